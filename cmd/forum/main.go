@@ -1,15 +1,22 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/mhcoffin/forum-tools/pkg/archive"
 	"github.com/mhcoffin/forum-tools/pkg/forum"
+	"github.com/mhcoffin/forum-tools/pkg/importer"
+	"github.com/mhcoffin/forum-tools/pkg/search"
 )
 
 /*
@@ -19,6 +26,8 @@ forum section create
 forum section list
 forum section update
 forum section delete
+forum section reorder -uid moderator id1,id2,...
+forum section hide -id section [-show]
 
 forum thread draft
 forum thread create
@@ -31,6 +40,24 @@ forum reply create
 forum reply update
 forum reply delete
 
+forum search -q query
+forum search -rebuild
+
+forum audit -uid moderator [-actor uid] [-user uid] [-target postID] [-since date] [-until date]
+
+forum webhook -create -uid moderator -url URL -secret secret [-events added,edited,deleted] [-sections id1,id2] [-threads]
+forum webhook -list -uid moderator
+forum webhook -delete -uid moderator -id webhook
+forum webhook -history -uid moderator -id webhook [-status pending|delivered|dead]
+forum webhook -retry -uid moderator -id delivery
+forum webhook -deliver [-interval 10s]
+
+forum export-html -out dir [-site title] [-sections id1,id2] [-templates dir] [-force]
+
+forum export [-o posts.jsonl]
+forum import [-i posts.jsonl] [-conflict fail|skip|overwrite] [-skip-orphans] [-dry-run]
+forum import-dump -uid moderator [-i dump.jsonl] [-report report.json] [-previous report.json] [-users prefix] [-conflict fail|skip|overwrite] [-skip-orphans] [-dry-run]
+
 args:
 	-f sectionId
 	-t topicID
@@ -41,16 +68,24 @@ args:
 */
 
 var (
-	section       = flag.NewFlagSet("section", flag.ExitOnError)
-	createSection = section.Bool("create", false, "create")
-	listSection   = section.Bool("list", false, "read")
-	updateSection = section.Bool("update", false, "update")
-	deleteSection = section.Bool("delete", false, "delete")
-	sectionTitle  = section.String("title", "", "title")
-	sectionIndex  = section.Int("index", -1, "index")
-	sectionUid    = section.String("uid", "", "user ID")
-	sectionID     = section.String("id", "", "section ID")
-	sectionReason = section.String("reason", "", "delete reason")
+	section        = flag.NewFlagSet("section", flag.ExitOnError)
+	createSection  = section.Bool("create", false, "create")
+	listSection    = section.Bool("list", false, "read")
+	updateSection  = section.Bool("update", false, "update")
+	deleteSection  = section.Bool("delete", false, "delete")
+	reorderSection = section.Bool("reorder", false, "reorder sections listed as arguments")
+	hideSection    = section.Bool("hide", false, "hide or, with -show, show a section")
+	sectionShow    = section.Bool("show", false, "with -hide, show the section again")
+	sectionDesc    = section.String("desc", "", "description")
+	sectionParent  = section.String("parent", "", "parent section ID, for a sub-section")
+	sectionVis     = section.String("visibility", "", "who may read the section: members or invite (default public)")
+	sectionTitle   = section.String("title", "", "title")
+	sectionIndex   = section.Int("index", -1, "index")
+	sectionUid     = section.String("uid", "", "user ID")
+	sectionID      = section.String("id", "", "section ID")
+	sectionReason  = section.String("reason", "", "delete reason")
+	sectionQA      = section.Bool("qa", false, "questions and answers section")
+	sectionMod     = section.Bool("moderated", false, "new posts need a moderator's approval")
 
 	thread            = flag.NewFlagSet("thread", flag.ExitOnError)
 	threadCreate      = thread.Bool("create", false, "create a thread")
@@ -77,6 +112,67 @@ var (
 	replyDisplayName = reply.String("display", "", "display name of poster")
 	replyPath        = reply.String("path", "", "parent path")
 
+	find        = flag.NewFlagSet("search", flag.ExitOnError)
+	findQuery   = find.String("q", "", "search query")
+	findRebuild = find.Bool("rebuild", false, "rebuild the search index")
+	findIndex   = find.String("index", "forum.idx", "search index file")
+	findSection = find.String("section", "", "only posts in this section")
+	findAuthor  = find.String("uid", "", "only posts by this user")
+	findSince   = find.String("since", "", "only posts created on or after this date (YYYY-MM-DD)")
+	findUntil   = find.String("until", "", "only posts created before this date (YYYY-MM-DD)")
+	findCount   = find.Int("n", 20, "number of results")
+
+	audit       = flag.NewFlagSet("audit", flag.ExitOnError)
+	auditUid    = audit.String("uid", "", "user ID of moderator")
+	auditActor  = audit.String("actor", "", "only actions by this user")
+	auditTarget = audit.String("target", "", "only actions on this post or below it")
+	auditUser   = audit.String("user", "", "only sanctions and membership changes of this user")
+	auditSince  = audit.String("since", "", "only actions on or after this date (YYYY-MM-DD)")
+	auditUntil  = audit.String("until", "", "only actions before this date (YYYY-MM-DD)")
+
+	webhook         = flag.NewFlagSet("webhook", flag.ExitOnError)
+	webhookCreate   = webhook.Bool("create", false, "create a webhook")
+	webhookList     = webhook.Bool("list", false, "list webhooks")
+	webhookDelete   = webhook.Bool("delete", false, "delete a webhook")
+	webhookHistory  = webhook.Bool("history", false, "list a webhook's deliveries")
+	webhookRetry    = webhook.Bool("retry", false, "send a dead delivery again")
+	webhookDeliver  = webhook.Bool("deliver", false, "deliver webhooks until interrupted")
+	webhookUid      = webhook.String("uid", "", "user ID of moderator")
+	webhookID       = webhook.String("id", "", "ID of webhook, or of delivery with -retry")
+	webhookURL      = webhook.String("url", "", "URL to send events to")
+	webhookSecret   = webhook.String("secret", "", "key for signing deliveries")
+	webhookEvents   = webhook.String("events", "", "comma-separated events to send (default all)")
+	webhookSections = webhook.String("sections", "", "comma-separated sections to send events from (default all)")
+	webhookThreads  = webhook.Bool("threads", false, "send events about threads only, not replies")
+	webhookStatus   = webhook.String("status", "", "with -history, only deliveries with this status")
+	webhookInterval = webhook.Duration("interval", 10*time.Second, "with -deliver, how often to look for deliveries")
+
+	exportHTML      = flag.NewFlagSet("export-html", flag.ExitOnError)
+	exportOut       = exportHTML.String("out", "", "directory to write the archive to")
+	exportSite      = exportHTML.String("site", "Forum archive", "title of the archive")
+	exportSections  = exportHTML.String("sections", "", "comma-separated sections to archive, with their sub-sections (default all)")
+	exportTemplates = exportHTML.String("templates", "", "directory of templates overriding the defaults")
+	exportThreads   = exportHTML.Int("threads-per-page", 50, "threads on each page of a section")
+	exportReplies   = exportHTML.Int("replies-per-page", 20, "top-level replies on each page of a thread")
+	exportForce     = exportHTML.Bool("force", false, "regenerate every page, not just those bumped since the last run")
+
+	export    = flag.NewFlagSet("export", flag.ExitOnError)
+	exportTo  = export.String("o", "", "file to write posts to (default stdout)")
+	imports   = flag.NewFlagSet("import", flag.ExitOnError)
+	importIn  = imports.String("i", "", "file to read posts from (default stdin)")
+	importDry = imports.Bool("dry-run", false, "check the posts without importing them")
+	conflict  = imports.String("conflict", "fail", "what to do with posts that already exist: fail, skip or overwrite")
+	orphans   = imports.Bool("skip-orphans", false, "leave out posts whose parent was expunged instead of failing")
+
+	importDump   = flag.NewFlagSet("import-dump", flag.ExitOnError)
+	dumpIn       = importDump.String("i", "", "file to read the dump from (default stdin)")
+	dumpUid      = importDump.String("uid", "", "user ID of moderator, recorded as the author of sections")
+	dumpReport   = importDump.String("report", "", "file to write the report of old and new IDs to (default stdout)")
+	dumpPrevious = importDump.String("previous", "", "report of an earlier import of the same dump, whose IDs are reused")
+	dumpUsers    = importDump.String("users", importer.DefaultUserPrefix, "prefix of the IDs of imported users")
+	dumpDry      = importDump.Bool("dry-run", false, "check the dump without importing it")
+	dumpConflict = importDump.String("conflict", "fail", "what to do with posts that already exist: fail, skip or overwrite")
+
 	sectionId = flag.String("f", "", "section ID")
 	threadId  = flag.String("t", "", "thread ID")
 	replyId   = flag.String("r", "", "reply ID")
@@ -108,6 +204,20 @@ func main() {
 		Thread()
 	case "reply":
 		Replies()
+	case "search":
+		Search()
+	case "audit":
+		Audit()
+	case "webhook":
+		Webhook()
+	case "export-html":
+		ExportHTML()
+	case "export":
+		Export()
+	case "import":
+		Import()
+	case "import-dump":
+		ImportDump()
 	default:
 		log.Fatalf("No such subcommand: %s\n", flag.Arg(0))
 	}
@@ -127,6 +237,10 @@ func Section() {
 		UpdateSection()
 	case *deleteSection:
 		DeleteSection()
+	case *reorderSection:
+		ReorderSections()
+	case *hideSection:
+		HideSection()
 	default:
 		log.Fatalf("no such subcommand: %s", flag.Arg(1))
 	}
@@ -136,27 +250,55 @@ func DeleteSection() {
 	if *sectionID == "" || *sectionUid == "" || *sectionReason == "" {
 		log.Fatal("-id, -uid, and -reason required")
 	}
-	err := fm.DeleteSection(ctx, *sectionID, *sectionUid, *sectionReason)
+	err := fm.DeleteSection(ctx, *sectionID, forum.User{ID: *sectionUid}, *sectionReason)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
 func UpdateSection() {
-	if *sectionId == "" {
-		log.Fatal("-f required")
+	if *sectionID == "" || *sectionTitle == "" || *sectionIndex == -1 || *sectionUid == "" {
+		log.Fatal("-id, -title, -index, and -uid required")
 	}
-	if *subject == "" && *body == "" && *sectionIndex == -1 {
-		log.Fatal("-s, -b, or -i required")
+	err := fm.UpdateSection(ctx, *sectionID, *sectionTitle, *sectionDesc, *sectionIndex, forum.User{ID: *sectionUid})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func ReorderSections() {
+	if *sectionUid == "" || section.NArg() != 1 {
+		log.Fatal("-uid and a comma-separated list of section IDs required")
+	}
+	err := fm.ReorderSections(ctx, strings.Split(section.Arg(0), ","), forum.User{ID: *sectionUid})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func HideSection() {
+	if *sectionID == "" || *sectionUid == "" {
+		log.Fatal("-id and -uid required")
+	}
+	err := fm.HideSection(ctx, *sectionID, !*sectionShow, forum.User{ID: *sectionUid})
+	if err != nil {
+		log.Fatal(err)
 	}
-	panic("implement")
 }
 
 func CreateSection() {
 	if *sectionTitle == "" || *sectionIndex == -1 || *sectionUid == "" {
 		log.Fatal("-title, -index, and -uid required")
 	}
-	id, err := fm.CreateSection(ctx, *sectionTitle, "", *sectionIndex, *sectionUid)
+	opts := forum.SectionOptions{QA: *sectionQA, Moderated: *sectionMod, Visibility: forum.Visibility(*sectionVis)}
+	author := forum.User{ID: *sectionUid}
+	var id []string
+	var err error
+	if *sectionParent != "" {
+		id, err = fm.CreateSubsection(ctx, *sectionParent, *sectionTitle, *sectionDesc, *sectionIndex, author, opts)
+	} else {
+		id, err = fm.CreateSection(ctx, *sectionTitle, *sectionDesc, *sectionIndex, author, opts)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -164,12 +306,21 @@ func CreateSection() {
 }
 
 func ListSections() {
-	posts, err := fm.GetSections(ctx)
+	view := forum.View{}
+	if *sectionUid != "" {
+		view.Viewer = &forum.User{ID: *sectionUid}
+	}
+	sections, err := fm.GetSections(ctx, view)
 	if err != nil {
 		panic(err)
 	}
-	for _, post := range posts {
-		fmt.Printf("%s %s\n", post.ID(), post.Header)
+	printSections(sections, "")
+}
+
+func printSections(sections []*forum.SectionNode, indent string) {
+	for _, s := range sections {
+		fmt.Printf("%s%s %s\n", indent, s.ID(), s.Head)
+		printSections(s.Subsections, indent+"  ")
 	}
 }
 
@@ -193,10 +344,11 @@ func Replies() {
 }
 
 func CreateReply() {
-	if *replyUid == "" || *replyBody == "" || *replyDisplayName == "" || *replyPath == ""  || *replyHeader == ""{
+	if *replyUid == "" || *replyBody == "" || *replyDisplayName == "" || *replyPath == "" || *replyHeader == "" {
 		log.Fatal("-uid, -body, -display, -path required")
 	}
-	_, err := fm.CreateReply(ctx, strings.Split(*replyPath, "/"), *replyHeader, *replyBody, *replyUid, *replyDisplayName)
+	author := forum.User{ID: *replyUid, Name: *replyDisplayName}
+	_, err := fm.CreateReply(ctx, strings.Split(*replyPath, "/"), *replyHeader, *replyBody, author)
 	if err != nil {
 		log.Fatal(fmt.Errorf("create reply failed: %w", err))
 	}
@@ -260,7 +412,8 @@ func CreateThread() {
 	if *threadSection == "" || *threadSubject == "" || *threadBody == "" || *threadUid == "" || *threadDisplayName == "" {
 		log.Fatal("-section, -subject, -body, and -uid required")
 	}
-	hash, err := fm.CreateThread(ctx, *threadSubject, *threadBody, *threadUid, *threadDisplayName, *threadSection)
+	author := forum.User{ID: *threadUid, Name: *threadDisplayName}
+	hash, err := fm.CreateThread(ctx, *threadSubject, *threadBody, author, *threadSection, forum.ThreadOptions{})
 	if err != nil {
 		log.Fatal(fmt.Errorf("failed to create draft: %w", err))
 	}
@@ -268,10 +421,10 @@ func CreateThread() {
 }
 
 func UpdateThread() {
-	if *sectionId == "" || *threadId == "" || *body == "" {
-		log.Fatal("-f and -b are required")
+	if *threadId == "" || *subject == "" || *body == "" || *uid == "" {
+		log.Fatal("-t, -s, -b and -u are required")
 	}
-	err := fm.UpdateThread(ctx, *sectionId, *threadId, *body)
+	err := fm.UpdateThread(ctx, *threadId, *subject, *body, forum.User{ID: *uid}, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -281,7 +434,7 @@ func DeleteThread() {
 	if *threadID == "" || *threadUid == "" || *threadReason == "" {
 		log.Fatal("-id, -uid and -reason are required")
 	}
-	err := fm.DeleteThread(ctx, *threadID, *threadUid, *threadReason)
+	err := fm.DeleteThread(ctx, *threadID, forum.User{ID: *threadUid}, *threadReason)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -296,6 +449,308 @@ func ListThreads() {
 		log.Fatal(err)
 	}
 	for _, topic := range topics {
-		fmt.Printf("%s %s\n", strings.Join(topic.Path, "/"), topic.Header)
+		fmt.Printf("%s %s\n", strings.Join(topic.Path, "/"), topic.Head)
+	}
+}
+
+func Search() {
+	err := find.Parse(os.Args[2:])
+	if err != nil {
+		log.Fatalf("failed to parse search flags: %s", err)
+	}
+	if *findRebuild {
+		RebuildIndex()
+		return
+	}
+	if *findQuery == "" {
+		log.Fatal("-q or -rebuild required")
+	}
+	file, err := os.Open(*findIndex)
+	if err != nil {
+		log.Fatalf("failed to open search index (try -rebuild): %s", err)
+	}
+	defer file.Close()
+	index, err := search.Load(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fm.SetSearchIndex(index)
+	query := search.Query{
+		Text:    *findQuery,
+		Section: *findSection,
+		Author:  *findAuthor,
+		Since:   parseDate(*findSince),
+		Until:   parseDate(*findUntil),
+	}
+	posts, _, err := fm.Search(ctx, query, nil, *findCount, forum.View{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, post := range posts {
+		fmt.Printf("%s %s\n", strings.Join(post.Path, "/"), post.Head)
+	}
+}
+
+func RebuildIndex() {
+	index, err := fm.RebuildSearchIndex(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	file, err := os.Create(*findIndex)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	if err := index.Save(file); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("indexed %d posts\n", index.Len())
+}
+
+func parseDate(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		log.Fatalf("bad date %q: %s", s, err)
+	}
+	return t
+}
+
+// Audit writes the audit log to stdout as JSON Lines.
+func Audit() {
+	err := audit.Parse(os.Args[2:])
+	if err != nil {
+		log.Fatalf("failed to parse audit flags: %s", err)
+	}
+	if *auditUid == "" {
+		log.Fatal("-uid required")
+	}
+	query := forum.AuditQuery{
+		Actor:   *auditActor,
+		Subject: *auditUser,
+		Target:  *auditTarget,
+		Since:   parseDate(*auditSince),
+		Until:   parseDate(*auditUntil),
+	}
+	err = fm.ExportAuditLog(ctx, os.Stdout, query, forum.User{ID: *auditUid})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func Webhook() {
+	err := webhook.Parse(os.Args[2:])
+	if err != nil {
+		log.Fatalf("failed to parse webhook flags: %s", err)
+	}
+	if *webhookDeliver {
+		client := &http.Client{Timeout: 30 * time.Second}
+		fm.RunWebhookWorker(ctx, client, *webhookInterval, func(err error) {
+			log.Print(err)
+		})
+		return
+	}
+	if *webhookUid == "" {
+		log.Fatal("-uid required")
+	}
+	moderator := forum.User{ID: *webhookUid}
+	switch {
+	case *webhookCreate:
+		hook := forum.Webhook{URL: *webhookURL, Secret: *webhookSecret, ThreadsOnly: *webhookThreads}
+		for _, event := range split(*webhookEvents) {
+			hook.Events = append(hook.Events, forum.EventKind(event))
+		}
+		hook.Sections = split(*webhookSections)
+		id, err := fm.CreateWebhook(ctx, hook, moderator)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(id)
+	case *webhookList:
+		hooks, err := fm.GetWebhooks(ctx, moderator)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, hook := range hooks {
+			fmt.Printf("%s %s events=%v sections=%v\n", hook.ID, hook.URL, hook.Events, hook.Sections)
+		}
+	case *webhookDelete:
+		if err := fm.DeleteWebhook(ctx, *webhookID, moderator); err != nil {
+			log.Fatal(err)
+		}
+	case *webhookHistory:
+		status := forum.DeliveryStatus(*webhookStatus)
+		deliveries, err := fm.GetDeliveries(ctx, *webhookID, status, moderator, 100)
+		if err != nil {
+			log.Fatal(err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		for _, d := range deliveries {
+			if err := enc.Encode(d); err != nil {
+				log.Fatal(err)
+			}
+		}
+	case *webhookRetry:
+		if err := fm.RetryDelivery(ctx, *webhookID, moderator); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatal("one of -create, -list, -delete, -history, -retry or -deliver required")
+	}
+}
+
+// split splits a comma-separated list, returning nil for "".
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// ExportHTML writes a static HTML archive of the forum, or brings one up to date.
+func ExportHTML() {
+	err := exportHTML.Parse(os.Args[2:])
+	if err != nil {
+		log.Fatalf("failed to parse export-html flags: %s", err)
+	}
+	if *exportOut == "" {
+		log.Fatal("-out required")
+	}
+	opts := archive.Options{
+		Dir:            *exportOut,
+		Site:           *exportSite,
+		Sections:       split(*exportSections),
+		Templates:      *exportTemplates,
+		ThreadsPerPage: *exportThreads,
+		RepliesPerPage: *exportReplies,
+		Force:          *exportForce,
+	}
+	stats, err := archive.Generate(ctx, fm, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("archived %d sections and %d threads (%d unchanged), writing %d pages and removing %d\n",
+		stats.Sections, stats.Threads, stats.Skipped, stats.Pages, stats.Removed)
+}
+
+// Export writes every post as JSON Lines.
+func Export() {
+	err := export.Parse(os.Args[2:])
+	if err != nil {
+		log.Fatalf("failed to parse export flags: %s", err)
+	}
+	out := os.Stdout
+	if *exportTo != "" {
+		out, err = os.Create(*exportTo)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	w := bufio.NewWriter(out)
+	if err := fm.Export(ctx, w); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Import reads posts written by Export.
+func Import() {
+	err := imports.Parse(os.Args[2:])
+	if err != nil {
+		log.Fatalf("failed to parse import flags: %s", err)
+	}
+	opts := forum.ImportOptions{DryRun: *importDry, OnConflict: conflictPolicy(*conflict), SkipOrphans: *orphans}
+	in := os.Stdin
+	if *importIn != "" {
+		in, err = os.Open(*importIn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer in.Close()
+	}
+	stats, err := fm.Import(ctx, bufio.NewReader(in), opts)
+	if stats != nil {
+		fmt.Printf("read %d posts: %d created, %d overwritten, %d skipped, %d orphans left out; rebuilt counters of %d\n",
+			stats.Read, stats.Created, stats.Overwritten, stats.Skipped, stats.Orphans, stats.Rebuilt)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func conflictPolicy(s string) forum.ConflictPolicy {
+	switch s {
+	case "fail":
+		return forum.ConflictFail
+	case "skip":
+		return forum.ConflictSkip
+	case "overwrite":
+		return forum.ConflictOverwrite
+	}
+	log.Fatalf("bad -conflict %q", s)
+	return forum.ConflictFail
+}
+
+func ImportDump() {
+	err := importDump.Parse(os.Args[2:])
+	if err != nil {
+		log.Fatalf("failed to parse import-dump flags: %s", err)
+	}
+	if *dumpUid == "" {
+		log.Fatal("-uid required")
+	}
+	opts := importer.Options{
+		UserPrefix: *dumpUsers,
+		Moderator:  forum.User{ID: *dumpUid},
+		OnConflict: conflictPolicy(*dumpConflict),
+		DryRun:     *dumpDry,
+	}
+	if *dumpPrevious != "" {
+		data, err := ioutil.ReadFile(*dumpPrevious)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Previous = &importer.Report{}
+		if err := json.Unmarshal(data, opts.Previous); err != nil {
+			log.Fatalf("failed to read %s: %s", *dumpPrevious, err)
+		}
+	}
+	in := os.Stdin
+	if *dumpIn != "" {
+		in, err = os.Open(*dumpIn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer in.Close()
+	}
+	out := os.Stdout
+	if *dumpReport != "" {
+		out, err = os.Create(*dumpReport)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer out.Close()
+	}
+	report, importErr := importer.Import(ctx, fm, bufio.NewReader(in), opts)
+	// Write the report even if the import failed part way, so it can be resumed.
+	if report != nil {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+		for _, w := range report.Warnings {
+			log.Printf("warning: %s", w)
+		}
+	}
+	if importErr != nil {
+		log.Fatal(importErr)
 	}
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/mhcoffin/forum-tools/pkg/auth"
+	"github.com/mhcoffin/forum-tools/pkg/forum"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Error codes returned in the body of every failed request. Clients should switch on the
+// code rather than on the message, which is meant for people.
+const (
+	codeBadRequest      = "bad_request"
+	codeUnauthenticated = "unauthenticated"
+	codeForbidden       = "forbidden"
+	codeNotFound        = "not_found"
+	codeNotAllowed      = "method_not_allowed"
+	codeConflict        = "conflict"
+	codeTooLarge        = "too_large"
+	codeRejected        = "rejected"
+	codeRateLimited     = "rate_limited"
+	codeInternal        = "internal"
+)
+
+// apiError is the body of a failed request: {"error": {"code": ..., "message": ...}}.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// requestError is an error in the request itself, detected by the server rather than
+// by the forum.
+type requestError struct {
+	status int
+	code   string
+	msg    string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+func badRequest(msg string) error {
+	return &requestError{status: http.StatusBadRequest, code: codeBadRequest, msg: msg}
+}
+
+var (
+	errUnauthenticated = &requestError{status: http.StatusUnauthorized, code: codeUnauthenticated, msg: "sign in required"}
+	errTooLarge        = &requestError{status: http.StatusRequestEntityTooLarge, code: codeTooLarge, msg: "request body too large"}
+	errNoRoute         = &requestError{status: http.StatusNotFound, code: codeNotFound, msg: "no such endpoint"}
+)
+
+// classify maps an error to an HTTP status and error code.
+func classify(err error) (int, string) {
+	var re *requestError
+	var sanction *forum.SanctionError
+	var rate *forum.RateLimitError
+	var grpc interface{ GRPCStatus() *status.Status }
+	switch {
+	case errors.As(err, &re):
+		return re.status, re.code
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		return http.StatusUnauthorized, codeUnauthenticated
+	case errors.As(err, &rate):
+		return http.StatusTooManyRequests, codeRateLimited
+	case errors.As(err, &sanction),
+		errors.Is(err, forum.ErrNotPermitted),
+		errors.Is(err, forum.ErrBlocked),
+		errors.Is(err, forum.ErrNotParticipant):
+		return http.StatusForbidden, codeForbidden
+	case errors.Is(err, forum.ErrNotFound),
+		errors.Is(err, forum.ErrNoDraft):
+		return http.StatusNotFound, codeNotFound
+	case errors.Is(err, forum.ErrBadCursor),
+		errors.Is(err, forum.ErrInvalid):
+		return http.StatusBadRequest, codeBadRequest
+	case errors.Is(err, forum.ErrLocked),
+		errors.Is(err, forum.ErrDeleted),
+		errors.Is(err, forum.ErrNotPending),
+		errors.Is(err, forum.ErrAlreadyReported),
+		errors.Is(err, forum.ErrNotQA):
+		return http.StatusConflict, codeConflict
+	case errors.Is(err, forum.ErrRejected):
+		return http.StatusUnprocessableEntity, codeRejected
+	case errors.As(err, &grpc) && grpc.GRPCStatus().Code() == codes.NotFound:
+		return http.StatusNotFound, codeNotFound
+	}
+	return http.StatusInternalServerError, codeInternal
+}
+
+// writeError sends err to the client as a JSON error body. Internal errors are logged
+// and replaced by a generic message, since they may reveal more than the client should
+// see.
+func writeError(w http.ResponseWriter, err error) {
+	code, name := classify(err)
+	msg := err.Error()
+	if code == http.StatusInternalServerError {
+		log.Printf("internal error: %s", err)
+		msg = "internal error"
+	}
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	var rate *forum.RateLimitError
+	if errors.As(err, &rate) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rate.RetryAfter.Seconds()))))
+	}
+	writeJSON(w, code, struct {
+		Error apiError `json:"error"`
+	}{apiError{Code: name, Message: msg}})
+}
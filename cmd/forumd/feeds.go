@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mhcoffin/forum-tools/pkg/feed"
+	"github.com/mhcoffin/forum-tools/pkg/forum"
+)
+
+// feedSize is how many posts a feed holds.
+const feedSize = 50
+
+// serveFeed writes posts as a feed, in the format named by the format query parameter.
+func (s *server) serveFeed(w http.ResponseWriter, r *http.Request, title string, path string, posts []*forum.Post) error {
+	format, err := feed.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	f := feed.New(s.site, title, path, posts)
+	f.Self = strings.TrimSuffix(s.site.URL, "/") + r.URL.RequestURI()
+	f.Private = user(r) != nil
+	return f.Serve(w, r, format)
+}
+
+// sectionFeed serves the newest threads in a section.
+func (s *server) sectionFeed(w http.ResponseWriter, r *http.Request, args []string) error {
+	section, err := s.forum.GetPost(r.Context(), args[0], view(r))
+	if err != nil {
+		return err
+	}
+	threads, _, err := s.forum.GetThreads(r.Context(), args[0], &forum.CreateTimeDesc{}, feedSize, view(r))
+	if err != nil {
+		return err
+	}
+	return s.serveFeed(w, r, s.site.Title+": "+section.Head, "sections/"+args[0], threads)
+}
+
+// threadFeed serves a thread and its newest replies.
+func (s *server) threadFeed(w http.ResponseWriter, r *http.Request, args []string) error {
+	thread, err := s.forum.GetPost(r.Context(), args[0], view(r))
+	if err != nil {
+		return err
+	}
+	posts, _, err := s.forum.GetReplies(r.Context(), args[0], &forum.CreateTimeDesc{}, feedSize, view(r))
+	if err != nil {
+		return err
+	}
+	return s.serveFeed(w, r, thread.Head, "threads/"+args[0], posts)
+}
+
+// userFeed serves the newest posts by a user.
+func (s *server) userFeed(w http.ResponseWriter, r *http.Request, args []string) error {
+	posts, _, err := s.forum.GetPostsByAuthor(r.Context(), args[0], nil, feedSize, view(r))
+	if err != nil {
+		return err
+	}
+	name := args[0]
+	if len(posts) > 0 && posts[0].Author.Name != "" {
+		name = posts[0].Author.Name
+	}
+	return s.serveFeed(w, r, "Posts by "+name, "users/"+args[0], posts)
+}
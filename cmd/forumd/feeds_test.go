@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// atomFeed is the part of an Atom feed the tests look at.
+type atomFeed struct {
+	Title   string `xml:"title"`
+	Entries []struct {
+		ID    string `xml:"id"`
+		Title string `xml:"title"`
+	} `xml:"entry"`
+}
+
+// getFeed fetches a feed, sending etag if it isn't "", and returns the response.
+func getFeed(t *testing.T, srv *httptest.Server, path string, etag string) (*http.Response, []byte) {
+	req, err := http.NewRequest("GET", srv.URL+path, nil)
+	require.Nil(t, err)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := srv.Client().Do(req)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	return resp, body
+}
+
+func TestServer_Feeds(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	section := createSection(t, srv)
+	var thread pathResponse
+	code := call(t, srv, "POST", "/sections/"+section+"/threads", ella, &postRequest{Subject: "Hi", Body: "<p>Hello</p>"}, &thread)
+	require.Equal(t, http.StatusCreated, code)
+	var reply pathResponse
+	code = call(t, srv, "POST", "/posts/"+thread.Path[1]+"/replies", admin, &postRequest{Subject: "Hi", Body: "Hello back"}, &reply)
+	require.Equal(t, http.StatusCreated, code)
+
+	resp, body := getFeed(t, srv, "/sections/"+section+"/feed", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var f atomFeed
+	require.Nil(t, xml.Unmarshal(body, &f))
+	assert.Equal(t, "Forum: General", f.Title)
+	require.Len(t, f.Entries, 1)
+	assert.Equal(t, "tag:localhost,2020:posts/"+section+"/"+thread.Path[1], f.Entries[0].ID)
+
+	resp, body = getFeed(t, srv, "/threads/"+thread.Path[1]+"/feed", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Nil(t, xml.Unmarshal(body, &f))
+	require.Len(t, f.Entries, 2)
+	assert.Equal(t, "Re: Hi", f.Entries[0].Title)
+	etag := resp.Header.Get("ETag")
+	resp, _ = getFeed(t, srv, "/threads/"+thread.Path[1]+"/feed", etag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	resp, body = getFeed(t, srv, "/users/"+ella+"/feed?format=rss", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/rss+xml; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), "&lt;p&gt;Hello&lt;/p&gt;")
+
+	var e errorBody
+	assert.Equal(t, http.StatusBadRequest, call(t, srv, "GET", "/users/"+ella+"/feed?format=json", "", nil, &e))
+	assert.Equal(t, http.StatusNotFound, call(t, srv, "GET", "/threads/nonexistent/feed", "", nil, &e))
+}
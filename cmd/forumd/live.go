@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mhcoffin/forum-tools/pkg/forum"
+	"golang.org/x/net/websocket"
+)
+
+// Defaults for live updates.
+const (
+	defaultHeartbeat = 30 * time.Second
+	defaultQueue     = 256
+	writeTimeout     = 10 * time.Second
+)
+
+// errSlowClient ends a stream whose client isn't reading fast enough. It can reconnect
+// and resume from the last event it received.
+var errSlowClient = errors.New("client fell behind")
+
+// liveEvent is an event as sent to clients. Its ID resumes the stream after it.
+type liveEvent struct {
+	ID    string          `json:"id"`
+	Kind  forum.EventKind `json:"kind"`
+	Post  *forum.Post     `json:"post,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// eventID returns the time of an event and the ID of its post, separated by a slash.
+func eventID(e *forum.Event) string {
+	return e.Time.UTC().Format(time.RFC3339Nano) + "/" + e.Post.ID()
+}
+
+// since parses the ID of the last event a client saw, or returns the zero Position if
+// it hasn't seen any.
+func since(id string) (forum.Position, error) {
+	if id == "" {
+		return forum.Position{}, nil
+	}
+	var pos forum.Position
+	if k := strings.Index(id, "/"); k >= 0 {
+		id, pos.Post = id[:k], id[k+1:]
+	}
+	t, err := time.Parse(time.RFC3339Nano, id)
+	if err != nil {
+		return forum.Position{}, badRequest("bad last event ID")
+	}
+	pos.Time = t
+	return pos, nil
+}
+
+// watchFunc starts watching a section or thread: (*forum.Forum).WatchSection or
+// (*forum.Forum).WatchThread.
+type watchFunc func(f *forum.Forum, ctx context.Context, id forum.PostID, since forum.Position, view forum.View) (*forum.Watch, error)
+
+// watch starts watching the section or thread named in a request's path, resuming
+// after the event whose ID is lastID.
+func (s *server) watch(r *http.Request, start watchFunc, id forum.PostID, lastID string) (*forum.Watch, error) {
+	from, err := since(lastID)
+	if err != nil {
+		return nil, err
+	}
+	return start(s.forum, r.Context(), id, from, view(r))
+}
+
+// relay passes events from a watch to send, and calls ping every heartbeat to keep the
+// connection open, until ctx is done or something fails. Events queue up while send is
+// blocked on a slow client. If too many queue up, relay gives up on the client with
+// errSlowClient rather than let the queue grow without limit, calling abort, if it isn't
+// nil, to unblock send.
+func (s *server) relay(ctx context.Context, w *forum.Watch, send func(*forum.Event) error, ping func() error, abort func()) error {
+	defer w.Stop()
+	queue := make(chan *forum.Event, s.queue)
+	overflow := make(chan struct{})
+	go func() {
+		defer close(queue)
+		for e := range w.C {
+			select {
+			case queue <- e:
+			default:
+				close(overflow)
+				w.Stop()
+				if abort != nil {
+					abort()
+				}
+				return
+			}
+		}
+	}()
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-queue:
+			if !ok {
+				select {
+				case <-overflow:
+					return errSlowClient
+				default:
+					return w.Err()
+				}
+			}
+			if err := send(e); err != nil {
+				select {
+				case <-overflow:
+					return errSlowClient
+				default:
+					return err
+				}
+			}
+		case <-heartbeat.C:
+			if err := ping(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// events returns a handler that streams changes to a section or thread as Server-Sent
+// Events. A client that reconnects with a Last-Event-ID header picks up where it left
+// off.
+func (s *server) events(start watchFunc) handler {
+	return func(w http.ResponseWriter, r *http.Request, args []string) error {
+		return s.serveEvents(w, r, start, args[0])
+	}
+}
+
+func (s *server) serveEvents(w http.ResponseWriter, r *http.Request, start watchFunc, id forum.PostID) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("streaming not supported")
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	watch, err := s.watch(r, start, id, lastID)
+	if err != nil {
+		return err
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	send := func(e *forum.Event) error {
+		data, err := json.Marshal(e.Post)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", eventID(e), e.Kind, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	ping := func() error {
+		if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	// There's no way to time out a write to a ResponseWriter, so a client that stops
+	// reading altogether holds on to its handler until the connection drops. It does let
+	// go of its watch, though.
+	err = s.relay(r.Context(), watch, send, ping, nil)
+	if err != nil {
+		// The response has started, so all we can do is tell the client and hang up.
+		log.Printf("event stream ended: %s", err)
+		fmt.Fprintf(w, "event: error\ndata: %q\n\n", err.Error())
+		flusher.Flush()
+	}
+	return nil
+}
+
+// socket returns a handler that streams changes to a section or thread over a
+// WebSocket, as JSON messages. A client resumes with the lastEventId query parameter.
+// Anything the client sends is ignored.
+func (s *server) socket(start watchFunc) handler {
+	return func(w http.ResponseWriter, r *http.Request, args []string) error {
+		return s.serveSocket(w, r, start, args[0])
+	}
+}
+
+func (s *server) serveSocket(w http.ResponseWriter, r *http.Request, start watchFunc, id forum.PostID) error {
+	watch, err := s.watch(r, start, id, r.URL.Query().Get("lastEventId"))
+	if err != nil {
+		return err
+	}
+	handler := func(ws *websocket.Conn) {
+		defer ws.Close()
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go func() {
+			// Reading is the only way to notice that the client has gone away.
+			var discard []byte
+			for websocket.Message.Receive(ws, &discard) == nil {
+			}
+			cancel()
+		}()
+		write := func(msg *liveEvent) error {
+			if err := ws.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+				return err
+			}
+			return websocket.JSON.Send(ws, msg)
+		}
+		send := func(e *forum.Event) error {
+			return write(&liveEvent{ID: eventID(e), Kind: e.Kind, Post: e.Post})
+		}
+		ping := func() error {
+			return write(&liveEvent{Kind: "ping"})
+		}
+		if err := s.relay(ctx, watch, send, ping, func() { ws.Close() }); err != nil {
+			log.Printf("websocket stream ended: %s", err)
+			_ = write(&liveEvent{Kind: "error", Error: err.Error()})
+		}
+	}
+	// Browsers send an Origin header, but the socket is authenticated like any other
+	// request, so there's nothing to check it against.
+	websocket.Server{Handler: handler}.ServeHTTP(w, r)
+	return nil
+}
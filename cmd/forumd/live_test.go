@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mhcoffin/forum-tools/pkg/forum"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func withBroker(s *server) {
+	s.forum.SetBroker(forum.NewBroker(100))
+}
+
+// sseEvent is one event read from a Server-Sent Events stream.
+type sseEvent struct {
+	id, kind, data string
+}
+
+// readEvents parses events from an SSE stream onto a channel, skipping comments.
+func readEvents(resp *http.Response) <-chan sseEvent {
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		var e sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if e.kind != "" {
+					events <- e
+				}
+				e = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				e.id = line[4:]
+			case strings.HasPrefix(line, "event: "):
+				e.kind = line[7:]
+			case strings.HasPrefix(line, "data: "):
+				e.data = line[6:]
+			}
+		}
+	}()
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	select {
+	case e, ok := <-events:
+		require.True(t, ok, "stream ended")
+		return e
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "no event")
+	}
+	return sseEvent{}
+}
+
+func openEvents(t *testing.T, srv *httptest.Server, path string, lastID string) *http.Response {
+	req, err := http.NewRequest("GET", srv.URL+path, nil)
+	require.Nil(t, err)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := srv.Client().Do(req)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return resp
+}
+
+func TestServer_Events(t *testing.T) {
+	srv := newTestServer(t, withBroker)
+	defer srv.Close()
+	section := createSection(t, srv)
+	var thread pathResponse
+	code := call(t, srv, "POST", "/sections/"+section+"/threads", ella, &postRequest{Subject: "Hi", Body: "Hello"}, &thread)
+	require.Equal(t, http.StatusCreated, code)
+
+	resp := openEvents(t, srv, "/threads/"+thread.Path[1]+"/events", "")
+	events := readEvents(resp)
+	var reply pathResponse
+	code = call(t, srv, "POST", "/posts/"+thread.Path[1]+"/replies", admin, &postRequest{Subject: "Re: Hi", Body: "Hello back"}, &reply)
+	require.Equal(t, http.StatusCreated, code)
+	added := nextEvent(t, events)
+	assert.Equal(t, "added", added.kind)
+	assert.Contains(t, added.data, "Hello back")
+	assert.Equal(t, "bumped", nextEvent(t, events).kind)
+	resp.Body.Close()
+
+	// Changes made while disconnected are delivered on reconnecting.
+	code = call(t, srv, "PUT", "/threads/"+thread.Path[1], ella, &postRequest{Subject: "Hi", Body: "Hello!"}, nil)
+	require.Equal(t, http.StatusNoContent, code)
+	resp = openEvents(t, srv, "/threads/"+thread.Path[1]+"/events", added.id)
+	defer resp.Body.Close()
+	events = readEvents(resp)
+	assert.Equal(t, "bumped", nextEvent(t, events).kind)
+	edited := nextEvent(t, events)
+	assert.Equal(t, "edited", edited.kind)
+	assert.Contains(t, edited.data, "Hello!")
+
+	var e errorBody
+	assert.Equal(t, http.StatusBadRequest, call(t, srv, "GET", "/threads/"+thread.Path[1]+"/events?lastEventId=yesterday", "", nil, &e))
+}
+
+func TestServer_Socket(t *testing.T) {
+	srv := newTestServer(t, withBroker, func(s *server) { s.heartbeat = 50 * time.Millisecond })
+	defer srv.Close()
+	section := createSection(t, srv)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/sections/" + section + "/ws"
+	ws, err := websocket.Dial(url, "", srv.URL)
+	require.Nil(t, err)
+	defer ws.Close()
+	var thread pathResponse
+	code := call(t, srv, "POST", "/sections/"+section+"/threads", ella, &postRequest{Subject: "Hi", Body: "Hello"}, &thread)
+	require.Equal(t, http.StatusCreated, code)
+
+	require.Nil(t, ws.SetReadDeadline(time.Now().Add(10*time.Second)))
+	var msg liveEvent
+	for msg.Kind == "" || msg.Kind == "ping" {
+		require.Nil(t, websocket.JSON.Receive(ws, &msg))
+	}
+	assert.Equal(t, forum.PostAdded, msg.Kind)
+	require.NotNil(t, msg.Post)
+	assert.Equal(t, thread.Path[1], msg.Post.ID())
+	assert.NotEmpty(t, msg.ID)
+
+	// With nothing happening, the server keeps the socket alive.
+	msg = liveEvent{}
+	require.Nil(t, websocket.JSON.Receive(ws, &msg))
+	assert.Equal(t, forum.EventKind("ping"), msg.Kind)
+}
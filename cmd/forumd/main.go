@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mhcoffin/forum-tools/pkg/auth"
+	"github.com/mhcoffin/forum-tools/pkg/feed"
+	"github.com/mhcoffin/forum-tools/pkg/forum"
+)
+
+/*
+HTTP server for the forum. Every endpoint takes and returns JSON.
+
+forumd [-addr :8080] [-project fugalist] [-max-body bytes]
+	[-firebase-certs certs.json] [-reload-certs 5m] [-tokens tokens.json] [-dev]
+	[-webhooks 10s] [-sanctions 1m]
+	[-url https://forum.example.com] [-title Forum] [-feed-tag example.com,2020]
+
+Requests are authenticated by a Firebase ID token or a static API token, sent as
+"Authorization: Bearer <token>". With -dev, the X-Forum-User header is trusted instead,
+which lets anyone claim to be anyone. The -firebase-certs file is reread at the
+-reload-certs interval, so whatever keeps it current as Google rotates its keys doesn't
+need to restart the server.
+
+Unless -webhooks is 0, the server also delivers webhooks, looking for due deliveries at
+that interval. Any number of servers may do so at once. Likewise, unless -sanctions is 0,
+it releases the posts hidden by shadow bans that have run out.
+
+GET    /sections
+POST   /sections
+GET    /sections/{id}/threads?cursor=&n=
+GET    /sections/{id}/events    (Server-Sent Events)
+GET    /sections/{id}/ws        (WebSocket)
+GET    /sections/{id}/feed?format=atom|rss
+POST   /sections/{id}/threads
+PUT    /threads/{id}
+GET    /threads/{id}/replies?cursor=&n=
+GET    /threads/{id}/events
+GET    /threads/{id}/ws
+GET    /threads/{id}/feed?format=
+GET    /posts/{id}
+DELETE /posts/{id}?reason=
+POST   /posts/{id}/replies
+POST   /posts/{id}/drafts
+GET    /drafts
+PUT    /drafts/{id}
+DELETE /drafts/{id}
+POST   /drafts/{id}/publish
+GET    /users/{id}/feed?format=
+
+Feeds link to posts under -url, and identify entries with tag: URIs under -feed-tag,
+which must never change.
+*/
+
+var (
+	addr    = flag.String("addr", ":8080", "address to listen on")
+	project = flag.String("project", "fugalist", "Firestore project ID")
+	maxBody = flag.Int64("max-body", 1<<20, "largest request body accepted, in bytes")
+	certs   = flag.String("firebase-certs", "", "JSON file of Firebase token signing certificates")
+	reload  = flag.Duration("reload-certs", 5*time.Minute, "how often to reread the Firebase certificates, or 0 not to")
+	tokens  = flag.String("tokens", "", "JSON file mapping static API tokens to users")
+	dev     = flag.Bool("dev", false, "trust the X-Forum-User header (insecure)")
+	hooks   = flag.Duration("webhooks", 10*time.Second, "how often to deliver webhooks, or 0 not to")
+	expiry  = flag.Duration("sanctions", time.Minute, "how often to end expired shadow bans, or 0 not to")
+	siteURL = flag.String("url", "http://localhost:8080", "public URL of the forum, for links in feeds")
+	title   = flag.String("title", "Forum", "title of the forum, for feeds")
+	feedTag = flag.String("feed-tag", "localhost,2020", "domain and date for feed entry IDs")
+)
+
+func main() {
+	flag.Parse()
+	fm, err := forum.NewClient(context.Background(), *project)
+	if err != nil {
+		log.Fatalf("failed to create forum client: %s", err)
+	}
+	authn, err := authenticator()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *hooks > 0 {
+		client := &http.Client{Timeout: 30 * time.Second}
+		go fm.RunWebhookWorker(context.Background(), client, *hooks, func(err error) {
+			log.Printf("webhook delivery failed: %s", err)
+		})
+	}
+	if *expiry > 0 {
+		go fm.RunSanctionExpiry(context.Background(), *expiry, func(err error) {
+			log.Printf("sanction expiry failed: %s", err)
+		})
+	}
+	s := newServer(fm, authn, *maxBody)
+	s.site = feed.Site{Title: *title, URL: *siteURL, Tag: *feedTag}
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, s))
+}
+
+// authenticator returns the authenticators selected by flags, in the order they are
+// tried.
+func authenticator() (auth.Authenticator, error) {
+	var chain auth.Chain
+	if *tokens != "" {
+		f, err := os.Open(*tokens)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tokens: %w", err)
+		}
+		defer f.Close()
+		static, err := auth.LoadStaticTokens(f)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, static)
+	}
+	if *certs != "" {
+		keys, err := readCertificates(*certs)
+		if err != nil {
+			return nil, err
+		}
+		fb := auth.NewFirebase(*project, keys)
+		if *reload > 0 {
+			go reloadCertificates(fb, *certs, *reload)
+		}
+		chain = append(chain, fb)
+	}
+	if *dev {
+		log.Printf("warning: trusting %s headers", auth.UserHeader)
+		chain = append(chain, auth.DevHeader{})
+	}
+	if len(chain) == 0 {
+		log.Printf("warning: no authentication configured; all requests are anonymous")
+	}
+	return chain, nil
+}
+
+func readCertificates(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificates: %w", err)
+	}
+	return auth.ParseCertificates(data)
+}
+
+// reloadCertificates rereads the certificates in path every interval so that keys
+// Google rotates in are picked up without a restart. Whatever keeps path current is
+// expected to follow the Cache-Control max-age Google publishes them with. If the
+// file can't be read, the keys already loaded stay in use.
+func reloadCertificates(fb *auth.Firebase, path string, interval time.Duration) {
+	for range time.Tick(interval) {
+		keys, err := readCertificates(path)
+		if err != nil {
+			log.Printf("failed to reload certificates: %s", err)
+			continue
+		}
+		fb.SetKeys(keys)
+	}
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhcoffin/forum-tools/pkg/auth"
+	"github.com/mhcoffin/forum-tools/pkg/feed"
+	"github.com/mhcoffin/forum-tools/pkg/forum"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// server routes requests to the forum.
+type server struct {
+	forum     *forum.Forum
+	auth      auth.Authenticator
+	maxBody   int64
+	heartbeat time.Duration // How often to ping live update streams
+	queue     int           // How many events a live update stream may fall behind
+	site      feed.Site     // Where feeds say they come from
+	routes    []route
+}
+
+// route handles requests with a method and path. A "*" in the pattern matches any one
+// path element, which is passed to the handler.
+type route struct {
+	method  string
+	pattern []string
+	handle  handler
+}
+
+type handler func(w http.ResponseWriter, r *http.Request, args []string) error
+
+func newServer(f *forum.Forum, authn auth.Authenticator, maxBody int64) *server {
+	s := &server{
+		forum:     f,
+		auth:      authn,
+		maxBody:   maxBody,
+		heartbeat: defaultHeartbeat,
+		queue:     defaultQueue,
+		site:      feed.Site{Title: "Forum", URL: "http://localhost:8080", Tag: "localhost,2020"},
+	}
+	s.routes = []route{
+		{"GET", []string{"sections"}, s.getSections},
+		{"POST", []string{"sections"}, s.createSection},
+		{"GET", []string{"sections", "*", "threads"}, s.getThreads},
+		{"GET", []string{"sections", "*", "events"}, s.events((*forum.Forum).WatchSection)},
+		{"GET", []string{"sections", "*", "ws"}, s.socket((*forum.Forum).WatchSection)},
+		{"GET", []string{"sections", "*", "feed"}, s.sectionFeed},
+		{"POST", []string{"sections", "*", "threads"}, s.createThread},
+		{"PUT", []string{"threads", "*"}, s.updateThread},
+		{"GET", []string{"threads", "*", "replies"}, s.getReplies},
+		{"GET", []string{"threads", "*", "events"}, s.events((*forum.Forum).WatchThread)},
+		{"GET", []string{"threads", "*", "ws"}, s.socket((*forum.Forum).WatchThread)},
+		{"GET", []string{"threads", "*", "feed"}, s.threadFeed},
+		{"GET", []string{"posts", "*"}, s.getPost},
+		{"DELETE", []string{"posts", "*"}, s.deletePost},
+		{"POST", []string{"posts", "*", "replies"}, s.createReply},
+		{"POST", []string{"posts", "*", "drafts"}, s.createDraft},
+		{"GET", []string{"drafts"}, s.getDrafts},
+		{"PUT", []string{"drafts", "*"}, s.updateDraft},
+		{"DELETE", []string{"drafts", "*"}, s.deleteDraft},
+		{"POST", []string{"drafts", "*", "publish"}, s.publishDraft},
+		{"GET", []string{"users", "*", "feed"}, s.userFeed},
+	}
+	return s
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var allowed []string
+	for _, rt := range s.routes {
+		args, ok := match(rt.pattern, parts)
+		if !ok {
+			continue
+		}
+		if rt.method != r.Method {
+			allowed = append(allowed, rt.method)
+			continue
+		}
+		r, err := s.authenticate(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := rt.handle(w, r, args); err != nil {
+			writeError(w, err)
+		}
+		return
+	}
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, &requestError{status: http.StatusMethodNotAllowed, code: codeNotAllowed, msg: r.Method + " not allowed"})
+		return
+	}
+	writeError(w, errNoRoute)
+}
+
+// authenticate returns r with the user making it, if any, in its context. Requests
+// without credentials are anonymous, but those with bad credentials are refused rather
+// than being quietly treated as anonymous.
+func (s *server) authenticate(r *http.Request) (*http.Request, error) {
+	u, err := s.auth.Authenticate(r)
+	if errors.Is(err, auth.ErrNoCredentials) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.WithContext(context.WithValue(r.Context(), userKey{}, u)), nil
+}
+
+func match(pattern []string, parts []string) ([]string, bool) {
+	if len(pattern) != len(parts) {
+		return nil, false
+	}
+	var args []string
+	for k, p := range pattern {
+		switch {
+		case p == "*" && parts[k] != "":
+			args = append(args, parts[k])
+		case p != parts[k]:
+			return nil, false
+		}
+	}
+	return args, true
+}
+
+type userKey struct{}
+
+// user returns the user making a request, or nil if it is anonymous.
+func user(r *http.Request) *forum.User {
+	u, _ := r.Context().Value(userKey{}).(*forum.User)
+	return u
+}
+
+// requireUser returns the user making a request, or errUnauthenticated if it is
+// anonymous.
+func requireUser(r *http.Request) (forum.User, error) {
+	u := user(r)
+	if u == nil {
+		return forum.User{}, errUnauthenticated
+	}
+	return *u, nil
+}
+
+func view(r *http.Request) forum.View {
+	return forum.View{Viewer: user(r), Collapse: r.URL.Query().Get("collapse") == "true"}
+}
+
+// decode reads a JSON request body into v, refusing bodies larger than s.maxBody.
+func (s *server) decode(r *http.Request, v interface{}) error {
+	if r.ContentLength > s.maxBody {
+		return errTooLarge
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, s.maxBody+1))
+	if err != nil {
+		return badRequest("failed to read request body")
+	}
+	if int64(len(body)) > s.maxBody {
+		return errTooLarge
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return badRequest("invalid JSON: " + err.Error())
+	}
+	return nil
+}
+
+// page returns the cursor and page size given by the cursor and n query parameters.
+// A cursor that allowed rejects was issued by some other listing and can't resume
+// this one.
+func page(r *http.Request, allowed func(forum.Cursor) bool) (forum.Cursor, int, error) {
+	q := r.URL.Query()
+	cursor, err := forum.DecodeCursor(q.Get("cursor"))
+	if err != nil {
+		return nil, 0, err
+	}
+	if cursor != nil && !allowed(cursor) {
+		return nil, 0, badRequest("cursor is not valid for this listing")
+	}
+	n := defaultPageSize
+	if s := q.Get("n"); s != "" {
+		n, err = strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			return nil, 0, badRequest("n must be between 1 and " + strconv.Itoa(maxPageSize))
+		}
+	}
+	return cursor, n, nil
+}
+
+// threadCursor reports whether c orders a section's threads.
+func threadCursor(c forum.Cursor) bool {
+	switch c.(type) {
+	case *forum.BumpTimeDesc, *forum.CreateTimeDesc, *forum.CreateTimeAsc:
+		return true
+	}
+	return false
+}
+
+// replyCursor reports whether c orders a thread's replies.
+func replyCursor(c forum.Cursor) bool {
+	switch c.(type) {
+	case *forum.CreateTimeAsc, *forum.CreateTimeDesc, *forum.ScoreDesc:
+		return true
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// postList is one page of a listing. Cursor is empty on the last page.
+type postList struct {
+	Items  []*forum.Post `json:"items"`
+	Cursor string        `json:"cursor,omitempty"`
+}
+
+func writePosts(w http.ResponseWriter, posts []*forum.Post, next forum.Cursor) {
+	if posts == nil {
+		posts = []*forum.Post{}
+	}
+	writeJSON(w, http.StatusOK, &postList{Items: posts, Cursor: forum.EncodeCursor(next)})
+}
+
+type pathResponse struct {
+	Path []forum.PostID `json:"path"`
+}
+
+func (s *server) getSections(w http.ResponseWriter, r *http.Request, _ []string) error {
+	sections, err := s.forum.GetSections(r.Context(), view(r))
+	if err != nil {
+		return err
+	}
+	if sections == nil {
+		sections = []*forum.SectionNode{}
+	}
+	writeJSON(w, http.StatusOK, sections)
+	return nil
+}
+
+type sectionRequest struct {
+	Title       string           `json:"title"`
+	Description string           `json:"description"`
+	Index       int              `json:"index"`
+	Parent      forum.PostID     `json:"parent"`
+	QA          bool             `json:"qa"`
+	CuratedTags bool             `json:"curatedTags"`
+	Moderated   bool             `json:"moderated"`
+	Visibility  forum.Visibility `json:"visibility"`
+}
+
+func (s *server) createSection(w http.ResponseWriter, r *http.Request, _ []string) error {
+	author, err := requireUser(r)
+	if err != nil {
+		return err
+	}
+	var req sectionRequest
+	if err := s.decode(r, &req); err != nil {
+		return err
+	}
+	if req.Title == "" {
+		return badRequest("title is required")
+	}
+	if err := s.requireModerator(r.Context(), author); err != nil {
+		return err
+	}
+	opts := forum.SectionOptions{
+		QA:          req.QA,
+		CuratedTags: req.CuratedTags,
+		Moderated:   req.Moderated,
+		Visibility:  req.Visibility,
+	}
+	var path []forum.PostID
+	if req.Parent == "" {
+		path, err = s.forum.CreateSection(r.Context(), req.Title, req.Description, req.Index, author, opts)
+	} else {
+		path, err = s.forum.CreateSubsection(r.Context(), req.Parent, req.Title, req.Description, req.Index, author, opts)
+	}
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusCreated, &pathResponse{Path: path})
+	return nil
+}
+
+func (s *server) requireModerator(ctx context.Context, u forum.User) error {
+	ok, err := s.forum.IsModerator(ctx, u)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return forum.ErrNotPermitted
+	}
+	return nil
+}
+
+func (s *server) getThreads(w http.ResponseWriter, r *http.Request, args []string) error {
+	cursor, n, err := page(r, threadCursor)
+	if err != nil {
+		return err
+	}
+	threads, next, err := s.forum.GetThreads(r.Context(), args[0], cursor, n, view(r))
+	if err != nil {
+		return err
+	}
+	writePosts(w, threads, next)
+	return nil
+}
+
+type postRequest struct {
+	Subject string   `json:"subject"`
+	Body    string   `json:"body"`
+	Tags    []string `json:"tags"`
+}
+
+func (s *server) createThread(w http.ResponseWriter, r *http.Request, args []string) error {
+	author, err := requireUser(r)
+	if err != nil {
+		return err
+	}
+	var req postRequest
+	if err := s.decode(r, &req); err != nil {
+		return err
+	}
+	if req.Subject == "" {
+		return badRequest("subject is required")
+	}
+	path, err := s.forum.CreateThread(r.Context(), req.Subject, req.Body, author, args[0], forum.ThreadOptions{Tags: req.Tags})
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusCreated, &pathResponse{Path: path})
+	return nil
+}
+
+// updateThread replaces a thread's subject and body, and its tags if the request has
+// any.
+func (s *server) updateThread(w http.ResponseWriter, r *http.Request, args []string) error {
+	editor, err := requireUser(r)
+	if err != nil {
+		return err
+	}
+	var req postRequest
+	if err := s.decode(r, &req); err != nil {
+		return err
+	}
+	if err := s.forum.UpdateThread(r.Context(), args[0], req.Subject, req.Body, editor, req.Tags); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *server) getReplies(w http.ResponseWriter, r *http.Request, args []string) error {
+	cursor, n, err := page(r, replyCursor)
+	if err != nil {
+		return err
+	}
+	replies, next, err := s.forum.GetReplies(r.Context(), args[0], cursor, n, view(r))
+	if err != nil {
+		return err
+	}
+	writePosts(w, replies, next)
+	return nil
+}
+
+func (s *server) getPost(w http.ResponseWriter, r *http.Request, args []string) error {
+	post, err := s.forum.GetPost(r.Context(), args[0], view(r))
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, post)
+	return nil
+}
+
+func (s *server) deletePost(w http.ResponseWriter, r *http.Request, args []string) error {
+	u, err := requireUser(r)
+	if err != nil {
+		return err
+	}
+	if err := s.forum.DeletePost(r.Context(), args[0], u, r.URL.Query().Get("reason")); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *server) createReply(w http.ResponseWriter, r *http.Request, args []string) error {
+	author, err := requireUser(r)
+	if err != nil {
+		return err
+	}
+	var req postRequest
+	if err := s.decode(r, &req); err != nil {
+		return err
+	}
+	parent, err := s.forum.GetPost(r.Context(), args[0], forum.View{Viewer: &author})
+	if err != nil {
+		return err
+	}
+	path, err := s.forum.CreateReply(r.Context(), parent.Path, req.Subject, req.Body, author)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusCreated, &pathResponse{Path: path})
+	return nil
+}
+
+type draftResponse struct {
+	ID string `json:"id"`
+}
+
+func (s *server) createDraft(w http.ResponseWriter, r *http.Request, args []string) error {
+	author, err := requireUser(r)
+	if err != nil {
+		return err
+	}
+	var req postRequest
+	if err := s.decode(r, &req); err != nil {
+		return err
+	}
+	parent, err := s.forum.GetPost(r.Context(), args[0], forum.View{Viewer: &author})
+	if err != nil {
+		return err
+	}
+	id, err := s.forum.CreateDraftReply(r.Context(), parent.Path, req.Subject, req.Body, author)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusCreated, &draftResponse{ID: id})
+	return nil
+}
+
+func (s *server) getDrafts(w http.ResponseWriter, r *http.Request, _ []string) error {
+	u, err := requireUser(r)
+	if err != nil {
+		return err
+	}
+	drafts, err := s.forum.GetDrafts(r.Context(), u.ID)
+	if err != nil {
+		return err
+	}
+	if drafts == nil {
+		drafts = []*forum.Draft{}
+	}
+	writeJSON(w, http.StatusOK, drafts)
+	return nil
+}
+
+func (s *server) updateDraft(w http.ResponseWriter, r *http.Request, args []string) error {
+	u, err := requireUser(r)
+	if err != nil {
+		return err
+	}
+	var req postRequest
+	if err := s.decode(r, &req); err != nil {
+		return err
+	}
+	if err := s.forum.UpdateDraft(r.Context(), u.ID, args[0], req.Subject, req.Body); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *server) deleteDraft(w http.ResponseWriter, r *http.Request, args []string) error {
+	u, err := requireUser(r)
+	if err != nil {
+		return err
+	}
+	if err := s.forum.DeleteDraft(r.Context(), u.ID, args[0]); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *server) publishDraft(w http.ResponseWriter, r *http.Request, args []string) error {
+	u, err := requireUser(r)
+	if err != nil {
+		return err
+	}
+	path, err := s.forum.InstallReply(r.Context(), u.ID, args[0])
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusCreated, &pathResponse{Path: path})
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mhcoffin/forum-tools/pkg/auth"
+	"github.com/mhcoffin/forum-tools/pkg/forum"
+	"github.com/mhcoffin/forum-tools/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	admin    = "mhc" // The forum's built-in moderator
+	ella     = "jane"
+	botToken = "s3cret"
+)
+
+func TestMain(m *testing.M) {
+	testutil.StartFirestoreEmulator(m)
+}
+
+// newTestServer starts a server, after letting configure change it.
+func newTestServer(t *testing.T, configure ...func(*server)) *httptest.Server {
+	fm, err := forum.NewClient(context.Background(), "fugalist")
+	require.Nil(t, err)
+	authn := auth.Chain{auth.NewStaticTokens(map[string]forum.User{botToken: {ID: "bot", Name: "Bot"}}), auth.DevHeader{}}
+	s := newServer(fm, authn, 4096)
+	for _, c := range configure {
+		c(s)
+	}
+	return httptest.NewServer(s)
+}
+
+// call makes a request as user, who may be "" for an anonymous request or a bearer
+// token, and decodes the response into out if it isn't nil.
+func call(t *testing.T, srv *httptest.Server, method string, path string, user string, body interface{}, out interface{}) int {
+	var r *bytes.Reader
+	switch b := body.(type) {
+	case nil:
+		r = bytes.NewReader(nil)
+	case string:
+		r = bytes.NewReader([]byte(b))
+	default:
+		j, err := json.Marshal(b)
+		require.Nil(t, err)
+		r = bytes.NewReader(j)
+	}
+	req, err := http.NewRequest(method, srv.URL+path, r)
+	require.Nil(t, err)
+	switch {
+	case strings.HasPrefix(user, "Bearer "):
+		req.Header.Set("Authorization", user)
+	case user != "":
+		req.Header.Set(auth.UserHeader, user)
+	}
+	resp, err := srv.Client().Do(req)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	if out != nil {
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp.StatusCode
+}
+
+type errorBody struct {
+	Error apiError `json:"error"`
+}
+
+func createSection(t *testing.T, srv *httptest.Server) forum.PostID {
+	var created pathResponse
+	code := call(t, srv, "POST", "/sections", admin, &sectionRequest{Title: "General", Description: "Chat"}, &created)
+	require.Equal(t, http.StatusCreated, code)
+	require.Len(t, created.Path, 1)
+	return created.Path[0]
+}
+
+func TestServer_Threads(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	section := createSection(t, srv)
+
+	var sections []*forum.SectionNode
+	require.Equal(t, http.StatusOK, call(t, srv, "GET", "/sections", "", nil, &sections))
+	var ids []forum.PostID
+	for _, s := range sections {
+		ids = append(ids, s.ID())
+	}
+	assert.Contains(t, ids, section)
+
+	var threads []forum.PostID
+	for _, subject := range []string{"One", "Two", "Three"} {
+		var created pathResponse
+		code := call(t, srv, "POST", "/sections/"+section+"/threads", ella, &postRequest{Subject: subject, Body: "Hello"}, &created)
+		require.Equal(t, http.StatusCreated, code)
+		threads = append(threads, created.Path[1])
+	}
+
+	var page postList
+	require.Equal(t, http.StatusOK, call(t, srv, "GET", "/sections/"+section+"/threads?n=2", "", nil, &page))
+	require.Len(t, page.Items, 2)
+	assert.Equal(t, "Three", page.Items[0].Head)
+	require.NotEmpty(t, page.Cursor)
+	var rest postList
+	require.Equal(t, http.StatusOK, call(t, srv, "GET", "/sections/"+section+"/threads?n=2&cursor="+page.Cursor, "", nil, &rest))
+	require.Len(t, rest.Items, 1)
+	assert.Equal(t, "One", rest.Items[0].Head)
+	assert.Empty(t, rest.Cursor)
+
+	var reply pathResponse
+	code := call(t, srv, "POST", "/posts/"+threads[0]+"/replies", admin, &postRequest{Subject: "Re: One", Body: "Hi"}, &reply)
+	require.Equal(t, http.StatusCreated, code)
+	assert.Len(t, reply.Path, 3)
+	var replies postList
+	require.Equal(t, http.StatusOK, call(t, srv, "GET", "/threads/"+threads[0]+"/replies", "", nil, &replies))
+	require.Len(t, replies.Items, 2)
+	assert.Equal(t, "Hi", replies.Items[1].Body)
+
+	code = call(t, srv, "PUT", "/threads/"+threads[0], "someone", &postRequest{Subject: "Mine now", Body: "Hello"}, nil)
+	assert.Equal(t, http.StatusForbidden, code)
+	code = call(t, srv, "PUT", "/threads/"+threads[0], ella, &postRequest{Subject: "One", Body: "Hello again"}, nil)
+	require.Equal(t, http.StatusNoContent, code)
+	var post forum.Post
+	require.Equal(t, http.StatusOK, call(t, srv, "GET", "/posts/"+threads[0], "", nil, &post))
+	assert.Equal(t, "Hello again", post.Body)
+
+	code = call(t, srv, "DELETE", "/posts/"+threads[1], "someone", nil, nil)
+	assert.Equal(t, http.StatusForbidden, code)
+	code = call(t, srv, "DELETE", "/posts/"+threads[1]+"?reason=dup", ella, nil, nil)
+	require.Equal(t, http.StatusNoContent, code)
+	require.Equal(t, http.StatusOK, call(t, srv, "GET", "/posts/"+threads[1], "", nil, &post))
+	require.NotNil(t, post.Deleted)
+	assert.Equal(t, "dup", post.Deleted.Why)
+	code = call(t, srv, "POST", "/posts/"+threads[1]+"/replies", admin, &postRequest{Subject: "Re: Two", Body: "Hi"}, nil)
+	assert.Equal(t, http.StatusConflict, code)
+}
+
+func TestServer_Drafts(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	section := createSection(t, srv)
+	var thread pathResponse
+	code := call(t, srv, "POST", "/sections/"+section+"/threads", admin, &postRequest{Subject: "Hi", Body: "Hello"}, &thread)
+	require.Equal(t, http.StatusCreated, code)
+
+	var draft draftResponse
+	code = call(t, srv, "POST", "/posts/"+thread.Path[1]+"/drafts", ella, &postRequest{Subject: "Re: Hi", Body: "Hel"}, &draft)
+	require.Equal(t, http.StatusCreated, code)
+	code = call(t, srv, "PUT", "/drafts/"+draft.ID, ella, &postRequest{Subject: "Re: Hi", Body: "Hello back"}, nil)
+	require.Equal(t, http.StatusNoContent, code)
+
+	var drafts []*forum.Draft
+	require.Equal(t, http.StatusOK, call(t, srv, "GET", "/drafts", ella, nil, &drafts))
+	require.Len(t, drafts, 1)
+	assert.Equal(t, "Hello back", drafts[0].Body)
+	assert.Equal(t, http.StatusNotFound, call(t, srv, "POST", "/drafts/"+draft.ID+"/publish", admin, nil, nil))
+
+	var reply pathResponse
+	require.Equal(t, http.StatusCreated, call(t, srv, "POST", "/drafts/"+draft.ID+"/publish", ella, nil, &reply))
+	assert.Equal(t, thread.Path, reply.Path[:2])
+	require.Equal(t, http.StatusOK, call(t, srv, "GET", "/drafts", ella, nil, &drafts))
+	assert.Empty(t, drafts)
+	assert.Equal(t, http.StatusNotFound, call(t, srv, "DELETE", "/drafts/"+draft.ID, ella, nil, nil))
+}
+
+func TestServer_Errors(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	section := createSection(t, srv)
+
+	var e errorBody
+	assert.Equal(t, http.StatusNotFound, call(t, srv, "GET", "/posts/nonexistent", "", nil, &e))
+	assert.Equal(t, codeNotFound, e.Error.Code)
+	assert.Equal(t, http.StatusNotFound, call(t, srv, "GET", "/nowhere", "", nil, &e))
+
+	assert.Equal(t, http.StatusForbidden, call(t, srv, "POST", "/sections", ella, &sectionRequest{Title: "Mine"}, &e))
+	assert.Equal(t, codeForbidden, e.Error.Code)
+
+	threads := "/sections/" + section + "/threads"
+	assert.Equal(t, http.StatusUnauthorized, call(t, srv, "POST", threads, "", &postRequest{Subject: "Hi"}, &e))
+	assert.Equal(t, codeUnauthenticated, e.Error.Code)
+	assert.Equal(t, http.StatusBadRequest, call(t, srv, "POST", threads, ella, "{not json", &e))
+	assert.Equal(t, codeBadRequest, e.Error.Code)
+	assert.Equal(t, http.StatusBadRequest, call(t, srv, "POST", threads, ella, &postRequest{Body: "No subject"}, &e))
+	badTag := &postRequest{Subject: "Tagged", Body: "Hello", Tags: []string{"no/slashes"}}
+	assert.Equal(t, http.StatusBadRequest, call(t, srv, "POST", threads, ella, badTag, &e))
+	assert.Equal(t, codeBadRequest, e.Error.Code)
+	big := &postRequest{Subject: "Big", Body: strings.Repeat("x", 5000)}
+	assert.Equal(t, http.StatusRequestEntityTooLarge, call(t, srv, "POST", threads, ella, big, &e))
+	assert.Equal(t, codeTooLarge, e.Error.Code)
+
+	assert.Equal(t, http.StatusBadRequest, call(t, srv, "GET", threads+"?cursor=garbage!", "", nil, &e))
+	search := forum.EncodeCursor(&forum.SearchAfter{})
+	assert.Equal(t, http.StatusBadRequest, call(t, srv, "GET", threads+"?cursor="+search, "", nil, &e))
+	assert.Equal(t, codeBadRequest, e.Error.Code)
+	index := forum.EncodeCursor(&forum.IndexAsc{})
+	assert.Equal(t, http.StatusBadRequest, call(t, srv, "GET", "/threads/nonexistent/replies?cursor="+index, "", nil, &e))
+	assert.Equal(t, http.StatusBadRequest, call(t, srv, "GET", threads+"?n=1000", "", nil, &e))
+	assert.Equal(t, http.StatusMethodNotAllowed, call(t, srv, "PATCH", threads, ella, nil, &e))
+	assert.Equal(t, codeNotAllowed, e.Error.Code)
+}
+
+func TestServer_Auth(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	section := createSection(t, srv)
+
+	var created pathResponse
+	code := call(t, srv, "POST", "/sections/"+section+"/threads", "Bearer "+botToken, &postRequest{Subject: "Welcome", Body: "Hi"}, &created)
+	require.Equal(t, http.StatusCreated, code)
+	var post forum.Post
+	require.Equal(t, http.StatusOK, call(t, srv, "GET", "/posts/"+created.Path[1], "", nil, &post))
+	assert.Equal(t, "bot", post.Author.ID)
+
+	// A bad token is refused, even for something anonymous users may do.
+	var e errorBody
+	assert.Equal(t, http.StatusUnauthorized, call(t, srv, "GET", "/posts/"+created.Path[1], "Bearer guess", nil, &e))
+	assert.Equal(t, codeUnauthenticated, e.Error.Code)
+}
@@ -57,15 +57,15 @@ type Path []string
 
 func main() {
 	// Create several sections
-	_, err := fm.CreateSection(ctx, "Announcements", "Public announcements", 100, mhc)
+	_, err := fm.CreateSection(ctx, "Announcements", "Public announcements", 100, mhc, forum.SectionOptions{Moderated: true})
 	if err != nil {
 		log.Fatalf("failed to create section: %s", err)
 	}
-	syn, err := fm.CreateSection(ctx, "Synchron Libraries", "VSL Synchron Libraries", 200, mhc)
+	syn, err := fm.CreateSection(ctx, "Synchron Libraries", "VSL Synchron Libraries", 200, mhc, forum.SectionOptions{QA: true})
 	if err != nil {
 		log.Fatalf("failed to create section: %s", err)
 	}
-	gen, err := fm.CreateSection(ctx, "General Discussion", "General discussion", 300, mhc)
+	gen, err := fm.CreateSection(ctx, "General Discussion", "General discussion", 300, mhc, forum.SectionOptions{})
 
 	sections := []Path{syn, gen}
 
@@ -85,7 +85,7 @@ func main() {
 
 func createRandomThread(path Path) []string {
 	subject := randomString(4)
-	p, err := fm.CreateThread(ctx, subject, randomString(400), randomUser(), path[0])
+	p, err := fm.CreateThread(ctx, subject, randomString(400), randomUser(), path[0], forum.ThreadOptions{})
 	if err != nil {
 		log.Fatalf("failed to create thread: %s", err)
 	}
@@ -5,4 +5,7 @@ go 1.15
 require (
 	cloud.google.com/go/firestore v1.3.0
 	github.com/stretchr/testify v1.6.1
+	golang.org/x/net v0.0.0-20200707034311-ab3426394381
+	google.golang.org/api v0.29.0
+	google.golang.org/grpc v1.30.0
 )
@@ -0,0 +1,613 @@
+// Package archive writes a read-only copy of the forum as a static HTML site.
+//
+// The site has an index of sections, a page per section listing its threads, and a page
+// per thread with its replies nested under the posts they reply to. Long listings are
+// split into pages. Every post also gets a permalink, posts/ID.html, which redirects to
+// wherever the post is.
+//
+// Only what an anonymous reader may see is archived.
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/feed"
+	"github.com/mhcoffin/forum-tools/pkg/forum"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultThreadsPerPage = 50
+	defaultRepliesPerPage = 20
+	// manifestFile records what has been archived, for regenerating incrementally.
+	manifestFile = ".archive.json"
+	// listSize is how many posts to read at a time.
+	listSize = 100
+)
+
+// Options control what is archived and how.
+type Options struct {
+	Dir      string         // Where to write the site
+	Site     string         // Title of the site
+	Sections []forum.PostID // Only these sections and their sub-sections. Empty means all.
+	// Templates is a directory of templates, each named after the template it replaces
+	// plus ".html", such as "thread.html". Templates not found there are the defaults.
+	// A style.css found there replaces the default style sheet.
+	Templates      string
+	ThreadsPerPage int // Threads listed on each page of a section
+	RepliesPerPage int // Replies to the thread itself, with everything under them, on each page of a thread
+	// Force regenerates every page. Otherwise, sections and threads are only regenerated
+	// if they have been bumped since they were last archived. Edits and deletions don't
+	// bump anything, so archiving them, or a change of templates, needs Force. Either
+	// way, files for sections and threads that are no longer archived are removed.
+	Force bool
+}
+
+// Stats says what Generate did.
+type Stats struct {
+	Sections int // Sections regenerated
+	Threads  int // Threads regenerated
+	Skipped  int // Sections and threads unchanged since they were last archived
+	Pages    int // Files written, including permalinks
+	Removed  int // Files removed because they are no longer archived
+}
+
+// Page is what every page knows about itself.
+type Page struct {
+	Title     string
+	Site      string
+	Root      string // Relative URL of the top of the site, ending in "/" unless empty
+	Generated time.Time
+}
+
+// IndexPage is the data for the "index" template.
+type IndexPage struct {
+	Page
+	Sections []*forum.SectionNode
+}
+
+// SectionPage is the data for the "section" template.
+type SectionPage struct {
+	Page
+	Section     *forum.Post
+	Subsections []*forum.SectionNode
+	Threads     []*forum.Post // Most recently bumped first
+	Number      int           // Of this page, from 1
+	Pages       []int         // Numbers of all the pages
+}
+
+// PostNode is a post and the replies to it.
+type PostNode struct {
+	*forum.Post
+	HTML     template.HTML // The body, sanitized
+	Children []*PostNode   // Oldest first
+}
+
+// ThreadPage is the data for the "thread" template.
+type ThreadPage struct {
+	Page
+	Section *forum.Post
+	Thread  *PostNode
+	Replies []*PostNode // The part of the thread on this page
+	Number  int
+	Pages   []int
+}
+
+// PermalinkPage is the data for the "permalink" template.
+type PermalinkPage struct {
+	Title  string
+	Target string // Relative URL of the post
+}
+
+// sectionList is the data for the "sections" template.
+type sectionList struct {
+	Root     string
+	Sections []*forum.SectionNode
+}
+
+// manifest records the bump time of every section and thread when it was archived, and
+// the files written for it, so that they can be removed once it's no longer archived.
+type manifest struct {
+	Bumps   map[forum.PostID]time.Time `json:"bumps"`
+	Files   map[forum.PostID][]string  `json:"files"`   // Pages and permalinks of each section and thread
+	Threads map[forum.PostID][]string  `json:"threads"` // IDs of the threads archived in each section
+}
+
+type archiver struct {
+	f         *forum.Forum
+	opts      Options
+	templates *template.Template
+	manifest  *manifest
+	generated time.Time
+	stats     Stats
+	written   []string              // Files written so far, in order
+	seen      map[forum.PostID]bool // Sections and threads still archived
+	stale     []string              // Files that regenerated sections and threads no longer have
+}
+
+// Generate writes the archive to opts.Dir, or brings an earlier archive up to date.
+func Generate(ctx context.Context, f *forum.Forum, opts Options) (*Stats, error) {
+	if opts.ThreadsPerPage <= 0 {
+		opts.ThreadsPerPage = defaultThreadsPerPage
+	}
+	if opts.RepliesPerPage <= 0 {
+		opts.RepliesPerPage = defaultRepliesPerPage
+	}
+	t, err := loadTemplates(opts.Templates)
+	if err != nil {
+		return nil, err
+	}
+	a := &archiver{f: f, opts: opts, templates: t, generated: time.Now(), seen: make(map[forum.PostID]bool)}
+	if err := a.loadManifest(); err != nil {
+		return nil, err
+	}
+	sections, err := f.GetSections(ctx, forum.View{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to archive: %w", err)
+	}
+	if len(opts.Sections) > 0 {
+		sections = selectSections(sections, opts.Sections)
+	}
+	if err := a.writeStyle(); err != nil {
+		return nil, err
+	}
+	index := &IndexPage{Page: a.page(opts.Site, ""), Sections: sections}
+	if err := a.write("index.html", "index", index); err != nil {
+		return nil, err
+	}
+	for _, node := range sections {
+		if err := a.section(ctx, node); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.removeStale(); err != nil {
+		return nil, err
+	}
+	if err := a.saveManifest(); err != nil {
+		return nil, err
+	}
+	return &a.stats, nil
+}
+
+// selectSections returns the sections in nodes, at any depth, whose IDs are in ids.
+func selectSections(nodes []*forum.SectionNode, ids []forum.PostID) []*forum.SectionNode {
+	var result []*forum.SectionNode
+	for _, node := range nodes {
+		if contains(ids, node.ID()) {
+			result = append(result, node)
+		} else {
+			result = append(result, selectSections(node.Subsections, ids)...)
+		}
+	}
+	return result
+}
+
+func contains(ids []forum.PostID, id forum.PostID) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+func loadTemplates(dir string) (*template.Template, error) {
+	t := template.New("archive").Funcs(template.FuncMap{
+		"sections": func(root string, nodes []*forum.SectionNode) *sectionList {
+			return &sectionList{Root: root, Sections: nodes}
+		},
+		"sectionURL": sectionURL,
+		"threadURL":  threadURL,
+		"pageURL":    pageFile,
+		"anchor":     anchor,
+		"author":     author,
+		"date": func(t time.Time) string {
+			return t.UTC().Format("2006-01-02 15:04")
+		},
+	})
+	if _, err := t.Parse(defaultTemplates); err != nil {
+		return nil, fmt.Errorf("failed to parse default templates: %w", err)
+	}
+	if dir == "" {
+		return t, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find templates: %w", err)
+	}
+	for _, file := range files {
+		text, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template: %w", err)
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".html")
+		if _, err := t.New(name).Parse(string(text)); err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", file, err)
+		}
+	}
+	return t, nil
+}
+
+// pageFile returns the name of page n of a listing.
+func pageFile(n int) string {
+	if n <= 1 {
+		return "index.html"
+	}
+	return "page-" + strconv.Itoa(n) + ".html"
+}
+
+func sectionURL(id forum.PostID, n int) string {
+	return "sections/" + id + "/" + pageFile(n)
+}
+
+func threadURL(id forum.PostID, n int) string {
+	return "threads/" + id + "/" + pageFile(n)
+}
+
+func anchor(id forum.PostID) string {
+	return "p-" + id
+}
+
+func author(u forum.User) string {
+	if u.Name != "" {
+		return u.Name
+	}
+	return u.ID
+}
+
+// pages returns the numbers of the pages needed for n items, at least one.
+func pages(n int, perPage int) []int {
+	count := (n + perPage - 1) / perPage
+	if count == 0 {
+		count = 1
+	}
+	result := make([]int, count)
+	for k := range result {
+		result[k] = k + 1
+	}
+	return result
+}
+
+// checkID makes sure an ID can be used in a file name.
+func checkID(id forum.PostID) error {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("failed to archive: can't use post ID %q in a file name", id)
+	}
+	return nil
+}
+
+func (a *archiver) page(title string, root string) Page {
+	return Page{Title: title, Site: a.opts.Site, Root: root, Generated: a.generated}
+}
+
+// changed reports whether a section or thread has been bumped since it was archived.
+func (a *archiver) changed(p *forum.Post) bool {
+	if a.opts.Force || p.Bump == nil {
+		return true
+	}
+	last, ok := a.manifest.Bumps[p.ID()]
+	return !ok || !last.Equal(p.Bump.Time)
+}
+
+// done records that a section or thread has been archived to files. Files it had before
+// but not now are stale.
+func (a *archiver) done(p *forum.Post, files []string) {
+	id := p.ID()
+	if p.Bump != nil {
+		a.manifest.Bumps[id] = p.Bump.Time
+	}
+	kept := make(map[string]bool)
+	for _, file := range files {
+		kept[file] = true
+	}
+	for _, file := range a.manifest.Files[id] {
+		if !kept[file] {
+			a.stale = append(a.stale, file)
+		}
+	}
+	a.manifest.Files[id] = append([]string{}, files...)
+	a.seen[id] = true
+}
+
+// removeStale removes the files of sections and threads that weren't archived this time,
+// because they were deleted, moved or hidden, and files that regenerated ones no longer
+// have. A file still claimed by anything archived, such as the permalink of a post that
+// moved to another thread, is kept.
+func (a *archiver) removeStale() error {
+	claimed := make(map[string]bool)
+	for id, files := range a.manifest.Files {
+		if a.seen[id] {
+			for _, file := range files {
+				claimed[file] = true
+			}
+		}
+	}
+	stale := a.stale
+	var dirs []string
+	for id, files := range a.manifest.Files {
+		if a.seen[id] {
+			continue
+		}
+		stale = append(stale, files...)
+		dirs = append(dirs, "sections/"+id, "threads/"+id)
+		delete(a.manifest.Files, id)
+		delete(a.manifest.Threads, id)
+		delete(a.manifest.Bumps, id)
+	}
+	for _, file := range stale {
+		if claimed[file] {
+			continue
+		}
+		err := os.Remove(filepath.Join(a.opts.Dir, filepath.FromSlash(file)))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", file, err)
+		}
+		if err == nil {
+			a.stats.Removed++
+		}
+		claimed[file] = true
+	}
+	for _, dir := range dirs {
+		// Fails, harmlessly, unless the directory is gone or empty.
+		_ = os.Remove(filepath.Join(a.opts.Dir, filepath.FromSlash(dir)))
+	}
+	return nil
+}
+
+func (a *archiver) section(ctx context.Context, node *forum.SectionNode) error {
+	section := node.Post
+	if err := checkID(section.ID()); err != nil {
+		return err
+	}
+	// Any new post in a section bumps it, so if it hasn't been bumped, none of its
+	// threads can have been either.
+	if a.changed(section) {
+		threads, err := a.threads(ctx, section.ID())
+		if err != nil {
+			return err
+		}
+		start := len(a.written)
+		numbers := pages(len(threads), a.opts.ThreadsPerPage)
+		for _, n := range numbers {
+			start := (n - 1) * a.opts.ThreadsPerPage
+			end := start + a.opts.ThreadsPerPage
+			if end > len(threads) {
+				end = len(threads)
+			}
+			data := &SectionPage{
+				Page:        a.page(section.Head+" - "+a.opts.Site, "../../"),
+				Section:     section,
+				Subsections: node.Subsections,
+				Threads:     threads[start:end],
+				Number:      n,
+				Pages:       numbers,
+			}
+			if err := a.write(sectionURL(section.ID(), n), "section", data); err != nil {
+				return err
+			}
+		}
+		files := append([]string{}, a.written[start:]...)
+		ids := make([]string, len(threads))
+		for k, thread := range threads {
+			ids[k] = thread.ID()
+			if !a.changed(thread) {
+				a.seen[thread.ID()] = true
+				a.stats.Skipped++
+				continue
+			}
+			if err := a.thread(ctx, section, thread); err != nil {
+				return err
+			}
+		}
+		a.manifest.Threads[section.ID()] = ids
+		a.done(section, files)
+		a.stats.Sections++
+	} else {
+		a.seen[section.ID()] = true
+		for _, id := range a.manifest.Threads[section.ID()] {
+			a.seen[id] = true
+		}
+		a.stats.Skipped++
+	}
+	for _, sub := range node.Subsections {
+		if err := a.section(ctx, sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// threads returns every thread in a section, most recently bumped first.
+func (a *archiver) threads(ctx context.Context, sectionID forum.PostID) ([]*forum.Post, error) {
+	var result []*forum.Post
+	var cursor forum.Cursor
+	for {
+		threads, next, err := a.f.GetThreads(ctx, sectionID, cursor, listSize, forum.View{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to archive section %s: %w", sectionID, err)
+		}
+		result = append(result, threads...)
+		if next == nil {
+			return result, nil
+		}
+		cursor = next
+	}
+}
+
+func (a *archiver) thread(ctx context.Context, section *forum.Post, thread *forum.Post) error {
+	if err := checkID(thread.ID()); err != nil {
+		return err
+	}
+	start := len(a.written)
+	var posts []*forum.Post
+	var cursor forum.Cursor
+	for {
+		page, next, err := a.f.GetReplies(ctx, thread.ID(), cursor, listSize, forum.View{})
+		if err != nil {
+			return fmt.Errorf("failed to archive thread %s: %w", thread.ID(), err)
+		}
+		posts = append(posts, page...)
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+	root := buildTree(thread, posts)
+	numbers := pages(len(root.Children), a.opts.RepliesPerPage)
+	for _, n := range numbers {
+		start := (n - 1) * a.opts.RepliesPerPage
+		end := start + a.opts.RepliesPerPage
+		if end > len(root.Children) {
+			end = len(root.Children)
+		}
+		data := &ThreadPage{
+			Page:    a.page(thread.Head+" - "+a.opts.Site, "../../"),
+			Section: section,
+			Thread:  root,
+			Replies: root.Children[start:end],
+			Number:  n,
+			Pages:   numbers,
+		}
+		file := threadURL(thread.ID(), n)
+		if err := a.write(file, "thread", data); err != nil {
+			return err
+		}
+		if n == 1 {
+			if err := a.permalink(root, file); err != nil {
+				return err
+			}
+		}
+		for _, node := range data.Replies {
+			if err := a.permalinks(node, file); err != nil {
+				return err
+			}
+		}
+	}
+	a.done(thread, a.written[start:])
+	a.stats.Threads++
+	return nil
+}
+
+// buildTree arranges the posts of a thread under the posts they reply to. A reply
+// whose parent isn't there to be archived goes under its nearest ancestor that is.
+func buildTree(thread *forum.Post, posts []*forum.Post) *PostNode {
+	root := &PostNode{Post: thread, HTML: template.HTML(feed.Sanitize(thread.Body))}
+	nodes := map[forum.PostID]*PostNode{thread.ID(): root}
+	for _, p := range posts {
+		if p.ID() == thread.ID() {
+			continue
+		}
+		nodes[p.ID()] = &PostNode{Post: p, HTML: template.HTML(feed.Sanitize(p.Body))}
+	}
+	// Posts come oldest first, so children stay in order.
+	for _, p := range posts {
+		node, ok := nodes[p.ID()]
+		if !ok || node == root {
+			continue
+		}
+		parent := root
+		for k := len(p.Path) - 2; k >= 0; k-- {
+			if n, ok := nodes[p.Path[k]]; ok {
+				parent = n
+				break
+			}
+		}
+		parent.Children = append(parent.Children, node)
+	}
+	return root
+}
+
+// permalinks writes permalinks to node and everything under it, which are on page.
+func (a *archiver) permalinks(node *PostNode, page string) error {
+	if err := a.permalink(node, page); err != nil {
+		return err
+	}
+	for _, child := range node.Children {
+		if err := a.permalinks(child, page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *archiver) permalink(node *PostNode, page string) error {
+	if err := checkID(node.ID()); err != nil {
+		return err
+	}
+	data := &PermalinkPage{Title: node.Head, Target: "../" + page + "#" + anchor(node.ID())}
+	return a.write("posts/"+node.ID()+".html", "permalink", data)
+}
+
+// write renders a template to a file under the archive's directory.
+func (a *archiver) write(name string, templateName string, data interface{}) error {
+	var buf bytes.Buffer
+	if err := a.templates.ExecuteTemplate(&buf, templateName, data); err != nil {
+		return fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	if err := a.writeFile(name, buf.Bytes()); err != nil {
+		return err
+	}
+	a.written = append(a.written, name)
+	a.stats.Pages++
+	return nil
+}
+
+func (a *archiver) writeFile(name string, data []byte) error {
+	path := filepath.Join(a.opts.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+func (a *archiver) writeStyle() error {
+	style := []byte(defaultStyle)
+	if a.opts.Templates != "" {
+		custom, err := ioutil.ReadFile(filepath.Join(a.opts.Templates, "style.css"))
+		switch {
+		case err == nil:
+			style = custom
+		case !os.IsNotExist(err):
+			return fmt.Errorf("failed to read style sheet: %w", err)
+		}
+	}
+	return a.writeFile("style.css", style)
+}
+
+func (a *archiver) loadManifest() error {
+	a.manifest = &manifest{
+		Bumps:   make(map[forum.PostID]time.Time),
+		Files:   make(map[forum.PostID][]string),
+		Threads: make(map[forum.PostID][]string),
+	}
+	data, err := ioutil.ReadFile(filepath.Join(a.opts.Dir, manifestFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read archive manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, a.manifest); err != nil {
+		return fmt.Errorf("failed to read archive manifest: %w", err)
+	}
+	return nil
+}
+
+func (a *archiver) saveManifest() error {
+	data, err := json.MarshalIndent(a.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to save archive manifest: %w", err)
+	}
+	return a.writeFile(manifestFile, data)
+}
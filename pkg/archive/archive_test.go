@@ -0,0 +1,164 @@
+package archive
+
+import (
+	"context"
+	"github.com/mhcoffin/forum-tools/pkg/forum"
+	"github.com/mhcoffin/forum-tools/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	ctx       = context.Background()
+	moderator = forum.User{ID: "mhc", Name: "Michael"}
+	ella      = forum.User{ID: "jane", Name: "Ella"}
+)
+
+func TestMain(m *testing.M) {
+	testutil.StartFirestoreEmulator(m)
+}
+
+func post(path ...forum.PostID) *forum.Post {
+	return &forum.Post{Path: path, Head: strings.Join(path, "/"), Body: "<p>" + path[len(path)-1] + "</p>"}
+}
+
+func TestBuildTree(t *testing.T) {
+	thread := post("s", "t")
+	posts := []*forum.Post{
+		thread,
+		post("s", "t", "a"),
+		post("s", "t", "a", "b"),
+		post("s", "t", "c"),
+		// The parent of this one isn't being archived.
+		post("s", "t", "gone", "d"),
+	}
+	root := buildTree(thread, posts)
+	require.Len(t, root.Children, 3)
+	assert.Equal(t, "a", root.Children[0].ID())
+	require.Len(t, root.Children[0].Children, 1)
+	assert.Equal(t, "b", root.Children[0].Children[0].ID())
+	assert.Equal(t, "c", root.Children[1].ID())
+	assert.Equal(t, "d", root.Children[2].ID())
+	assert.Equal(t, "<p>t</p>", string(root.HTML))
+}
+
+func TestPages(t *testing.T) {
+	assert.Equal(t, []int{1}, pages(0, 10))
+	assert.Equal(t, []int{1}, pages(10, 10))
+	assert.Equal(t, []int{1, 2}, pages(11, 10))
+	assert.Equal(t, "index.html", pageFile(1))
+	assert.Equal(t, "threads/t/page-3.html", threadURL("t", 3))
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	custom := `{{template "header" .}}<h1 class="custom">{{.Thread.Head}}</h1>{{template "footer" .}}`
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "thread.html"), []byte(custom), 0644))
+	tmpl, err := loadTemplates(dir)
+	require.Nil(t, err)
+	var out strings.Builder
+	data := &ThreadPage{Section: post("s"), Thread: buildTree(post("s", "t"), nil), Number: 1, Pages: []int{1}}
+	require.Nil(t, tmpl.ExecuteTemplate(&out, "thread", data))
+	assert.Contains(t, out.String(), `<h1 class="custom">s/t</h1>`)
+	assert.Contains(t, out.String(), `style.css`)
+
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte(`{{.Nonsense`), 0644))
+	_, err = loadTemplates(dir)
+	assert.NotNil(t, err)
+}
+
+func TestGenerate(t *testing.T) {
+	f, err := forum.NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	old, err := f.CreateSection(ctx, "Old", "Old news", 900, moderator, forum.SectionOptions{})
+	require.Nil(t, err)
+	other, err := f.CreateSection(ctx, "Other", "Not archived", 901, moderator, forum.SectionOptions{})
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Hello", "<p>Hi<script>x()</script></p>", ella, old[0], forum.ThreadOptions{})
+	require.Nil(t, err)
+	var replies [][]forum.PostID
+	for _, body := range []string{"one", "two", "three"} {
+		reply, err := f.CreateReply(ctx, thread, "Hello", body, moderator)
+		require.Nil(t, err)
+		replies = append(replies, reply)
+	}
+	nested, err := f.CreateReply(ctx, replies[0], "Hello", "nested", ella)
+	require.Nil(t, err)
+	_, err = f.CreateThread(ctx, "Elsewhere", "Not archived", ella, other[0], forum.ThreadOptions{})
+	require.Nil(t, err)
+
+	dir, err := ioutil.TempDir("", "archive")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	opts := Options{Dir: dir, Site: "Archive", Sections: []forum.PostID{old[0]}, RepliesPerPage: 2}
+	stats, err := Generate(ctx, f, opts)
+	require.Nil(t, err)
+	assert.Equal(t, 1, stats.Sections)
+	assert.Equal(t, 1, stats.Threads)
+
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		require.Nil(t, err)
+		return string(data)
+	}
+	index := read("index.html")
+	assert.Contains(t, index, "Old")
+	assert.NotContains(t, index, "Other")
+	assert.Contains(t, read(sectionURL(old[0], 1)), threadURL(thread[1], 1))
+	_, err = os.Stat(filepath.Join(dir, "sections", other[0]))
+	assert.True(t, os.IsNotExist(err))
+
+	// Two top-level replies fit on a page, so the third goes on page two. The nested
+	// reply stays with its parent.
+	first := read(threadURL(thread[1], 1))
+	assert.Contains(t, first, "<p>Hi</p>")
+	assert.NotContains(t, first, "x()")
+	assert.Contains(t, first, "nested")
+	assert.Contains(t, first, "page-2.html")
+	assert.Contains(t, read(threadURL(thread[1], 2)), "three")
+	assert.Contains(t, read("posts/"+nested[3]+".html"), "threads/"+thread[1]+"/index.html#p-"+nested[3])
+	assert.Contains(t, read("posts/"+replies[2][2]+".html"), "threads/"+thread[1]+"/page-2.html#p-"+replies[2][2])
+
+	// Nothing has changed, so nothing is regenerated.
+	stats, err = Generate(ctx, f, opts)
+	require.Nil(t, err)
+	assert.Zero(t, stats.Threads)
+	assert.Equal(t, 1, stats.Skipped)
+
+	four, err := f.CreateReply(ctx, thread, "Hello", "four", ella)
+	require.Nil(t, err)
+	stats, err = Generate(ctx, f, opts)
+	require.Nil(t, err)
+	assert.Equal(t, 1, stats.Threads)
+	assert.Contains(t, read(threadURL(thread[1], 2)), "four")
+
+	// Deleted posts lose their permalinks, and pages no longer needed go.
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		return err == nil
+	}
+	require.Nil(t, f.DeletePost(ctx, replies[2][2], moderator, "spam"))
+	require.Nil(t, f.DeletePost(ctx, four[2], moderator, "spam"))
+	opts.Force = true
+	stats, err = Generate(ctx, f, opts)
+	require.Nil(t, err)
+	assert.Equal(t, 3, stats.Removed)
+	assert.False(t, exists(threadURL(thread[1], 2)))
+	assert.False(t, exists("posts/"+four[2]+".html"))
+	assert.True(t, exists("posts/"+nested[3]+".html"))
+
+	// So does a deleted thread, with everything in it.
+	require.Nil(t, f.DeletePost(ctx, thread[1], moderator, "spam"))
+	_, err = Generate(ctx, f, opts)
+	require.Nil(t, err)
+	assert.False(t, exists(threadURL(thread[1], 1)))
+	assert.False(t, exists("posts/"+nested[3]+".html"))
+	assert.NotContains(t, read(sectionURL(old[0], 1)), thread[1])
+}
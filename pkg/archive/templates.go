@@ -0,0 +1,99 @@
+package archive
+
+// defaultTemplates are used for any template not defined in Options.Templates. Each page
+// is rendered by the template named after its kind: "index", "section", "thread" or
+// "permalink".
+const defaultTemplates = `
+{{define "header"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="{{.Root}}style.css">
+</head>
+<body>
+<header><a href="{{.Root}}index.html">{{.Site}}</a></header>
+<main>
+{{end}}
+
+{{define "footer"}}</main>
+<footer>Archived {{date .Generated}}</footer>
+</body>
+</html>
+{{end}}
+
+{{define "sections"}}<ul class="sections">
+{{range .Sections}}<li><a href="{{$.Root}}{{sectionURL .ID 1}}">{{.Head}}</a>
+{{if .Body}}<p>{{.Body}}</p>{{end}}
+{{if .Subsections}}{{template "sections" (sections $.Root .Subsections)}}{{end}}</li>
+{{end}}</ul>
+{{end}}
+
+{{define "pager"}}{{if gt (len .Pages) 1}}<nav class="pager">
+{{range .Pages}}{{if eq . $.Number}}<span>{{.}}</span>{{else}}<a href="{{pageURL .}}">{{.}}</a>{{end}} {{end}}</nav>{{end}}
+{{end}}
+
+{{define "index"}}{{template "header" .}}
+<h1>{{.Site}}</h1>
+{{template "sections" (sections .Root .Sections)}}
+{{template "footer" .}}{{end}}
+
+{{define "section"}}{{template "header" .}}
+<h1>{{.Section.Head}}</h1>
+{{if .Subsections}}{{template "sections" (sections .Root .Subsections)}}{{end}}
+<table class="threads">
+<tr><th>Thread</th><th>Author</th><th>Replies</th><th>Last post</th></tr>
+{{range .Threads}}<tr>
+<td><a href="{{$.Root}}{{threadURL .ID 1}}">{{.Head}}</a></td>
+<td>{{author .Author}}</td>
+<td>{{.DescendentCount}}</td>
+<td>{{if .Bump}}{{date .Bump.Time}}{{end}}</td>
+</tr>
+{{end}}</table>
+{{template "pager" .}}
+{{template "footer" .}}{{end}}
+
+{{define "reply"}}<article class="post" id="{{anchor .ID}}">
+<h3><a href="#{{anchor .ID}}">{{.Head}}</a></h3>
+<p class="byline">{{author .Author}}, {{date .CreateTime}}</p>
+<div class="body">{{.HTML}}</div>
+{{if .Children}}<div class="replies">{{range .Children}}{{template "reply" .}}{{end}}</div>{{end}}
+</article>
+{{end}}
+
+{{define "thread"}}{{template "header" .}}
+<p><a href="{{.Root}}{{sectionURL .Section.ID 1}}">{{.Section.Head}}</a></p>
+<article class="post thread" id="{{anchor .Thread.ID}}">
+<h1>{{.Thread.Head}}</h1>
+<p class="byline">{{author .Thread.Author}}, {{date .Thread.CreateTime}}</p>
+{{if eq .Number 1}}<div class="body">{{.Thread.HTML}}</div>{{end}}
+</article>
+{{template "pager" .}}
+<div class="replies">{{range .Replies}}{{template "reply" .}}{{end}}</div>
+{{template "pager" .}}
+{{template "footer" .}}{{end}}
+
+{{define "permalink"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="0; url={{.Target}}">
+<link rel="canonical" href="{{.Target}}">
+<title>{{.Title}}</title>
+</head>
+<body><a href="{{.Target}}">{{.Title}}</a></body>
+</html>
+{{end}}
+`
+
+// defaultStyle is written to style.css, unless Options.Templates has one.
+const defaultStyle = `body { font-family: sans-serif; max-width: 60em; margin: 0 auto; padding: 1em; }
+header, footer { color: #666; margin: 1em 0; }
+table.threads { width: 100%; border-collapse: collapse; }
+table.threads td, table.threads th { text-align: left; padding: 0.3em; border-bottom: 1px solid #ddd; }
+.post { margin: 1em 0; }
+.replies { margin-left: 1.5em; border-left: 2px solid #eee; padding-left: 1em; }
+.byline { color: #666; font-size: 0.9em; }
+.pager span { font-weight: bold; }
+`
@@ -0,0 +1,86 @@
+// Package auth works out which forum user an HTTP request comes from.
+package auth
+
+import (
+	"errors"
+	"github.com/mhcoffin/forum-tools/pkg/forum"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrNoCredentials means a request doesn't say who it is from. Callers usually treat
+	// it as anonymous.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials means a request carries credentials that can't be accepted.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Authenticator identifies the user making a request.
+type Authenticator interface {
+	// Authenticate returns the user making r. It returns ErrNoCredentials if r carries
+	// no credentials it understands, and an error wrapping ErrInvalidCredentials if they
+	// are wrong.
+	Authenticate(r *http.Request) (*forum.User, error)
+}
+
+// Chain tries each authenticator in turn and returns the first user found. If none of
+// them finds one, it fails with ErrInvalidCredentials if any of them did, so that a bad
+// token isn't silently treated as anonymous.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (*forum.User, error) {
+	var invalid error
+	for _, a := range c {
+		user, err := a.Authenticate(r)
+		switch {
+		case err == nil:
+			return user, nil
+		case errors.Is(err, ErrNoCredentials):
+		case errors.Is(err, ErrInvalidCredentials):
+			if invalid == nil {
+				invalid = err
+			}
+		default:
+			return nil, err
+		}
+	}
+	if invalid != nil {
+		return nil, invalid
+	}
+	return nil, ErrNoCredentials
+}
+
+// bearer returns the token in a request's "Authorization: Bearer" header, or "" if it
+// has none.
+func bearer(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(h[len(prefix):])
+}
+
+// Header names read by DevHeader.
+const (
+	UserHeader     = "X-Forum-User"
+	UserNameHeader = "X-Forum-User-Name"
+)
+
+// DevHeader takes the user ID from the X-Forum-User header, and the display name from
+// X-Forum-User-Name, without checking anything. Anyone can claim to be anyone, so it is
+// only for development.
+type DevHeader struct{}
+
+func (DevHeader) Authenticate(r *http.Request) (*forum.User, error) {
+	id := r.Header.Get(UserHeader)
+	if id == "" {
+		return nil, ErrNoCredentials
+	}
+	name := r.Header.Get(UserNameHeader)
+	if name == "" {
+		name = id
+	}
+	return &forum.User{ID: id, Name: name}, nil
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"errors"
+	"github.com/mhcoffin/forum-tools/pkg/forum"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDevHeader(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	_, err := DevHeader{}.Authenticate(r)
+	assert.True(t, errors.Is(err, ErrNoCredentials))
+
+	r.Header.Set(UserHeader, "jane")
+	user, err := DevHeader{}.Authenticate(r)
+	require.Nil(t, err)
+	assert.Equal(t, forum.User{ID: "jane", Name: "jane"}, *user)
+	r.Header.Set(UserNameHeader, "Ella Fitzgerald")
+	user, err = DevHeader{}.Authenticate(r)
+	require.Nil(t, err)
+	assert.Equal(t, "Ella Fitzgerald", user.Name)
+}
+
+func TestStaticTokens(t *testing.T) {
+	tokens, err := LoadStaticTokens(strings.NewReader(`{"s3cret": {"ID": "bot", "Name": "Welcome Bot"}}`))
+	require.Nil(t, err)
+	r := httptest.NewRequest("GET", "/", nil)
+	_, err = tokens.Authenticate(r)
+	assert.True(t, errors.Is(err, ErrNoCredentials))
+
+	r.Header.Set("Authorization", "Bearer s3cret")
+	user, err := tokens.Authenticate(r)
+	require.Nil(t, err)
+	assert.Equal(t, "bot", user.ID)
+	assert.Equal(t, "Welcome Bot", user.Name)
+
+	r.Header.Set("Authorization", "Bearer guess")
+	_, err = tokens.Authenticate(r)
+	assert.True(t, errors.Is(err, ErrInvalidCredentials))
+
+	_, err = LoadStaticTokens(strings.NewReader(`{"s3cret": {"Name": "No ID"}}`))
+	assert.NotNil(t, err)
+}
+
+func TestChain(t *testing.T) {
+	chain := Chain{NewStaticTokens(map[string]forum.User{"s3cret": {ID: "bot"}}), DevHeader{}}
+	r := httptest.NewRequest("GET", "/", nil)
+	_, err := chain.Authenticate(r)
+	assert.True(t, errors.Is(err, ErrNoCredentials))
+
+	r.Header.Set(UserHeader, "jane")
+	user, err := chain.Authenticate(r)
+	require.Nil(t, err)
+	assert.Equal(t, "jane", user.ID)
+
+	r.Header.Set("Authorization", "Bearer s3cret")
+	user, err = chain.Authenticate(r)
+	require.Nil(t, err)
+	assert.Equal(t, "bot", user.ID)
+
+	r.Header.Del(UserHeader)
+	r.Header.Set("Authorization", "Bearer guess")
+	_, err = chain.Authenticate(r)
+	assert.True(t, errors.Is(err, ErrInvalidCredentials))
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/forum"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew is how far the issuer's clock may be ahead of ours.
+const clockSkew = 5 * time.Minute
+
+// Firebase authenticates requests carrying a Firebase ID token as an
+// "Authorization: Bearer" header. Tokens are checked against public keys supplied by
+// the caller, so nothing is fetched over the network; the caller is responsible for
+// refreshing the keys with SetKeys as Google rotates them.
+type Firebase struct {
+	project string
+	mu      sync.RWMutex
+	keys    map[string]*rsa.PublicKey // By key ID
+	now     func() time.Time
+}
+
+// NewFirebase returns an authenticator for ID tokens issued to a Firebase project,
+// signed by one of keys. ParseCertificates reads keys in the form Google publishes them.
+func NewFirebase(project string, keys map[string]*rsa.PublicKey) *Firebase {
+	return &Firebase{project: project, keys: keys, now: time.Now}
+}
+
+// SetKeys replaces the keys tokens are checked against. It is safe to call while
+// requests are being authenticated.
+func (fb *Firebase) SetKeys(keys map[string]*rsa.PublicKey) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	fb.keys = keys
+}
+
+// ParseCertificates reads a JSON object mapping key IDs to PEM-encoded X.509
+// certificates, as published at
+// https://www.googleapis.com/robot/v1/metadata/x509/securetoken@system.gserviceaccount.com
+// and returns the public keys in them.
+func ParseCertificates(data []byte) (map[string]*rsa.PublicKey, error) {
+	var certs map[string]string
+	if err := json.Unmarshal(data, &certs); err != nil {
+		return nil, fmt.Errorf("failed to parse certificates: %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(certs))
+	for kid, text := range certs {
+		block, _ := pem.Decode([]byte(text))
+		if block == nil {
+			return nil, fmt.Errorf("failed to parse certificate %s: no PEM data", kid)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate %s: %w", kid, err)
+		}
+		key, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("failed to parse certificate %s: not an RSA key", kid)
+		}
+		keys[kid] = key
+	}
+	return keys, nil
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type tokenClaims struct {
+	Issuer   string `json:"iss"`
+	Audience string `json:"aud"`
+	Subject  string `json:"sub"`
+	Expires  int64  `json:"exp"`
+	Issued   int64  `json:"iat"`
+	AuthTime int64  `json:"auth_time"`
+	Name     string `json:"name"`
+	Picture  string `json:"picture"`
+}
+
+func (fb *Firebase) Authenticate(r *http.Request) (*forum.User, error) {
+	token := bearer(r)
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+	claims, err := fb.verify(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCredentials, err)
+	}
+	name := claims.Name
+	if name == "" {
+		name = claims.Subject
+	}
+	return &forum.User{ID: claims.Subject, Name: name, PhotoURL: claims.Picture}, nil
+}
+
+// verify checks a token's signature and claims, following
+// https://firebase.google.com/docs/auth/admin/verify-id-tokens.
+func (fb *Firebase) verify(token string) (*tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unexpected algorithm %q", header.Alg)
+	}
+	fb.mu.RLock()
+	key, ok := fb.keys[header.Kid]
+	fb.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", header.Kid)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("bad signature")
+	}
+	var claims tokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := fb.now()
+	switch {
+	case claims.Audience != fb.project:
+		return nil, fmt.Errorf("token is for project %q", claims.Audience)
+	case claims.Issuer != "https://securetoken.google.com/"+fb.project:
+		return nil, fmt.Errorf("token issued by %q", claims.Issuer)
+	case claims.Subject == "" || len(claims.Subject) > 128:
+		return nil, fmt.Errorf("bad subject")
+	case !now.Before(time.Unix(claims.Expires, 0)):
+		return nil, fmt.Errorf("token expired")
+	case time.Unix(claims.Issued, 0).After(now.Add(clockSkew)),
+		time.Unix(claims.AuthTime, 0).After(now.Add(clockSkew)):
+		return nil, fmt.Errorf("token issued in the future")
+	}
+	return &claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return fmt.Errorf("malformed token")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("malformed token")
+	}
+	return nil
+}
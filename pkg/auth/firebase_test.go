@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const project = "fugalist"
+
+// sign returns a token with the given header and claims, signed with key.
+func sign(t *testing.T, key *rsa.PrivateKey, header tokenHeader, claims tokenClaims) string {
+	h, err := json.Marshal(header)
+	require.Nil(t, err)
+	c, err := json.Marshal(claims)
+	require.Nil(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.Nil(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestFirebase(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	fb := NewFirebase(project, map[string]*rsa.PublicKey{"k1": &key.PublicKey})
+	fb.now = func() time.Time { return now }
+
+	header := tokenHeader{Alg: "RS256", Kid: "k1"}
+	valid := tokenClaims{
+		Issuer:   "https://securetoken.google.com/" + project,
+		Audience: project,
+		Subject:  "jane",
+		Expires:  now.Add(time.Hour).Unix(),
+		Issued:   now.Add(-time.Minute).Unix(),
+		AuthTime: now.Add(-time.Hour).Unix(),
+		Name:     "Ella Fitzgerald",
+		Picture:  "https://example.com/ella.jpg",
+	}
+	authenticate := func(token string) error {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		_, err := fb.Authenticate(r)
+		return err
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	_, err = fb.Authenticate(r)
+	assert.True(t, errors.Is(err, ErrNoCredentials))
+	r.Header.Set("Authorization", "Bearer "+sign(t, key, header, valid))
+	user, err := fb.Authenticate(r)
+	require.Nil(t, err)
+	assert.Equal(t, "jane", user.ID)
+	assert.Equal(t, "Ella Fitzgerald", user.Name)
+	assert.Equal(t, valid.Picture, user.PhotoURL)
+
+	expired := valid
+	expired.Expires = now.Add(-time.Second).Unix()
+	wrongProject := valid
+	wrongProject.Audience = "someone-else"
+	wrongIssuer := valid
+	wrongIssuer.Issuer = "https://securetoken.google.com/someone-else"
+	future := valid
+	future.Issued = now.Add(time.Hour).Unix()
+	noSubject := valid
+	noSubject.Subject = ""
+	for name, token := range map[string]string{
+		"expired":       sign(t, key, header, expired),
+		"wrong project": sign(t, key, header, wrongProject),
+		"wrong issuer":  sign(t, key, header, wrongIssuer),
+		"future":        sign(t, key, header, future),
+		"no subject":    sign(t, key, header, noSubject),
+		"unknown key":   sign(t, key, tokenHeader{Alg: "RS256", Kid: "k2"}, valid),
+		"wrong key":     sign(t, other, header, valid),
+		"wrong alg":     sign(t, key, tokenHeader{Alg: "none", Kid: "k1"}, valid),
+		"garbage":       "not.a.token",
+	} {
+		err := authenticate(token)
+		assert.True(t, errors.Is(err, ErrInvalidCredentials), name)
+	}
+
+	// After a key rotation, tokens signed with the old key are refused.
+	fb.SetKeys(map[string]*rsa.PublicKey{"k2": &other.PublicKey})
+	assert.Nil(t, authenticate(sign(t, other, tokenHeader{Alg: "RS256", Kid: "k2"}, valid)))
+	err = authenticate(sign(t, key, header, valid))
+	assert.True(t, errors.Is(err, ErrInvalidCredentials))
+}
+
+func TestParseCertificates(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "securetoken.system.gserviceaccount.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	data, err := json.Marshal(map[string]string{"k1": string(cert)})
+	require.Nil(t, err)
+
+	keys, err := ParseCertificates(data)
+	require.Nil(t, err)
+	require.Contains(t, keys, "k1")
+	assert.Equal(t, 0, key.PublicKey.N.Cmp(keys["k1"].N))
+
+	_, err = ParseCertificates([]byte(`{"k1": "not a certificate"}`))
+	assert.NotNil(t, err)
+}
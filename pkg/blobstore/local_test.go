@@ -0,0 +1,52 @@
+package blobstore
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocal(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "blobs")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store, err := NewLocal(dir)
+	require.Nil(t, err)
+
+	require.Nil(t, store.Put(ctx, "att/abc", strings.NewReader("hello")))
+	require.Nil(t, store.Put(ctx, "att/abc.thumb", strings.NewReader("hi")))
+	r, err := store.Get(ctx, "att/abc")
+	require.Nil(t, err)
+	b, err := ioutil.ReadAll(r)
+	require.Nil(t, err)
+	require.Nil(t, r.Close())
+	assert.Equal(t, "hello", string(b))
+
+	keys, err := store.List(ctx, time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []string{"att/abc", "att/abc.thumb"}, keys)
+	keys, err = store.List(ctx, time.Now().Add(-time.Minute))
+	require.Nil(t, err)
+	assert.Empty(t, keys)
+
+	require.Nil(t, store.Delete(ctx, "att/abc"))
+	require.Nil(t, store.Delete(ctx, "att/abc"))
+	_, err = store.Get(ctx, "att/abc")
+	assert.NotNil(t, err)
+}
+
+func TestLocal_BadKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store, err := NewLocal(dir)
+	require.Nil(t, err)
+	assert.NotNil(t, store.Put(context.Background(), "../escape", strings.NewReader("x")))
+	assert.NotNil(t, store.Put(context.Background(), "", strings.NewReader("x")))
+}
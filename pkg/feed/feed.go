@@ -0,0 +1,277 @@
+// Package feed renders lists of forum posts as Atom and RSS feeds.
+package feed
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/forum"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Format is a feed format.
+type Format string
+
+const (
+	Atom Format = "atom"
+	RSS  Format = "rss"
+)
+
+// ParseFormat returns the format named s, or Atom if s is "".
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case "", Atom:
+		return Atom, nil
+	case RSS:
+		return RSS, nil
+	}
+	return "", fmt.Errorf("unknown feed format %q", s)
+}
+
+// Site says where feeds are published.
+type Site struct {
+	Title string
+	URL   string // Base URL. A post's page is URL/posts/ID.
+	// Tag is the authority of the tag: URIs that identify feeds and entries: a domain
+	// the site owns and a date it owned it on, such as "example.com,2020". It must never
+	// change, or feed readers will show every entry again.
+	Tag string
+}
+
+// PostURL returns the address of a post's page.
+func (s Site) PostURL(p *forum.Post) string {
+	return strings.TrimSuffix(s.URL, "/") + "/posts/" + p.ID()
+}
+
+// id returns a tag: URI for something on the site.
+func (s Site) id(parts ...string) string {
+	return "tag:" + s.Tag + ":" + strings.Join(parts, "/")
+}
+
+// Feed is a list of posts, newest first.
+type Feed struct {
+	Site    Site
+	Title   string
+	Path    string // Identifies the feed within the site, such as "sections/ID"
+	Self    string // Where the feed itself can be fetched
+	Updated time.Time
+	Posts   []*forum.Post
+	// Private means the feed was built for a signed-in reader, and may include what others
+	// can't see, so shared caches mustn't keep it.
+	Private bool
+}
+
+// New returns a feed of posts. It was last updated when the most recently edited post
+// was.
+func New(site Site, title string, path string, posts []*forum.Post) *Feed {
+	f := &Feed{Site: site, Title: title, Path: path, Posts: posts}
+	for _, p := range posts {
+		if t := updated(p); t.After(f.Updated) {
+			f.Updated = t
+		}
+	}
+	return f
+}
+
+// updated returns when a post last changed.
+func updated(p *forum.Post) time.Time {
+	if p.EditTime.After(p.CreateTime) {
+		return p.EditTime
+	}
+	return p.CreateTime
+}
+
+// entryID identifies a post. It is derived from the post's path, so a reply's ID shows
+// which thread and section it belongs to.
+func (f *Feed) entryID(p *forum.Post) string {
+	return f.Site.id(append([]string{"posts"}, p.Path...)...)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Author     atomPerson     `xml:"author"`
+	Link       atomLink       `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomText       `xml:"content"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title string `xml:"title"`
+	// Self comes before Link so that decoding doesn't mistake one for the other.
+	Self          *atomLink `xml:"http://www.w3.org/2005/Atom link,omitempty"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+func authorName(p *forum.Post) string {
+	if p.Author.Name != "" {
+		return p.Author.Name
+	}
+	return p.Author.ID
+}
+
+func (f *Feed) atom() *atomFeed {
+	feed := &atomFeed{
+		Title:   f.Title,
+		ID:      f.Site.id(f.Path),
+		Updated: f.Updated.UTC().Format(time.RFC3339),
+		Links:   []atomLink{{Rel: "alternate", Type: "text/html", Href: strings.TrimSuffix(f.Site.URL, "/") + "/" + f.Path}},
+	}
+	if f.Self != "" {
+		feed.Links = append(feed.Links, atomLink{Rel: "self", Type: "application/atom+xml", Href: f.Self})
+	}
+	for _, p := range f.Posts {
+		entry := atomEntry{
+			Title:     p.Head,
+			ID:        f.entryID(p),
+			Updated:   updated(p).UTC().Format(time.RFC3339),
+			Published: p.CreateTime.UTC().Format(time.RFC3339),
+			Author:    atomPerson{Name: authorName(p)},
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: f.Site.PostURL(p)},
+			Content:   atomText{Type: "html", Body: Sanitize(p.Body)},
+		}
+		for _, tag := range p.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
+
+func (f *Feed) rss() *rssFeed {
+	channel := rssChannel{
+		Title:       f.Title,
+		Link:        strings.TrimSuffix(f.Site.URL, "/") + "/" + f.Path,
+		Description: f.Title,
+	}
+	if !f.Updated.IsZero() {
+		channel.LastBuildDate = f.Updated.UTC().Format(time.RFC1123Z)
+	}
+	if f.Self != "" {
+		channel.Self = &atomLink{Rel: "self", Type: "application/rss+xml", Href: f.Self}
+	}
+	for _, p := range f.Posts {
+		channel.Items = append(channel.Items, rssItem{
+			Title:       p.Head,
+			Link:        f.Site.PostURL(p),
+			GUID:        rssGUID{ID: f.entryID(p)},
+			PubDate:     p.CreateTime.UTC().Format(time.RFC1123Z),
+			Creator:     authorName(p),
+			Categories:  p.Tags,
+			Description: Sanitize(p.Body),
+		})
+	}
+	return &rssFeed{Version: "2.0", Channel: channel}
+}
+
+// Encode writes the feed to w in a format.
+func (f *Feed) Encode(w io.Writer, format Format) error {
+	var doc interface{}
+	switch format {
+	case Atom:
+		doc = f.atom()
+	case RSS:
+		doc = f.rss()
+	default:
+		return fmt.Errorf("unknown feed format %q", format)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode feed: %w", err)
+	}
+	return nil
+}
+
+// ContentType returns the media type of a format.
+func (format Format) ContentType() string {
+	if format == RSS {
+		return "application/rss+xml; charset=utf-8"
+	}
+	return "application/atom+xml; charset=utf-8"
+}
+
+// Serve writes the feed as the response to r. It answers conditional requests, with
+// If-None-Match or If-Modified-Since, with 304 Not Modified when the feed hasn't
+// changed, so that readers polling it don't download it again. A private feed may only
+// be cached by the reader's own client.
+func (f *Feed) Serve(w http.ResponseWriter, r *http.Request, format Format) error {
+	var buf bytes.Buffer
+	if err := f.Encode(&buf, format); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	if f.Private {
+		w.Header().Set("Cache-Control", "private, max-age=300")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	}
+	// Who is reading decides what's in the feed.
+	w.Header().Set("Vary", "Authorization")
+	http.ServeContent(w, r, "", f.Updated, bytes.NewReader(buf.Bytes()))
+	return nil
+}
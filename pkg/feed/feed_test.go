@@ -0,0 +1,139 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/mhcoffin/forum-tools/pkg/forum"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var (
+	site    = Site{Title: "Forum", URL: "https://forum.example.com/", Tag: "example.com,2020"}
+	created = time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+)
+
+func testFeed() *Feed {
+	posts := []*forum.Post{
+		{
+			Path:       []forum.PostID{"general", "t1", "r1"},
+			Head:       "Re: Hello",
+			Body:       `<p>Hi <b>there</b><script>alert(1)</script></p>`,
+			Author:     forum.User{ID: "jane", Name: "Jane"},
+			CreateTime: created.Add(time.Hour),
+			EditTime:   created.Add(2 * time.Hour),
+		},
+		{
+			Path:       []forum.PostID{"general", "t1"},
+			Head:       "Hello",
+			Body:       "First",
+			Author:     forum.User{ID: "mhc"},
+			Tags:       []string{"intro"},
+			CreateTime: created,
+			EditTime:   created,
+		},
+	}
+	f := New(site, "Hello", "threads/t1", posts)
+	f.Self = "https://forum.example.com/threads/t1/feed"
+	return f
+}
+
+func TestFeed_Atom(t *testing.T) {
+	var buf bytes.Buffer
+	require.Nil(t, testFeed().Encode(&buf, Atom))
+	var feed atomFeed
+	require.Nil(t, xml.Unmarshal(buf.Bytes(), &feed))
+	assert.Equal(t, "tag:example.com,2020:threads/t1", feed.ID)
+	assert.Equal(t, "2020-07-01T14:00:00Z", feed.Updated)
+	require.Len(t, feed.Entries, 2)
+	reply := feed.Entries[0]
+	assert.Equal(t, "tag:example.com,2020:posts/general/t1/r1", reply.ID)
+	assert.Equal(t, "2020-07-01T14:00:00Z", reply.Updated)
+	assert.Equal(t, "2020-07-01T13:00:00Z", reply.Published)
+	assert.Equal(t, "Jane", reply.Author.Name)
+	assert.Equal(t, "https://forum.example.com/posts/r1", reply.Link.Href)
+	assert.Equal(t, "<p>Hi <b>there</b></p>", reply.Content.Body)
+	assert.Equal(t, "mhc", feed.Entries[1].Author.Name)
+	assert.Equal(t, []atomCategory{{Term: "intro"}}, feed.Entries[1].Categories)
+}
+
+func TestFeed_RSS(t *testing.T) {
+	var buf bytes.Buffer
+	require.Nil(t, testFeed().Encode(&buf, RSS))
+	var feed rssFeed
+	require.Nil(t, xml.Unmarshal(buf.Bytes(), &feed))
+	assert.Equal(t, "2.0", feed.Version)
+	assert.Equal(t, "https://forum.example.com/threads/t1", feed.Channel.Link)
+	require.Len(t, feed.Channel.Items, 2)
+	item := feed.Channel.Items[0]
+	assert.Equal(t, "tag:example.com,2020:posts/general/t1/r1", item.GUID.ID)
+	assert.False(t, item.GUID.IsPermaLink)
+	assert.Equal(t, "Wed, 01 Jul 2020 13:00:00 +0000", item.PubDate)
+	assert.Equal(t, "<p>Hi <b>there</b></p>", item.Description)
+}
+
+func TestFeed_Serve(t *testing.T) {
+	f := testFeed()
+	rec := httptest.NewRecorder()
+	require.Nil(t, f.Serve(rec, httptest.NewRequest("GET", "/feed", nil), Atom))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/atom+xml; charset=utf-8", rec.Header().Get("Content-Type"))
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	modified := rec.Header().Get("Last-Modified")
+	assert.Equal(t, "Wed, 01 Jul 2020 14:00:00 GMT", modified)
+	assert.Equal(t, "public, max-age=300", rec.Header().Get("Cache-Control"))
+	assert.Equal(t, "Authorization", rec.Header().Get("Vary"))
+
+	req := httptest.NewRequest("GET", "/feed", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	require.Nil(t, f.Serve(rec, req, Atom))
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Zero(t, rec.Body.Len())
+
+	req = httptest.NewRequest("GET", "/feed", nil)
+	req.Header.Set("If-Modified-Since", modified)
+	rec = httptest.NewRecorder()
+	require.Nil(t, f.Serve(rec, req, Atom))
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
+	// An edit changes the feed.
+	f.Posts[1].EditTime = created.Add(3 * time.Hour)
+	f = New(site, f.Title, f.Path, f.Posts)
+	req = httptest.NewRequest("GET", "/feed", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	require.Nil(t, f.Serve(rec, req, Atom))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+
+	// A feed built for a signed-in reader stays out of shared caches.
+	f.Private = true
+	rec = httptest.NewRecorder()
+	require.Nil(t, f.Serve(rec, httptest.NewRequest("GET", "/feed", nil), Atom))
+	assert.Equal(t, "private, max-age=300", rec.Header().Get("Cache-Control"))
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		body, want string
+	}{
+		{"plain & simple", "plain &amp; simple"},
+		{`<p onclick="steal()">Hi</p>`, "<p>Hi</p>"},
+		{`<a href="javascript:steal()">click</a>`, `<a rel="nofollow">click</a>`},
+		{`<a href="https://example.com/?a=1&b=2" target="_blank">x</a>`, `<a href="https://example.com/?a=1&amp;b=2" rel="nofollow">x</a>`},
+		{`<style>p {}</style><div><span>kept</span></div>`, "kept"},
+		{`<img src="data:image/png;base64,AAAA" alt="x"><br>`, `<img alt="x"><br>`},
+		{`<iframe src="https://evil.example.com"></iframe>text`, "text"},
+		{`<b>unclosed`, "<b>unclosed</b>"},
+		{`<!-- note -->`, ""},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, Sanitize(test.body), test.body)
+	}
+}
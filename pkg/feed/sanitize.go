@@ -0,0 +1,142 @@
+package feed
+
+import (
+	"bytes"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"net/url"
+	"strings"
+)
+
+// allowed lists the elements kept in feed content, with the attributes each may keep.
+var allowed = map[atom.Atom][]string{
+	atom.A:          {"href", "title"},
+	atom.B:          nil,
+	atom.Blockquote: nil,
+	atom.Br:         nil,
+	atom.Code:       nil,
+	atom.Del:        nil,
+	atom.Em:         nil,
+	atom.H1:         nil,
+	atom.H2:         nil,
+	atom.H3:         nil,
+	atom.H4:         nil,
+	atom.Hr:         nil,
+	atom.I:          nil,
+	atom.Img:        {"src", "alt", "title", "width", "height"},
+	atom.Li:         nil,
+	atom.Ol:         nil,
+	atom.P:          nil,
+	atom.Pre:        nil,
+	atom.S:          nil,
+	atom.Strong:     nil,
+	atom.Sub:        nil,
+	atom.Sup:        nil,
+	atom.Table:      nil,
+	atom.Tbody:      nil,
+	atom.Td:         nil,
+	atom.Th:         nil,
+	atom.Thead:      nil,
+	atom.Tr:         nil,
+	atom.U:          nil,
+	atom.Ul:         nil,
+}
+
+// dropped lists the elements removed along with everything in them. Other elements that
+// aren't allowed are removed, but their contents are kept.
+var dropped = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Iframe:   true,
+	atom.Object:   true,
+	atom.Embed:    true,
+	atom.Form:     true,
+	atom.Template: true,
+	atom.Noscript: true,
+}
+
+// Sanitize returns the HTML of a post body with everything a feed reader shouldn't run or
+// fetch stripped out: scripts, styles, event handlers, and links that aren't http, https
+// or mailto.
+func Sanitize(body string) string {
+	context := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(body), context)
+	if err != nil {
+		return html.EscapeString(body)
+	}
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		sanitize(&buf, n)
+	}
+	return buf.String()
+}
+
+func sanitize(buf *bytes.Buffer, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		buf.WriteString(html.EscapeString(n.Data))
+		return
+	case html.ElementNode:
+	default:
+		// Comments and doctypes.
+		return
+	}
+	if dropped[n.DataAtom] {
+		return
+	}
+	attrs, ok := allowed[n.DataAtom]
+	if ok {
+		buf.WriteByte('<')
+		buf.WriteString(n.Data)
+		for _, a := range n.Attr {
+			if a.Namespace != "" || !contains(attrs, a.Key) {
+				continue
+			}
+			if (a.Key == "href" || a.Key == "src") && !safeURL(a.Val) {
+				continue
+			}
+			buf.WriteByte(' ')
+			buf.WriteString(a.Key)
+			buf.WriteString(`="`)
+			buf.WriteString(html.EscapeString(a.Val))
+			buf.WriteByte('"')
+		}
+		if n.DataAtom == atom.A {
+			buf.WriteString(` rel="nofollow"`)
+		}
+		buf.WriteByte('>')
+		if n.DataAtom == atom.Br || n.DataAtom == atom.Hr || n.DataAtom == atom.Img {
+			return
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sanitize(buf, c)
+	}
+	if ok {
+		buf.WriteString("</")
+		buf.WriteString(n.Data)
+		buf.WriteByte('>')
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// safeURL reports whether a link may be kept: relative, or http, https or mailto.
+func safeURL(s string) bool {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNotPending = errors.New("post is not pending approval")
+
+// holdForApproval marks post pending if its section requires approval and its author
+// isn't a moderator. Posts already held by a content filter are left as they are.
+func (f Forum) holdForApproval(ctx Context, section *Post, post *Post) error {
+	if !section.Moderated || post.Pending != nil {
+		return nil
+	}
+	isMod, err := f.IsModerator(ctx, post.Author)
+	if err != nil {
+		return err
+	}
+	if !isMod {
+		post.Pending = &PendingInfo{Reason: "section requires approval", Time: time.Now()}
+	}
+	return nil
+}
+
+// ApprovePost makes a post held for review visible. A new post only now bumps and counts
+// toward its ancestors. The author is notified.
+func (f Forum) ApprovePost(ctx Context, postID PostID, moderator User) error {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return fmt.Errorf("failed to approve post: %w", err)
+	}
+	doc := f.fs.Collection(Root).Doc(postID)
+	snap, err := doc.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to approve post: %w", err)
+	}
+	post := &Post{}
+	if err := snap.DataTo(post); err != nil {
+		return fmt.Errorf("failed to decode post: %w", err)
+	}
+	if post.Pending == nil || post.Deleted != nil {
+		return fmt.Errorf("failed to approve post %s: %w", postID, ErrNotPending)
+	}
+	hooks, err := f.getWebhooks(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to approve post: %w", err)
+	}
+	after := *post
+	after.Pending = nil
+	wb := f.fs.Batch()
+	// The precondition makes a second, concurrent approval fail rather than count the
+	// post twice.
+	wb.Update(doc, []firestore.Update{{Path: "Pending", Value: nil}}, firestore.LastUpdateTime(snap.UpdateTime))
+	if !post.Pending.Edit && !after.hidden() {
+		f.countPost(wb, post)
+	}
+	f.audit(wb, newAuditEntry(moderator, AuditApprove, post, &after, ""))
+	f.notify(wb, newNotification(NotifyApproved, post, ""))
+	kind := PostAdded
+	if post.Pending.Edit {
+		kind = PostEdited
+	}
+	if err := f.queueWebhooks(wb, hooks, kind, &after); err != nil {
+		return fmt.Errorf("failed to approve post %s: %w", postID, err)
+	}
+	if _, err := wb.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to approve post %s: %w", postID, err)
+	}
+	f.indexPost(&after)
+	f.publish(ctx, kind, &after)
+	return nil
+}
+
+// RejectPost deletes a post held for review, and tells the author why. A held edit is
+// undone instead, leaving the post as it was when last approved.
+func (f Forum) RejectPost(ctx Context, postID PostID, moderator User, reason string) error {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return fmt.Errorf("failed to reject post: %w", err)
+	}
+	post, err := f.getPost(ctx, postID)
+	if err != nil {
+		return fmt.Errorf("failed to reject post: %w", err)
+	}
+	if post.Pending == nil || post.Deleted != nil {
+		return fmt.Errorf("failed to reject post %s: %w", postID, ErrNotPending)
+	}
+	if post.Pending.Edit {
+		return f.rejectEdit(ctx, postID, moderator, reason)
+	}
+	if err := f.deletePost(ctx, postID, moderator, reason); err != nil {
+		return fmt.Errorf("failed to reject post: %w", err)
+	}
+	wb := f.fs.Batch()
+	f.notify(wb, newNotification(NotifyRejected, post, reason))
+	if _, err := wb.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to notify author of rejection: %w", err)
+	}
+	return nil
+}
+
+// rejectEdit restores the approved content of a post whose edit was held for review.
+func (f Forum) rejectEdit(ctx Context, postID PostID, moderator User, reason string) error {
+	hooks, err := f.getWebhooks(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to reject edit of %s: %w", postID, err)
+	}
+	doc := f.fs.Collection(Root).Doc(postID)
+	var after Post
+	err = f.fs.RunTransaction(ctx, func(ctx Context, tx *firestore.Transaction) error {
+		before, err := txPost(tx, doc)
+		if err != nil {
+			return err
+		}
+		if before.Pending == nil || !before.Pending.Edit || before.Deleted != nil {
+			return ErrNotPending
+		}
+		approved := before.Pending.Approved
+		if approved == nil {
+			return fmt.Errorf("%w: no approved version to restore", ErrNotPending)
+		}
+		after = *before
+		after.Pending = nil
+		after.Head = approved.Head
+		after.Body = approved.Body
+		after.Tags = approved.Tags
+		after.EditTime = approved.EditTime
+		for tag, delta := range tagDeltas(before.Tags, approved.Tags) {
+			err := tx.Set(f.fs.Collection(Tags).Doc(tag), tagCount(tag, delta), firestore.MergeAll)
+			if err != nil {
+				return err
+			}
+		}
+		if err := f.txAudit(tx, newAuditEntry(moderator, AuditReject, before, &after, reason)); err != nil {
+			return err
+		}
+		if err := f.txNotify(tx, newNotification(NotifyRejected, before, reason)); err != nil {
+			return err
+		}
+		if err := f.txQueueWebhooks(tx, hooks, PostEdited, &after); err != nil {
+			return err
+		}
+		return tx.Update(doc, []firestore.Update{
+			{Path: "Pending", Value: nil},
+			{Path: "Head", Value: approved.Head},
+			{Path: "Body", Value: approved.Body},
+			{Path: "Tags", Value: approved.Tags},
+			{Path: "EditTime", Value: approved.EditTime},
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reject edit of %s: %w", postID, err)
+	}
+	f.indexPost(&after)
+	f.publish(ctx, PostEdited, &after)
+	return nil
+}
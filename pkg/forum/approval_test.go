@@ -0,0 +1,111 @@
+package forum
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestForum_ApprovePost(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	news, err := f.CreateSection(ctx, "Announcements", "News", 100, mhc, SectionOptions{Moderated: true})
+	require.Nil(t, err)
+
+	thread, err := f.CreateThread(ctx, "Release", "Version 2 is out", ella, news[0], ThreadOptions{})
+	require.Nil(t, err)
+	threads, _, err := f.GetThreads(ctx, news[0], nil, 10, View{})
+	require.Nil(t, err)
+	assert.Empty(t, threads)
+	section, err := f.getPost(ctx, news[0])
+	require.Nil(t, err)
+	assert.Equal(t, 0, section.ChildCount)
+
+	err = f.ApprovePost(ctx, thread[1], ella)
+	assert.True(t, errors.Is(err, ErrNotPermitted))
+	require.Nil(t, f.ApprovePost(ctx, thread[1], moderator))
+	err = f.ApprovePost(ctx, thread[1], moderator)
+	assert.True(t, errors.Is(err, ErrNotPending))
+
+	threads, _, err = f.GetThreads(ctx, news[0], nil, 10, View{})
+	require.Nil(t, err)
+	assert.Len(t, threads, 1)
+	section, err = f.getPost(ctx, news[0])
+	require.Nil(t, err)
+	assert.Equal(t, 1, section.ChildCount)
+	assert.Equal(t, thread[1], section.Bump.ID)
+
+	_, err = f.CreateReply(ctx, thread, "Re", "Official reply", moderator)
+	require.Nil(t, err)
+	notes, err := f.GetNotifications(ctx, ella.ID, true, 10)
+	require.Nil(t, err)
+	require.Len(t, notes, 1)
+	assert.Equal(t, NotifyApproved, notes[0].Kind)
+	require.Nil(t, f.MarkNotificationRead(ctx, ella.ID, notes[0].ID))
+}
+
+func TestForum_RejectPost(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	news, err := f.CreateSection(ctx, "Announcements", "News", 100, mhc, SectionOptions{Moderated: true})
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Release", "Version 2 is out", moderator, news[0], ThreadOptions{})
+	require.Nil(t, err)
+	reply, err := f.CreateReply(ctx, thread, "Re", "Version 3 when?", ella)
+	require.Nil(t, err)
+
+	pending, err := f.GetPending(ctx, moderator, 10)
+	require.Nil(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, reply[2], pending[0].ID())
+
+	require.Nil(t, f.RejectPost(ctx, reply[2], moderator, "not an announcement"))
+	post, err := f.getPost(ctx, reply[2])
+	require.Nil(t, err)
+	assert.NotNil(t, post.Deleted)
+	notes, err := f.GetNotifications(ctx, ella.ID, false, 10)
+	require.Nil(t, err)
+	require.Len(t, notes, 1)
+	assert.Equal(t, NotifyRejected, notes[0].Kind)
+	assert.Equal(t, "not an announcement", notes[0].Message)
+}
+
+func TestForum_RejectEdit(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	f.AddFilter(FilterFunc(func(ctx Context, post *Post) (Verdict, string, error) {
+		if strings.Contains(post.Body, "buy") {
+			return Hold, "looks like an ad", nil
+		}
+		return Accept, "", nil
+	}))
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Hi", "Hello", ella, gen[0], ThreadOptions{Tags: []string{"intro"}})
+	require.Nil(t, err)
+	require.Nil(t, f.UpdateThread(ctx, thread[1], "Hi", "Please buy my plugin", ella, []string{"ads"}))
+	held, err := f.getPost(ctx, thread[1])
+	require.Nil(t, err)
+	require.NotNil(t, held.Pending)
+	assert.True(t, held.Pending.Edit)
+
+	require.Nil(t, f.RejectPost(ctx, thread[1], moderator, "no ads"))
+	post, err := f.getPost(ctx, thread[1])
+	require.Nil(t, err)
+	assert.Nil(t, post.Deleted)
+	assert.Nil(t, post.Pending)
+	assert.Equal(t, "Hello", post.Body)
+	assert.Equal(t, []string{"intro"}, post.Tags)
+	tag, err := f.getTag(ctx, "ads")
+	require.Nil(t, err)
+	assert.Zero(t, tag.Count)
+	threads, _, err := f.GetThreads(ctx, gen[0], nil, 10, View{})
+	require.Nil(t, err)
+	assert.Len(t, threads, 1)
+	assert.True(t, errors.Is(f.RejectPost(ctx, thread[1], moderator, "again"), ErrNotPending))
+}
@@ -0,0 +1,342 @@
+package forum
+
+import (
+	"bytes"
+	"cloud.google.com/go/firestore"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/uniq"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	Attachments      = "Attachments"
+	ThumbnailSize    = 200      // Maximum width or height of a thumbnail, in pixels
+	DefaultMaxPixels = 50000000 // Largest image accepted unless AttachmentLimits says otherwise
+)
+
+var ErrTooLarge = errors.New("attachment too large")
+
+// BlobStore holds the contents of attachments.
+type BlobStore interface {
+	Put(ctx Context, key string, r io.Reader) error
+	Get(ctx Context, key string) (io.ReadCloser, error)
+	Delete(ctx Context, key string) error
+	List(ctx Context, before time.Time) ([]string, error) // Keys of blobs last written before a time
+}
+
+// AttachmentLimits restricts what may be attached to a post.
+type AttachmentLimits struct {
+	MaxSize int64    // Largest allowed attachment, in bytes
+	Types   []string // Allowed content types. Empty allows any type.
+	// Largest allowed image, in pixels. A small, highly compressed file can decode to an
+	// enormous image, so this is checked before decoding. Zero means DefaultMaxPixels.
+	MaxPixels int64
+}
+
+var DefaultAttachmentLimits = AttachmentLimits{
+	MaxSize:   10 << 20,
+	MaxPixels: DefaultMaxPixels,
+	Types: []string{
+		"image/png",
+		"image/jpeg",
+		"image/gif",
+		"application/pdf",
+		"application/zip",
+		"text/plain; charset=utf-8",
+	},
+}
+
+// Attachment describes a file attached to a post. The contents live in the BlobStore.
+type Attachment struct {
+	ID          string
+	Post        PostID
+	Filename    string
+	ContentType string
+	Size        int64
+	Checksum    string // Hex SHA-256 of the contents
+	Key         string // Storage key of the contents
+	Thumbnail   string // Storage key of the thumbnail, for images
+	Uploader    User
+	CreateTime  time.Time `firestore:",serverTimestamp"`
+}
+
+// SetBlobStore enables attachments, stored in store and restricted by limits.
+func (f *Forum) SetBlobStore(store BlobStore, limits AttachmentLimits) {
+	f.blobs = store
+	f.limits = limits
+}
+
+func (f Forum) allowedType(contentType string) bool {
+	if len(f.limits.Types) == 0 {
+		return true
+	}
+	for _, t := range f.limits.Types {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// Attach stores the contents of r as an attachment to a post. The content type is
+// sniffed from the contents rather than trusted from the uploader. Only the author of
+// the post or a moderator may attach files.
+func (f Forum) Attach(ctx Context, postID PostID, uploader User, filename string, r io.Reader) (*Attachment, error) {
+	if f.blobs == nil {
+		return nil, fmt.Errorf("failed to attach file: no blob store")
+	}
+	post, err := f.getPost(ctx, postID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach file: %w", err)
+	}
+	if post.Author.ID != uploader.ID {
+		if err := f.requireModerator(ctx, uploader); err != nil {
+			return nil, fmt.Errorf("failed to attach file: %w", err)
+		}
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, f.limits.MaxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach file: %w", err)
+	}
+	if int64(len(data)) > f.limits.MaxSize {
+		return nil, fmt.Errorf("failed to attach file: %w", ErrTooLarge)
+	}
+	contentType := http.DetectContentType(data)
+	if !f.allowedType(contentType) {
+		return nil, fmt.Errorf("failed to attach file: %w: type %s not allowed", ErrInvalid, contentType)
+	}
+	maxPixels := f.limits.MaxPixels
+	if maxPixels == 0 {
+		maxPixels = DefaultMaxPixels
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil && int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return nil, fmt.Errorf("failed to attach file: %w: %dx%d image", ErrTooLarge, cfg.Width, cfg.Height)
+	}
+	sum := sha256.Sum256(data)
+	id := uniq.Uniq()
+	att := &Attachment{
+		ID:          id,
+		Post:        postID,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Checksum:    hex.EncodeToString(sum[:]),
+		Key:         "attachments/" + id,
+		Uploader:    uploader,
+	}
+	if err := f.blobs.Put(ctx, att.Key, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to attach file: %w", err)
+	}
+	if thumb, ok := thumbnail(data, maxPixels); ok {
+		att.Thumbnail = att.Key + ".thumb"
+		if err := f.blobs.Put(ctx, att.Thumbnail, bytes.NewReader(thumb)); err != nil {
+			_ = f.blobs.Delete(ctx, att.Key)
+			return nil, fmt.Errorf("failed to store thumbnail: %w", err)
+		}
+	}
+	if _, err := f.fs.Collection(Attachments).Doc(id).Create(ctx, att); err != nil {
+		f.deleteBlobs(ctx, att)
+		return nil, fmt.Errorf("failed to attach file: %w", err)
+	}
+	return att, nil
+}
+
+// GetAttachments returns the attachments of a post, oldest first.
+func (f Forum) GetAttachments(ctx Context, postID PostID) ([]*Attachment, error) {
+	docs, err := f.fs.
+		Collection(Attachments).
+		Where("Post", "==", postID).
+		OrderBy("CreateTime", firestore.Asc).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachments: %w", err)
+	}
+	result := make([]*Attachment, len(docs))
+	for k, doc := range docs {
+		att := &Attachment{}
+		if err := doc.DataTo(att); err != nil {
+			return nil, fmt.Errorf("failed to decode attachment: %w", err)
+		}
+		result[k] = att
+	}
+	return result, nil
+}
+
+func (f Forum) getAttachment(ctx Context, id string) (*Attachment, error) {
+	doc, err := f.fs.Collection(Attachments).Doc(id).Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment %s: %w", id, err)
+	}
+	att := &Attachment{}
+	if err := doc.DataTo(att); err != nil {
+		return nil, fmt.Errorf("failed to decode attachment %s: %w", id, err)
+	}
+	return att, nil
+}
+
+// OpenAttachment returns the contents of an attachment, or of its thumbnail, if view's
+// viewer may see the post it is attached to. Otherwise it returns ErrNotFound.
+func (f Forum) OpenAttachment(ctx Context, id string, thumbnail bool, view View) (*Attachment, io.ReadCloser, error) {
+	if f.blobs == nil {
+		return nil, nil, fmt.Errorf("failed to open attachment: no blob store")
+	}
+	att, err := f.getAttachment(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := f.GetPost(ctx, att.Post, view); err != nil {
+		return nil, nil, fmt.Errorf("failed to open attachment %s: %w", id, err)
+	}
+	key := att.Key
+	if thumbnail {
+		if att.Thumbnail == "" {
+			return nil, nil, fmt.Errorf("attachment %s has no thumbnail", id)
+		}
+		key = att.Thumbnail
+	}
+	r, err := f.blobs.Get(ctx, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open attachment: %w", err)
+	}
+	return att, r, nil
+}
+
+// DeleteAttachment removes an attachment and its contents. Only the uploader or a
+// moderator may delete an attachment.
+func (f Forum) DeleteAttachment(ctx Context, id string, user User) error {
+	att, err := f.getAttachment(ctx, id)
+	if err != nil {
+		return err
+	}
+	if att.Uploader.ID != user.ID {
+		if err := f.requireModerator(ctx, user); err != nil {
+			return fmt.Errorf("failed to delete attachment: %w", err)
+		}
+	}
+	if _, err := f.fs.Collection(Attachments).Doc(id).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+	f.deleteBlobs(ctx, att)
+	return nil
+}
+
+// deleteAttachments removes every attachment of a post. It is used when the post is
+// expunged.
+func (f Forum) deleteAttachments(ctx Context, postID PostID) error {
+	atts, err := f.GetAttachments(ctx, postID)
+	if err != nil {
+		return err
+	}
+	for _, att := range atts {
+		if _, err := f.fs.Collection(Attachments).Doc(att.ID).Delete(ctx); err != nil {
+			return fmt.Errorf("failed to delete attachment: %w", err)
+		}
+		if f.blobs != nil {
+			f.deleteBlobs(ctx, att)
+		}
+	}
+	return nil
+}
+
+// deleteBlobs removes the contents of an attachment. Failures leave orphans, which
+// CleanupBlobs removes later.
+func (f Forum) deleteBlobs(ctx Context, att *Attachment) {
+	_ = f.blobs.Delete(ctx, att.Key)
+	if att.Thumbnail != "" {
+		_ = f.blobs.Delete(ctx, att.Thumbnail)
+	}
+}
+
+// CleanupBlobs deletes blobs that no attachment refers to and returns their keys. Attach
+// stores the contents before recording the attachment, so blobs written within the last
+// grace are left alone in case their upload is still in progress.
+func (f Forum) CleanupBlobs(ctx Context, grace time.Duration) ([]string, error) {
+	if f.blobs == nil {
+		return nil, fmt.Errorf("failed to clean up blobs: no blob store")
+	}
+	// List before reading the attachments, so that any blob listed was either recorded or
+	// abandoned by then.
+	keys, err := f.blobs.List(ctx, time.Now().Add(-grace))
+	if err != nil {
+		return nil, fmt.Errorf("failed to clean up blobs: %w", err)
+	}
+	docs, err := f.fs.Collection(Attachments).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to clean up blobs: %w", err)
+	}
+	used := make(map[string]bool)
+	for _, doc := range docs {
+		att := &Attachment{}
+		if err := doc.DataTo(att); err != nil {
+			return nil, fmt.Errorf("failed to decode attachment: %w", err)
+		}
+		used[att.Key] = true
+		used[att.Thumbnail] = true
+	}
+	var removed []string
+	for _, key := range keys {
+		if used[key] {
+			continue
+		}
+		if err := f.blobs.Delete(ctx, key); err != nil {
+			return removed, fmt.Errorf("failed to clean up blobs: %w", err)
+		}
+		removed = append(removed, key)
+	}
+	return removed, nil
+}
+
+// thumbnail returns a JPEG no larger than ThumbnailSize on a side if data is an image of
+// at most maxPixels.
+func thumbnail(data []byte, maxPixels int64) ([]byte, bool) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return nil, false
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return nil, false
+	}
+	scale := 1.0
+	if w > ThumbnailSize || h > ThumbnailSize {
+		if w > h {
+			scale = float64(ThumbnailSize) / float64(w)
+		} else {
+			scale = float64(ThumbnailSize) / float64(h)
+		}
+	}
+	tw, th := int(float64(w)*scale+0.5), int(float64(h)*scale+0.5)
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		for x := 0; x < tw; x++ {
+			dst.Set(x, y, src.At(b.Min.X+x*w/tw, b.Min.Y+y*h/th))
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
@@ -0,0 +1,130 @@
+package forum
+
+import (
+	"bytes"
+	"errors"
+	"github.com/mhcoffin/forum-tools/pkg/blobstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newBlobForum(t *testing.T) (*Forum, *blobstore.Local, func()) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	dir, err := ioutil.TempDir("", "blobs")
+	require.Nil(t, err)
+	store, err := blobstore.NewLocal(dir)
+	require.Nil(t, err)
+	f.SetBlobStore(store, AttachmentLimits{MaxSize: 1 << 20, Types: []string{"image/png", "text/plain; charset=utf-8"}})
+	return f, store, func() {
+		f.expunge(ctx)
+		os.RemoveAll(dir)
+	}
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	var buf bytes.Buffer
+	require.Nil(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))))
+	return buf.Bytes()
+}
+
+func TestForum_Attach(t *testing.T) {
+	f, store, cleanup := newBlobForum(t)
+	defer cleanup()
+	post := AddRandomPost(t, f)
+
+	att, err := f.Attach(ctx, post.ID(), post.Author, "score.png", bytes.NewReader(testPNG(t, 800, 400)))
+	require.Nil(t, err)
+	assert.Equal(t, "image/png", att.ContentType)
+	assert.NotEmpty(t, att.Thumbnail)
+	assert.Len(t, att.Checksum, 64)
+
+	_, r, err := f.OpenAttachment(ctx, att.ID, true, View{})
+	require.Nil(t, err)
+	thumb, _, err := image.Decode(r)
+	require.Nil(t, err)
+	require.Nil(t, r.Close())
+	assert.Equal(t, 200, thumb.Bounds().Dx())
+	assert.Equal(t, 100, thumb.Bounds().Dy())
+
+	notes, err := f.Attach(ctx, post.ID(), post.Author, "notes.txt", strings.NewReader("some notes"))
+	require.Nil(t, err)
+	assert.Empty(t, notes.Thumbnail)
+
+	atts, err := f.GetAttachments(ctx, post.ID())
+	require.Nil(t, err)
+	assert.Len(t, atts, 2)
+
+	require.Nil(t, f.expungePost(ctx, post.ID(), moderator, "cleanup"))
+	keys, err := store.List(ctx, time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestForum_AttachLimits(t *testing.T) {
+	f, store, cleanup := newBlobForum(t)
+	defer cleanup()
+	post := AddRandomPost(t, f)
+
+	_, err := f.Attach(ctx, post.ID(), post.Author, "big.txt", strings.NewReader(strings.Repeat("x", 2<<20)))
+	assert.True(t, errors.Is(err, ErrTooLarge))
+	_, err = f.Attach(ctx, post.ID(), post.Author, "doc.pdf", strings.NewReader("%PDF-1.4 ..."))
+	assert.NotNil(t, err)
+	_, err = f.Attach(ctx, post.ID(), ella, "notes.txt", strings.NewReader("not mine"))
+	assert.True(t, errors.Is(err, ErrNotPermitted))
+
+	f.SetBlobStore(store, AttachmentLimits{MaxSize: 1 << 20, MaxPixels: 100 * 100})
+	_, err = f.Attach(ctx, post.ID(), post.Author, "huge.png", bytes.NewReader(testPNG(t, 800, 400)))
+	assert.True(t, errors.Is(err, ErrTooLarge))
+}
+
+func TestThumbnailPixelLimit(t *testing.T) {
+	_, ok := thumbnail(testPNG(t, 800, 400), 800*400)
+	assert.True(t, ok)
+	_, ok = thumbnail(testPNG(t, 800, 400), 800*400-1)
+	assert.False(t, ok)
+}
+
+func TestForum_CleanupBlobs(t *testing.T) {
+	f, store, cleanup := newBlobForum(t)
+	defer cleanup()
+	post := AddRandomPost(t, f)
+	att, err := f.Attach(ctx, post.ID(), post.Author, "notes.txt", strings.NewReader("keep me"))
+	require.Nil(t, err)
+	require.Nil(t, store.Put(ctx, "attachments/orphan", strings.NewReader("lost")))
+
+	// The orphan might be an upload still in progress.
+	removed, err := f.CleanupBlobs(ctx, time.Hour)
+	require.Nil(t, err)
+	assert.Empty(t, removed)
+	removed, err = f.CleanupBlobs(ctx, 0)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"attachments/orphan"}, removed)
+	keys, err := store.List(ctx, time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	assert.Equal(t, []string{att.Key}, keys)
+}
+
+func TestForum_OpenAttachmentAccess(t *testing.T) {
+	f, _, cleanup := newBlobForum(t)
+	defer cleanup()
+	staff, err := f.CreateSection(ctx, "Staff", "Members only", 100, moderator, SectionOptions{Visibility: MembersOnly})
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Plans", "Secret", moderator, staff[0], ThreadOptions{})
+	require.Nil(t, err)
+	att, err := f.Attach(ctx, thread[1], moderator, "plans.txt", strings.NewReader("secret plans"))
+	require.Nil(t, err)
+
+	_, _, err = f.OpenAttachment(ctx, att.ID, false, View{})
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, r, err := f.OpenAttachment(ctx, att.ID, false, View{Viewer: &moderator})
+	require.Nil(t, err)
+	require.Nil(t, r.Close())
+}
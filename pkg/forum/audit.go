@@ -0,0 +1,172 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/uniq"
+	"google.golang.org/api/iterator"
+	"io"
+	"time"
+)
+
+const AuditLog = "AuditLog"
+
+// AuditAction names a privileged action recorded in the audit log.
+type AuditAction string
+
+const (
+	AuditDelete  AuditAction = "delete"
+	AuditEdit    AuditAction = "edit"
+	AuditMove    AuditAction = "move"
+	AuditExpunge AuditAction = "expunge"
+	AuditLock    AuditAction = "lock"
+	AuditUnlock  AuditAction = "unlock"
+	AuditResolve AuditAction = "resolve"
+	AuditApprove AuditAction = "approve"
+	AuditReject  AuditAction = "reject"
+	AuditBan     AuditAction = "ban"
+	AuditMute    AuditAction = "mute"
+	AuditShadow  AuditAction = "shadow-ban"
+	AuditLift    AuditAction = "lift"
+	// Section settings and memberships
+	AuditReorder      AuditAction = "reorder"
+	AuditHide         AuditAction = "hide"
+	AuditShow         AuditAction = "show"
+	AuditVisibility   AuditAction = "visibility"
+	AuditAddMember    AuditAction = "add-member"
+	AuditRemoveMember AuditAction = "remove-member"
+	// Tags
+	AuditRenameTag AuditAction = "rename-tag"
+)
+
+// AuditEntry records one privileged action. Entries are only ever created, never
+// updated or deleted.
+type AuditEntry struct {
+	ID          string
+	Actor       User
+	Action      AuditAction
+	Target      []PostID // Path of the post acted on
+	Destination []PostID // New path of the post, for moves
+	Subject     User     // User acted on, for sanctions and memberships
+	Tag         string   // Tag acted on, for tag renames
+	NewTag      string   // New name of the tag, for tag renames
+	Before      string   // Hash of the post before the action
+	After       string   // Hash of the post after the action. Empty if it no longer exists.
+	Reason      string
+	Time        time.Time `firestore:",serverTimestamp"`
+}
+
+// AuditQuery selects audit entries. Zero fields match everything.
+type AuditQuery struct {
+	Actor   string    // User ID of the actor
+	Subject string    // User ID of the user acted on
+	Target  PostID    // Entries about this post or anything below it
+	Since   time.Time // Entries at or after this time
+	Until   time.Time // Entries before this time
+}
+
+// snapshotHash returns a hash of the contents of post, or "" if post is nil. Server
+// timestamps that have not yet been filled in are hashed as zero times.
+func snapshotHash(post *Post) string {
+	if post == nil {
+		return ""
+	}
+	b, err := json.Marshal(post)
+	if err != nil {
+		panic(fmt.Errorf("failed to marshal post: %w", err))
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func newAuditEntry(actor User, action AuditAction, before *Post, after *Post, reason string) *AuditEntry {
+	return &AuditEntry{
+		ID:     uniq.Uniq(),
+		Actor:  actor,
+		Action: action,
+		Target: before.Path,
+		Before: snapshotHash(before),
+		After:  snapshotHash(after),
+		Reason: reason,
+	}
+}
+
+// audit adds entry to a write batch so it is committed with the action it records.
+func (f Forum) audit(wb *firestore.WriteBatch, entry *AuditEntry) {
+	wb.Create(f.fs.Collection(AuditLog).Doc(entry.ID), entry)
+}
+
+// txAudit adds entry to a transaction so it is committed with the action it records.
+func (f Forum) txAudit(tx *firestore.Transaction, entry *AuditEntry) error {
+	return tx.Create(f.fs.Collection(AuditLog).Doc(entry.ID), entry)
+}
+
+func (f Forum) auditQuery(q AuditQuery) firestore.Query {
+	query := f.fs.Collection(AuditLog).Query
+	if q.Actor != "" {
+		query = query.Where("Actor.ID", "==", q.Actor)
+	}
+	if q.Subject != "" {
+		query = query.Where("Subject.ID", "==", q.Subject)
+	}
+	if q.Target != "" {
+		query = query.Where("Target", "array-contains", q.Target)
+	}
+	if !q.Since.IsZero() {
+		query = query.Where("Time", ">=", q.Since)
+	}
+	if !q.Until.IsZero() {
+		query = query.Where("Time", "<", q.Until)
+	}
+	return query.OrderBy("Time", firestore.Desc)
+}
+
+// GetAuditLog returns up to n entries matching q, newest first. Only moderators may
+// read the audit log.
+func (f Forum) GetAuditLog(ctx Context, q AuditQuery, moderator User, n int) ([]*AuditEntry, error) {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	docs, err := f.auditQuery(q).Limit(n).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	result := make([]*AuditEntry, len(docs))
+	for k, doc := range docs {
+		entry := &AuditEntry{}
+		if err := doc.DataTo(entry); err != nil {
+			return nil, fmt.Errorf("failed to decode audit entry: %w", err)
+		}
+		result[k] = entry
+	}
+	return result, nil
+}
+
+// ExportAuditLog writes every entry matching q to w as JSON Lines, newest first.
+func (f Forum) ExportAuditLog(ctx Context, w io.Writer, q AuditQuery, moderator User) error {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return fmt.Errorf("failed to export audit log: %w", err)
+	}
+	iter := f.auditQuery(q).Documents(ctx)
+	defer iter.Stop()
+	enc := json.NewEncoder(w)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to export audit log: %w", err)
+		}
+		entry := &AuditEntry{}
+		if err := doc.DataTo(entry); err != nil {
+			return fmt.Errorf("failed to decode audit entry: %w", err)
+		}
+		if err := enc.Encode(entry); err != nil {
+			return fmt.Errorf("failed to export audit log: %w", err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package forum
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForum_AuditLog(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	start := time.Now().Add(-time.Minute)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	other, err := f.CreateSection(ctx, "Other", "More chat", 200, mhc, SectionOptions{})
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Hi", "Hello", ella, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+	reply, err := f.CreateReply(ctx, thread, "Hi", "Hello back", mhc)
+	require.Nil(t, err)
+
+	require.Nil(t, f.UpdateThread(ctx, thread[1], "Hi", "Hello!", ella, nil))
+	require.Nil(t, f.UpdateThread(ctx, thread[1], "Hi", "Hello (edited)", moderator, nil))
+	require.Nil(t, f.MoveThread(ctx, thread[1], other[0], moderator, "wrong section"))
+	require.Nil(t, f.DeleteThread(ctx, reply[2], mhc, "oops"))
+
+	entries, err := f.GetAuditLog(ctx, AuditQuery{Target: thread[1]}, moderator, 10)
+	require.Nil(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, AuditDelete, entries[0].Action)
+	assert.Equal(t, AuditMove, entries[1].Action)
+	assert.Equal(t, []PostID{other[0], thread[1]}, entries[1].Destination)
+	assert.Equal(t, AuditEdit, entries[2].Action)
+	assert.NotEqual(t, entries[2].Before, entries[2].After)
+
+	entries, err = f.GetAuditLog(ctx, AuditQuery{Actor: mhc.ID, Since: start}, moderator, 10)
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "oops", entries[0].Reason)
+
+	entries, err = f.GetAuditLog(ctx, AuditQuery{Until: start}, moderator, 10)
+	require.Nil(t, err)
+	assert.Empty(t, entries)
+
+	moved, err := f.getPost(ctx, reply[2])
+	require.Nil(t, err)
+	assert.Equal(t, other[0], moved.Path[0])
+	section, err := f.getPost(ctx, other[0])
+	require.Nil(t, err)
+	assert.Equal(t, 1, section.ChildCount)
+	assert.Equal(t, 2, section.DescendentCount)
+}
+
+func TestForum_ExportAuditLog(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	root := AddRandomPost(t, f)
+	thread := AddRandomPost(t, f, root.Path...)
+	require.Nil(t, f.LockThread(ctx, thread.ID(), moderator, true))
+	require.Nil(t, f.ExpungePost(ctx, thread.ID(), moderator, "gone"))
+
+	var buf bytes.Buffer
+	require.Nil(t, f.ExportAuditLog(ctx, &buf, AuditQuery{Target: thread.ID()}, moderator))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var entry AuditEntry
+	require.Nil(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, AuditExpunge, entry.Action)
+	assert.Empty(t, entry.After)
+	assert.NotEmpty(t, entry.Before)
+}
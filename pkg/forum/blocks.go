@@ -0,0 +1,87 @@
+package forum
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+const Blocks = "Blocks"
+
+var ErrBlocked = errors.New("blocked by recipient")
+
+// Block records that one user doesn't want to hear from another.
+type Block struct {
+	User    string // ID of the user doing the blocking
+	Blocked User
+	Time    time.Time `firestore:",serverTimestamp"`
+}
+
+func blockID(userID string, blockedID string) string {
+	return userID + ":" + blockedID
+}
+
+// Block stops blocked from starting conversations with user, and hides blocked's posts
+// from user in listings.
+func (f Forum) Block(ctx Context, user User, blocked User) error {
+	if user.ID == blocked.ID {
+		return fmt.Errorf("failed to block: %w: users can't block themselves", ErrInvalid)
+	}
+	_, err := f.fs.Collection(Blocks).Doc(blockID(user.ID, blocked.ID)).Set(ctx, &Block{User: user.ID, Blocked: blocked})
+	if err != nil {
+		return fmt.Errorf("failed to block %s: %w", blocked.ID, err)
+	}
+	return nil
+}
+
+// Unblock removes a block.
+func (f Forum) Unblock(ctx Context, user User, blockedID string) error {
+	if _, err := f.fs.Collection(Blocks).Doc(blockID(user.ID, blockedID)).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to unblock %s: %w", blockedID, err)
+	}
+	return nil
+}
+
+// GetBlocks returns the users a user has blocked.
+func (f Forum) GetBlocks(ctx Context, userID string) ([]*Block, error) {
+	docs, err := f.fs.Collection(Blocks).Where("User", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blocks: %w", err)
+	}
+	result := make([]*Block, len(docs))
+	for k, doc := range docs {
+		b := &Block{}
+		if err := doc.DataTo(b); err != nil {
+			return nil, fmt.Errorf("failed to decode block: %w", err)
+		}
+		result[k] = b
+	}
+	return result, nil
+}
+
+// blockedBy returns the set of IDs of users a user has blocked.
+func (f Forum) blockedBy(ctx Context, userID string) (map[string]bool, error) {
+	blocks, err := f.GetBlocks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool, len(blocks))
+	for _, b := range blocks {
+		result[b.Blocked.ID] = true
+	}
+	return result, nil
+}
+
+// isBlocked reports whether userID has blocked blockedID.
+func (f Forum) isBlocked(ctx Context, userID string, blockedID string) (bool, error) {
+	_, err := f.fs.Collection(Blocks).Doc(blockID(userID, blockedID)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read block: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,58 @@
+package forum
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestForum_BlockedAuthors(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	bob := User{ID: "bob", Name: "Bob"}
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	var threads [][]PostID
+	for _, author := range []User{bob, ella, bob, ella, bob} {
+		thread, err := f.CreateThread(ctx, "Hi", "Hello from "+author.Name, author, gen[0], ThreadOptions{})
+		require.Nil(t, err)
+		threads = append(threads, thread)
+	}
+	_, err = f.CreateReply(ctx, threads[0], "Hi", "Ella here", ella)
+	require.Nil(t, err)
+	_, err = f.CreateReply(ctx, threads[0], "Hi", "Bob here", bob)
+	require.Nil(t, err)
+	require.Nil(t, f.Block(ctx, mhc, ella))
+	blocks, err := f.GetBlocks(ctx, mhc.ID)
+	require.Nil(t, err)
+	require.Len(t, blocks, 1)
+
+	view := View{Viewer: &mhc}
+	page, cursor, err := f.GetThreads(ctx, gen[0], nil, 2, view)
+	require.Nil(t, err)
+	require.Len(t, page, 2)
+	require.NotNil(t, cursor)
+	more, cursor, err := f.GetThreads(ctx, gen[0], cursor, 2, view)
+	require.Nil(t, err)
+	require.Len(t, more, 1)
+	assert.Nil(t, cursor)
+	for _, p := range append(page, more...) {
+		assert.Equal(t, bob.ID, p.Author.ID)
+	}
+
+	replies, _, err := f.GetReplies(ctx, threads[0][1], nil, 10, view)
+	require.Nil(t, err)
+	assert.Len(t, replies, 2)
+
+	view.Collapse = true
+	all, _, err := f.GetThreads(ctx, gen[0], nil, 10, view)
+	require.Nil(t, err)
+	require.Len(t, all, 5)
+	for _, p := range all {
+		assert.Equal(t, p.Author.ID == ella.ID, p.Collapsed)
+	}
+	others, _, err := f.GetThreads(ctx, gen[0], nil, 10, View{Viewer: &bob})
+	require.Nil(t, err)
+	assert.Len(t, others, 5)
+}
@@ -0,0 +1,149 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"fmt"
+	"time"
+)
+
+const Bookmarks = "Bookmarks"
+
+// Bookmark is a post a user has saved to come back to.
+type Bookmark struct {
+	User       string // ID of the user who saved the post
+	Path       []PostID
+	Note       string
+	CreateTime time.Time `firestore:",serverTimestamp"`
+}
+
+// SavedPost is a bookmark together with the current state of the post it refers to.
+type SavedPost struct {
+	Bookmark
+	Post   *Post // Nil if the post has been expunged or the user can no longer see it
+	Thread *Bump // Latest activity in the post's thread, or nil if that is unknown
+}
+
+// Bookmark saves a post to a user's reading list, replacing the note on any earlier
+// bookmark of the same post.
+func (f Forum) Bookmark(ctx Context, userID string, postPath []PostID, note string) error {
+	if len(postPath) == 0 {
+		return fmt.Errorf("failed to bookmark: %w: empty path", ErrInvalid)
+	}
+	post, err := f.getPost(ctx, postPath[len(postPath)-1])
+	if err != nil {
+		return fmt.Errorf("failed to bookmark: %w", err)
+	}
+	doc := f.fs.Collection(Bookmarks).Doc(pairID(post.ID(), userID))
+	if _, err := doc.Set(ctx, &Bookmark{User: userID, Path: post.Path, Note: note}); err != nil {
+		return fmt.Errorf("failed to bookmark %s: %w", post.ID(), err)
+	}
+	return nil
+}
+
+// RemoveBookmark removes a post from a user's reading list.
+func (f Forum) RemoveBookmark(ctx Context, userID string, postID PostID) error {
+	if _, err := f.fs.Collection(Bookmarks).Doc(pairID(postID, userID)).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to remove bookmark %s: %w", postID, err)
+	}
+	return nil
+}
+
+// ListBookmarks returns a user's bookmarks, newest first, using a CreateTimeDesc cursor.
+// Bookmarks of posts that have since been deleted are returned with the deleted post;
+// those of posts that are gone altogether are returned without one.
+func (f Forum) ListBookmarks(ctx Context, userID string, cursor Cursor, n int) ([]*SavedPost, Cursor, error) {
+	if cursor == nil {
+		cursor = &CreateTimeDesc{}
+	}
+	docs, err := f.fs.
+		Collection(Bookmarks).
+		Where("User", "==", userID).
+		OrderBy(cursor.field(), cursor.direction()).
+		StartAfter(cursor.value()).
+		Limit(n).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list bookmarks: %w", err)
+	}
+	if len(docs) == 0 {
+		return nil, nil, nil
+	}
+	result := make([]*SavedPost, len(docs))
+	// Read each post, and the thread it belongs to, in one round trip.
+	var refs []*firestore.DocumentRef
+	for k, doc := range docs {
+		saved := &SavedPost{}
+		if err := doc.DataTo(&saved.Bookmark); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode bookmark: %w", err)
+		}
+		result[k] = saved
+		id := saved.Path[len(saved.Path)-1]
+		refs = append(refs, f.fs.Collection(Root).Doc(id))
+	}
+	snaps, err := f.fs.GetAll(ctx, refs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list bookmarks: %w", err)
+	}
+	r, err := f.newReader(ctx, View{Viewer: &User{ID: userID}})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list bookmarks: %w", err)
+	}
+	threads := make(map[PostID]*firestore.DocumentRef)
+	for k, snap := range snaps {
+		if !snap.Exists() {
+			continue
+		}
+		post := &Post{}
+		if err := snap.DataTo(post); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode post: %w", err)
+		}
+		if !r.canSee(post) {
+			continue
+		}
+		result[k].Post = post
+		if id := post.threadID(); id != "" {
+			threads[id] = f.fs.Collection(Root).Doc(id)
+		}
+	}
+	if err := f.hydrateThreads(ctx, result, threads); err != nil {
+		return nil, nil, fmt.Errorf("failed to list bookmarks: %w", err)
+	}
+	if len(result) < n {
+		return result, nil, nil
+	}
+	return result, &CreateTimeDesc{tm: result[n-1].CreateTime}, nil
+}
+
+// hydrateThreads fills in the latest thread activity for saved posts whose threads are
+// in refs.
+func (f Forum) hydrateThreads(ctx Context, saved []*SavedPost, refs map[PostID]*firestore.DocumentRef) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	var list []*firestore.DocumentRef
+	for _, ref := range refs {
+		list = append(list, ref)
+	}
+	snaps, err := f.fs.GetAll(ctx, list)
+	if err != nil {
+		return err
+	}
+	bumps := make(map[PostID]*Bump)
+	for _, snap := range snaps {
+		if !snap.Exists() {
+			continue
+		}
+		thread := &Post{}
+		if err := snap.DataTo(thread); err != nil {
+			return fmt.Errorf("failed to decode thread: %w", err)
+		}
+		bumps[thread.ID()] = thread.Bump
+	}
+	for _, s := range saved {
+		if s.Post != nil {
+			s.Thread = bumps[s.Post.threadID()]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package forum
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestForum_Bookmarks(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Hi", "Hello", mhc, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+	reply, err := f.CreateReply(ctx, thread, "Hi", "Hello back", mhc)
+	require.Nil(t, err)
+	gone, err := f.CreateReply(ctx, thread, "Hi", "Soon gone", mhc)
+	require.Nil(t, err)
+
+	require.Nil(t, f.Bookmark(ctx, ella.ID, thread, "read later"))
+	require.Nil(t, f.Bookmark(ctx, ella.ID, reply, "good answer"))
+	require.Nil(t, f.Bookmark(ctx, ella.ID, gone, ""))
+	require.Nil(t, f.Bookmark(ctx, ella.ID, thread, "read soon"))
+	require.Nil(t, f.DeleteThread(ctx, reply[2], mhc, "oops"))
+	require.Nil(t, f.ExpungePost(ctx, gone[2], moderator, "spam"))
+
+	saved, cursor, err := f.ListBookmarks(ctx, ella.ID, nil, 2)
+	require.Nil(t, err)
+	require.Len(t, saved, 2)
+	assert.Equal(t, thread, saved[0].Path)
+	assert.Equal(t, "read soon", saved[0].Note)
+	require.NotNil(t, saved[0].Post)
+	require.NotNil(t, saved[0].Thread)
+	assert.Equal(t, gone[2], saved[0].Thread.ID)
+	assert.Nil(t, saved[1].Post)
+
+	more, cursor, err := f.ListBookmarks(ctx, ella.ID, cursor, 2)
+	require.Nil(t, err)
+	require.Len(t, more, 1)
+	assert.Nil(t, cursor)
+	require.NotNil(t, more[0].Post)
+	assert.NotNil(t, more[0].Post.Deleted)
+
+	require.Nil(t, f.RemoveBookmark(ctx, ella.ID, thread[1]))
+	saved, _, err = f.ListBookmarks(ctx, ella.ID, nil, 10)
+	require.Nil(t, err)
+	assert.Len(t, saved, 2)
+}
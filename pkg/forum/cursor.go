@@ -2,6 +2,11 @@ package forum
 
 import (
 	"cloud.google.com/go/firestore"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"time"
 )
 
@@ -10,11 +15,15 @@ type Cursor interface {
 	direction() firestore.Direction
 	field() string
 	Next(post *Post) Cursor
+}
 
+// tiebreaker is implemented by cursors whose order breaks ties on a second field.
+type tiebreaker interface {
+	tiebreak() (field string, direction firestore.Direction, value interface{})
 }
 
 type CreateTimeAsc struct {
-	tm time.Time
+	tm        time.Time
 	fieldName string
 }
 
@@ -32,11 +41,37 @@ func (tc *CreateTimeAsc) direction() firestore.Direction {
 
 func (tc *CreateTimeAsc) Next(post *Post) Cursor {
 	return &CreateTimeAsc{
-		tm: post.CreateTime,
+		tm:        post.CreateTime,
 		fieldName: "CreateTime",
 	}
 }
 
+// CreateTimeDesc orders by creation time, newest first.
+type CreateTimeDesc struct {
+	tm time.Time
+}
+
+func (tc *CreateTimeDesc) value() interface{} {
+	if tc.tm.IsZero() {
+		return time.Now()
+	}
+	return tc.tm
+}
+
+func (tc *CreateTimeDesc) field() string {
+	return "CreateTime"
+}
+
+func (tc *CreateTimeDesc) direction() firestore.Direction {
+	return firestore.Desc
+}
+
+func (tc *CreateTimeDesc) Next(post *Post) Cursor {
+	return &CreateTimeDesc{
+		tm: post.CreateTime,
+	}
+}
+
 type BumpTimeDesc struct {
 	tm time.Time
 }
@@ -53,7 +88,7 @@ func (tc *BumpTimeDesc) field() string {
 }
 
 func (tc *BumpTimeDesc) Next(post *Post) Cursor {
-	return &CreateTimeAsc{
+	return &BumpTimeDesc{
 		tm: post.Bump.Time,
 	}
 }
@@ -83,3 +118,112 @@ func (i IndexAsc) Next(post *Post) Cursor {
 		val: post.Index,
 	}
 }
+
+// ScoreDesc orders the answers to a Q&A thread by score, highest first. The accepted
+// answer, if any, comes before everything else.
+type ScoreDesc struct {
+	score int
+	tm    time.Time
+	after bool // true once the accepted answer has been returned
+}
+
+func (s *ScoreDesc) value() interface{} {
+	if s.tm.IsZero() {
+		return int64(math.MaxInt64)
+	}
+	return s.score
+}
+
+func (s *ScoreDesc) tiebreak() (string, firestore.Direction, interface{}) {
+	return "CreateTime", firestore.Asc, s.tm
+}
+
+func (s *ScoreDesc) field() string {
+	return "Score"
+}
+
+func (s *ScoreDesc) direction() firestore.Direction {
+	return firestore.Desc
+}
+
+func (s *ScoreDesc) Next(post *Post) Cursor {
+	return &ScoreDesc{
+		score: post.Score,
+		tm:    post.CreateTime,
+		after: true,
+	}
+}
+
+// ErrBadCursor is returned by DecodeCursor for a string EncodeCursor didn't produce.
+var ErrBadCursor = errors.New("bad cursor")
+
+// cursorToken is the serialized form of a cursor.
+type cursorToken struct {
+	Kind  string    `json:"k"`
+	Time  time.Time `json:"t,omitempty"`
+	Int   int       `json:"i,omitempty"`
+	Score float64   `json:"s,omitempty"`
+	ID    string    `json:"id,omitempty"`
+	After bool      `json:"a,omitempty"`
+}
+
+// EncodeCursor returns an opaque string that DecodeCursor turns back into c, so that a
+// client can resume a listing later. A nil cursor, meaning there are no more results,
+// encodes as "".
+func EncodeCursor(c Cursor) string {
+	var t cursorToken
+	switch c := c.(type) {
+	case nil:
+		return ""
+	case *CreateTimeAsc:
+		t = cursorToken{Kind: "ca", Time: c.tm}
+	case *CreateTimeDesc:
+		t = cursorToken{Kind: "cd", Time: c.tm}
+	case *BumpTimeDesc:
+		t = cursorToken{Kind: "bd", Time: c.tm}
+	case *IndexAsc:
+		t = cursorToken{Kind: "ia", Int: c.val}
+	case *ScoreDesc:
+		t = cursorToken{Kind: "sd", Int: c.score, Time: c.tm, After: c.after}
+	case *SearchAfter:
+		t = cursorToken{Kind: "sa", Score: c.score, ID: c.id}
+	default:
+		panic(fmt.Errorf("can't encode cursor of type %T", c))
+	}
+	b, err := json.Marshal(&t)
+	if err != nil {
+		panic(fmt.Errorf("failed to marshal cursor: %w", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor parses a string made by EncodeCursor. The empty string decodes as a nil
+// cursor, which starts a listing from the beginning.
+func DecodeCursor(s string) (Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	var t cursorToken
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, ErrBadCursor
+	}
+	switch t.Kind {
+	case "ca":
+		return &CreateTimeAsc{tm: t.Time, fieldName: "CreateTime"}, nil
+	case "cd":
+		return &CreateTimeDesc{tm: t.Time}, nil
+	case "bd":
+		return &BumpTimeDesc{tm: t.Time}, nil
+	case "ia":
+		return &IndexAsc{val: t.Int}, nil
+	case "sd":
+		return &ScoreDesc{score: t.Int, tm: t.Time, after: t.After}, nil
+	case "sa":
+		return &SearchAfter{score: t.Score, id: t.ID}, nil
+	}
+	return nil, ErrBadCursor
+}
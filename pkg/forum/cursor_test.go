@@ -0,0 +1,35 @@
+package forum
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestEncodeCursor(t *testing.T) {
+	tm := time.Date(2020, 6, 1, 12, 30, 0, 123456789, time.UTC)
+	for _, c := range []Cursor{
+		&CreateTimeAsc{tm: tm, fieldName: "CreateTime"},
+		&CreateTimeDesc{tm: tm},
+		&BumpTimeDesc{tm: tm},
+		&IndexAsc{val: 300},
+		&ScoreDesc{score: -2, tm: tm, after: true},
+		&SearchAfter{score: 2.718281828459045, id: "abc"},
+	} {
+		decoded, err := DecodeCursor(EncodeCursor(c))
+		require.Nil(t, err)
+		assert.Equal(t, c, decoded)
+	}
+
+	assert.Equal(t, "", EncodeCursor(nil))
+	c, err := DecodeCursor("")
+	require.Nil(t, err)
+	assert.Nil(t, c)
+
+	_, err = DecodeCursor("not a cursor!")
+	assert.True(t, errors.Is(err, ErrBadCursor))
+	_, err = DecodeCursor("e30")
+	assert.True(t, errors.Is(err, ErrBadCursor))
+}
@@ -0,0 +1,131 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"errors"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/uniq"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+const Drafts = "Drafts"
+
+// ErrNoDraft is returned for drafts that don't exist or belong to someone else.
+var ErrNoDraft = errors.New("no such draft")
+
+// Draft is a reply that its author has saved but not yet posted.
+type Draft struct {
+	ID         string
+	Parent     []PostID // Path of the post being replied to
+	Subject    string
+	Body       string
+	Author     User
+	CreateTime time.Time `firestore:",serverTimestamp"`
+	EditTime   time.Time `firestore:",serverTimestamp"`
+}
+
+// CreateDraftReply saves a reply to the post at parent without posting it, and returns
+// the draft's ID. Nothing is checked until the draft is installed.
+func (f Forum) CreateDraftReply(ctx Context, parent []PostID, subject string, body string, author User) (string, error) {
+	if len(parent) == 0 {
+		return "", fmt.Errorf("failed to create draft: %w: empty parent path", ErrInvalid)
+	}
+	draft := &Draft{
+		ID:      uniq.Uniq(),
+		Parent:  parent,
+		Subject: subject,
+		Body:    body,
+		Author:  author,
+	}
+	if _, err := f.fs.Collection(Drafts).Doc(draft.ID).Create(ctx, draft); err != nil {
+		return "", fmt.Errorf("failed to create draft: %w", err)
+	}
+	return draft.ID, nil
+}
+
+// GetDrafts returns a user's drafts, most recently edited first.
+func (f Forum) GetDrafts(ctx Context, userID string) ([]*Draft, error) {
+	docs, err := f.fs.
+		Collection(Drafts).
+		Where("Author.ID", "==", userID).
+		OrderBy("EditTime", firestore.Desc).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get drafts: %w", err)
+	}
+	result := make([]*Draft, len(docs))
+	for k, doc := range docs {
+		draft := &Draft{}
+		if err := doc.DataTo(draft); err != nil {
+			return nil, fmt.Errorf("failed to decode draft: %w", err)
+		}
+		result[k] = draft
+	}
+	return result, nil
+}
+
+// UpdateDraft replaces the subject and body of one of a user's drafts.
+func (f Forum) UpdateDraft(ctx Context, userID string, draftID string, subject string, body string) error {
+	if _, err := f.getDraft(ctx, userID, draftID); err != nil {
+		return fmt.Errorf("failed to update draft: %w", err)
+	}
+	_, err := f.fs.Collection(Drafts).Doc(draftID).Update(ctx, []firestore.Update{
+		{Path: "Subject", Value: subject},
+		{Path: "Body", Value: body},
+		{Path: "EditTime", Value: firestore.ServerTimestamp},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update draft: %w", err)
+	}
+	return nil
+}
+
+// DeleteDraft discards one of a user's drafts.
+func (f Forum) DeleteDraft(ctx Context, userID string, draftID string) error {
+	if _, err := f.getDraft(ctx, userID, draftID); err != nil {
+		return fmt.Errorf("failed to delete draft: %w", err)
+	}
+	if _, err := f.fs.Collection(Drafts).Doc(draftID).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete draft: %w", err)
+	}
+	return nil
+}
+
+// InstallReply posts one of a user's drafts as a reply and discards the draft. The reply
+// goes through the same checks as CreateReply, and the draft is kept if they fail.
+func (f Forum) InstallReply(ctx Context, userID string, draftID string) ([]PostID, error) {
+	draft, err := f.getDraft(ctx, userID, draftID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to install reply: %w", err)
+	}
+	path, err := f.CreateReply(ctx, draft.Parent, draft.Subject, draft.Body, draft.Author)
+	if err != nil {
+		return nil, fmt.Errorf("failed to install reply: %w", err)
+	}
+	if _, err := f.fs.Collection(Drafts).Doc(draftID).Delete(ctx); err != nil {
+		return nil, fmt.Errorf("failed to discard installed draft: %w", err)
+	}
+	return path, nil
+}
+
+// getDraft returns ErrNoDraft unless draftID is one of userID's drafts.
+func (f Forum) getDraft(ctx Context, userID string, draftID string) (*Draft, error) {
+	snap, err := f.fs.Collection(Drafts).Doc(draftID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrNoDraft
+	}
+	if err != nil {
+		return nil, err
+	}
+	draft := &Draft{}
+	if err := snap.DataTo(draft); err != nil {
+		return nil, fmt.Errorf("failed to decode draft: %w", err)
+	}
+	if draft.Author.ID != userID {
+		return nil, ErrNoDraft
+	}
+	return draft, nil
+}
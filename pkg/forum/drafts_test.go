@@ -0,0 +1,86 @@
+package forum
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestForum_Drafts(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Hi", "Hello", mhc, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+
+	id, err := f.CreateDraftReply(ctx, thread, "Re: Hi", "Hello bac", ella)
+	require.Nil(t, err)
+	other, err := f.CreateDraftReply(ctx, thread, "Re: Hi", "Never mind", ella)
+	require.Nil(t, err)
+	require.Nil(t, f.UpdateDraft(ctx, ella.ID, id, "Re: Hi", "Hello back"))
+	err = f.UpdateDraft(ctx, mhc.ID, id, "Re: Hi", "Not mine")
+	assert.True(t, errors.Is(err, ErrNoDraft))
+
+	drafts, err := f.GetDrafts(ctx, ella.ID)
+	require.Nil(t, err)
+	require.Len(t, drafts, 2)
+	assert.Equal(t, id, drafts[0].ID)
+	assert.Equal(t, "Hello back", drafts[0].Body)
+
+	_, err = f.InstallReply(ctx, mhc.ID, id)
+	assert.True(t, errors.Is(err, ErrNoDraft))
+	path, err := f.InstallReply(ctx, ella.ID, id)
+	require.Nil(t, err)
+	assert.Equal(t, thread, path[:2])
+	reply, err := f.GetPost(ctx, path[2], View{})
+	require.Nil(t, err)
+	assert.Equal(t, "Hello back", reply.Body)
+	assert.Equal(t, ella.ID, reply.Author.ID)
+
+	require.Nil(t, f.DeleteDraft(ctx, ella.ID, other))
+	drafts, err = f.GetDrafts(ctx, ella.ID)
+	require.Nil(t, err)
+	assert.Empty(t, drafts)
+
+	// A draft saved before its thread was deleted can't be published afterwards, nor
+	// can anything else in the thread be replied to.
+	late, err := f.CreateDraftReply(ctx, path, "Re: Hi", "Too late", ella)
+	require.Nil(t, err)
+	require.Nil(t, f.DeleteThread(ctx, thread[1], mhc, "done"))
+	_, err = f.InstallReply(ctx, ella.ID, late)
+	assert.True(t, errors.Is(err, ErrDeleted))
+	_, err = f.CreateReply(ctx, path, "Re: Hi", "Still here?", mhc)
+	assert.True(t, errors.Is(err, ErrDeleted))
+}
+
+func TestForum_DeletePost(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Hi", "Hello", mhc, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+	reply, err := f.CreateReply(ctx, thread, "Hi", "Hello back", ella)
+	require.Nil(t, err)
+
+	err = f.DeletePost(ctx, reply[2], mhc, "not yours")
+	assert.True(t, errors.Is(err, ErrNotPermitted))
+	err = f.DeletePost(ctx, gen[0], mhc, "sections are for moderators")
+	assert.True(t, errors.Is(err, ErrNotPermitted))
+	require.Nil(t, f.DeletePost(ctx, reply[2], ella, "oops"))
+	require.Nil(t, f.DeletePost(ctx, thread[1], moderator, "cleanup"))
+
+	post, err := f.GetPost(ctx, reply[2], View{})
+	require.Nil(t, err)
+	require.NotNil(t, post.Deleted)
+	assert.Equal(t, "oops", post.Deleted.Why)
+
+	_, err = f.GetPost(ctx, "nonexistent", View{})
+	assert.True(t, errors.Is(err, ErrNotFound))
+	err = f.DeletePost(ctx, "nonexistent", moderator, "")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
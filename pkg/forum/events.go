@@ -0,0 +1,368 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventKind says what happened to a post.
+type EventKind string
+
+const (
+	PostAdded    EventKind = "added"
+	PostEdited   EventKind = "edited"
+	PostDeleted  EventKind = "deleted"
+	ThreadBumped EventKind = "bumped" // A reply was added somewhere in the thread
+)
+
+// ErrFellBehind ends a watch whose reader didn't keep up with events.
+var ErrFellBehind = errors.New("watcher fell behind")
+
+// watchBuffer is how many events a watch holds for its reader.
+const watchBuffer = 64
+
+// Event is a change to a post seen by a watch.
+type Event struct {
+	Kind EventKind
+	Post *Post     // The post after the change
+	Time time.Time // When the change was made
+}
+
+// Position returns where the event is in a watch. Watching since it resumes after the
+// event.
+func (e *Event) Position() Position {
+	return Position{Time: e.Time, Post: e.Post.ID()}
+}
+
+// Position identifies an event, for resuming a watch after it. Changes written together
+// share a time, so the post ID breaks ties. The zero Position means now.
+type Position struct {
+	Time time.Time
+	Post PostID
+}
+
+// IsZero reports whether p is the zero Position.
+func (p Position) IsZero() bool {
+	return p.Time.IsZero() && p.Post == ""
+}
+
+// After reports whether an event at p comes after one at q.
+func (p Position) After(q Position) bool {
+	if !p.Time.Equal(q.Time) {
+		return p.Time.After(q.Time)
+	}
+	return p.Post > q.Post
+}
+
+// Watch is a stream of events. C is closed when the watch ends, after which Err reports
+// why.
+type Watch struct {
+	C      <-chan *Event
+	c      chan *Event
+	cancel func()
+	once   sync.Once
+	mu     sync.Mutex
+	err    error
+}
+
+func newWatch(ctx Context, buffer int) (*Watch, Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	c := make(chan *Event, buffer)
+	return &Watch{C: c, c: c, cancel: cancel}, ctx
+}
+
+// Stop ends the watch.
+func (w *Watch) Stop() {
+	w.cancel()
+}
+
+// Err returns the error that ended the watch, or nil if it was stopped or its context
+// was done.
+func (w *Watch) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *Watch) finish(err error) {
+	w.once.Do(func() {
+		w.mu.Lock()
+		w.err = err
+		w.mu.Unlock()
+		w.cancel()
+		close(w.c)
+	})
+}
+
+// watchScope says which posts a watch is about.
+type watchScope struct {
+	section PostID // Threads in this section
+	thread  PostID // This thread and its replies
+}
+
+func (s watchScope) matches(p *Post) bool {
+	if s.section != "" {
+		return p.Parent == s.section
+	}
+	return containsID(p.Path, s.thread)
+}
+
+// WatchSection watches the threads in a section: new threads, edits and deletions, and
+// bumps when someone replies. If since is zero the watch starts now; otherwise it starts
+// with whatever changed after since, oldest first, so that a reader can resume from the
+// Position of the last event it saw. Several changes to a post while nobody was watching
+// are reported as one event.
+func (f Forum) WatchSection(ctx Context, sectionID PostID, since Position, view View) (*Watch, error) {
+	query := f.fs.Collection(Root).Where("Parent", "==", sectionID)
+	w, err := f.watch(ctx, watchScope{section: sectionID}, query, since, view)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch section %s: %w", sectionID, err)
+	}
+	return w, nil
+}
+
+// WatchThread watches a thread and its replies, like WatchSection.
+func (f Forum) WatchThread(ctx Context, threadID PostID, since Position, view View) (*Watch, error) {
+	query := f.fs.Collection(Root).Where("Path", "array-contains", threadID)
+	w, err := f.watch(ctx, watchScope{thread: threadID}, query, since, view)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch thread %s: %w", threadID, err)
+	}
+	return w, nil
+}
+
+func (f Forum) watch(ctx Context, scope watchScope, query firestore.Query, since Position, view View) (*Watch, error) {
+	r, err := f.newReader(ctx, view)
+	if err != nil {
+		return nil, err
+	}
+	// Each watcher gets its own copy of the post, since canSee may mark it collapsed.
+	keep := func(e *Event) *Event {
+		if !scope.matches(e.Post) {
+			return nil
+		}
+		p := *e.Post
+		if !r.canSee(&p) {
+			return nil
+		}
+		return &Event{Kind: e.Kind, Post: &p, Time: e.Time}
+	}
+	if f.broker != nil {
+		return f.broker.subscribe(ctx, since, keep), nil
+	}
+	w, ctx := newWatch(ctx, watchBuffer)
+	go f.listen(ctx, w, query, since, keep)
+	return w, nil
+}
+
+// listen turns the snapshots of query into events for w until ctx is done. Firestore
+// reports the changes in a snapshot in no particular order, so they are sent in order of
+// Position, which resuming relies on.
+func (f Forum) listen(ctx Context, w *Watch, query firestore.Query, since Position, keep func(*Event) *Event) {
+	iter := query.Snapshots(ctx)
+	defer iter.Stop()
+	known := make(map[PostID]*Post)
+	first := true
+	for {
+		snap, err := iter.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				err = nil
+			}
+			w.finish(err)
+			return
+		}
+		var events []*Event
+		for _, change := range snap.Changes {
+			post := &Post{}
+			if err := change.Doc.DataTo(post); err != nil {
+				w.finish(fmt.Errorf("failed to decode post: %w", err))
+				return
+			}
+			event := &Event{Post: post, Time: change.Doc.UpdateTime}
+			switch {
+			case change.Kind == firestore.DocumentRemoved:
+				// Expunged, or moved elsewhere. Either way it's gone from here.
+				delete(known, post.ID())
+				event.Kind = PostDeleted
+				event.Time = snap.ReadTime
+			case first:
+				known[post.ID()] = post
+				if since.IsZero() || !event.Position().After(since) {
+					continue
+				}
+				event.Kind = classifyChange(nil, post, since.Time)
+			case change.Kind == firestore.DocumentAdded:
+				known[post.ID()] = post
+				event.Kind = PostAdded
+			default:
+				before := known[post.ID()]
+				event.Kind = classifyChange(before, post, since.Time)
+				known[post.ID()] = post
+				if event.Kind == PostDeleted && post.Deleted == nil {
+					// Hidden rather than deleted, so watchers are told about the post as they
+					// last saw it.
+					event.Post = before
+				}
+			}
+			if event.Kind == "" {
+				continue
+			}
+			if event = keep(event); event != nil {
+				events = append(events, event)
+			}
+		}
+		sort.Slice(events, func(i, j int) bool {
+			return events[j].Position().After(events[i].Position())
+		})
+		for _, event := range events {
+			select {
+			case w.c <- event:
+			case <-ctx.Done():
+				w.finish(nil)
+				return
+			}
+		}
+		first = false
+	}
+}
+
+// classifyChange works out what happened to a post from its state before and after.
+// With no earlier state, it reports the most significant change since a time. It
+// returns "" for changes nobody watching needs to hear about, such as view counts.
+func classifyChange(before *Post, after *Post, since time.Time) EventKind {
+	if before == nil {
+		switch {
+		case after.Deleted != nil:
+			return PostDeleted
+		case after.CreateTime.After(since):
+			return PostAdded
+		case after.EditTime.After(since):
+			return PostEdited
+		case after.Bump != nil && after.Bump.Time.After(since):
+			return ThreadBumped
+		}
+		return ""
+	}
+	switch {
+	case after.Deleted != nil && before.Deleted == nil:
+		return PostDeleted
+	case before.hidden() && !after.hidden():
+		// Approved, or released from a shadow ban: it's new to everyone else.
+		return PostAdded
+	case !before.hidden() && after.hidden():
+		return PostDeleted
+	case !after.EditTime.Equal(before.EditTime):
+		return PostEdited
+	case after.Bump != nil && (before.Bump == nil || !after.Bump.Time.Equal(before.Bump.Time)):
+		return ThreadBumped
+	}
+	return ""
+}
+
+// SetBroker makes the forum publish every change it makes to broker, and serve watches
+// from it instead of from database listeners. That suits storage that has no listeners
+// of its own, but a watch then only sees changes made through this client.
+func (f *Forum) SetBroker(broker *Broker) {
+	f.broker = broker
+}
+
+// Broker passes events from the write path to watchers in the same process. It keeps
+// recent events so that watchers can resume.
+type Broker struct {
+	mu       sync.Mutex
+	history  []*Event // Oldest first
+	capacity int
+	last     time.Time // Time of the latest event
+	watches  map[*Watch]func(*Event) *Event
+}
+
+// NewBroker returns a broker that remembers the last history events.
+func NewBroker(history int) *Broker {
+	return &Broker{capacity: history, watches: make(map[*Watch]func(*Event) *Event)}
+}
+
+func (b *Broker) subscribe(ctx Context, since Position, keep func(*Event) *Event) *Watch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w, ctx := newWatch(ctx, watchBuffer+len(b.history))
+	if !since.IsZero() {
+		for _, e := range b.history {
+			if e.Position().After(since) {
+				if e := keep(e); e != nil {
+					w.c <- e
+				}
+			}
+		}
+	}
+	b.watches[w] = keep
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.watches, w)
+		b.mu.Unlock()
+		w.finish(nil)
+	}()
+	return w
+}
+
+// publish delivers an event to every interested watcher. It never blocks: a watcher
+// whose buffer is full is ended with ErrFellBehind.
+func (b *Broker) publish(e *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Event times resume watches, so each must be later than the last.
+	e.Time = e.Time.Round(0)
+	if !e.Time.After(b.last) {
+		e.Time = b.last.Add(time.Nanosecond)
+	}
+	b.last = e.Time
+	if b.capacity > 0 {
+		if len(b.history) == b.capacity {
+			b.history = b.history[1:]
+		}
+		b.history = append(b.history, e)
+	}
+	for w, keep := range b.watches {
+		e := keep(e)
+		if e == nil {
+			continue
+		}
+		select {
+		case w.c <- e:
+		default:
+			delete(b.watches, w)
+			w.finish(ErrFellBehind)
+		}
+	}
+}
+
+// publish tells the broker, if there is one, about a change the forum has just made to
+// post. A new reply also bumps its thread. Events are best effort: the change has
+// already been committed, so failing to read the bumped thread is not an error.
+func (f Forum) publish(ctx Context, kind EventKind, post *Post) {
+	if f.broker == nil || post.hidden() {
+		return
+	}
+	now := time.Now()
+	p := *post
+	if kind == PostAdded && p.CreateTime.IsZero() {
+		p.CreateTime = now
+		p.EditTime = now
+	}
+	if kind == PostEdited {
+		p.EditTime = now
+	}
+	f.broker.publish(&Event{Kind: kind, Post: &p, Time: now})
+	if kind == PostAdded && !p.isSection() && p.threadID() != p.ID() {
+		thread, err := f.getPost(ctx, p.threadID())
+		if err == nil {
+			f.broker.publish(&Event{Kind: ThreadBumped, Post: thread, Time: now})
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package forum
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// expectEvent reads events from w until one of the given kind arrives for postID.
+func expectEvent(t *testing.T, w *Watch, kind EventKind, postID PostID) *Event {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case e, ok := <-w.C:
+			require.True(t, ok, "watch ended: %v", w.Err())
+			if e.Kind == kind && e.Post.ID() == postID {
+				return e
+			}
+		case <-timeout:
+			require.FailNow(t, "no event", "%s %s", kind, postID)
+		}
+	}
+}
+
+func TestPosition(t *testing.T) {
+	now := time.Now()
+	// A reply and the thread it bumps are written together, so only their IDs order them.
+	reply, thread := Position{Time: now, Post: "b"}, Position{Time: now, Post: "a"}
+	assert.True(t, reply.After(thread))
+	assert.False(t, thread.After(reply))
+	assert.False(t, reply.After(reply))
+	assert.True(t, Position{Time: now.Add(time.Nanosecond)}.After(reply))
+	assert.True(t, Position{}.IsZero())
+	assert.False(t, thread.IsZero())
+}
+
+func TestClassifyChange(t *testing.T) {
+	pending := &Post{Pending: &PendingInfo{Reason: "held"}}
+	shadowed := &Post{Shadow: true}
+	visible := &Post{}
+	assert.Equal(t, PostAdded, classifyChange(pending, visible, time.Time{}))
+	assert.Equal(t, PostAdded, classifyChange(shadowed, visible, time.Time{}))
+	assert.Equal(t, PostDeleted, classifyChange(visible, pending, time.Time{}))
+	assert.Equal(t, EventKind(""), classifyChange(visible, visible, time.Time{}))
+}
+
+func TestForum_WatchThread(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Hi", "Hello", mhc, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+
+	w, err := f.WatchThread(ctx, thread[1], Position{}, View{})
+	require.Nil(t, err)
+	defer w.Stop()
+	reply, err := f.CreateReply(ctx, thread, "Re: Hi", "Hello back", ella)
+	require.Nil(t, err)
+	added := expectEvent(t, w, PostAdded, reply[2])
+	assert.Equal(t, "Hello back", added.Post.Body)
+	expectEvent(t, w, ThreadBumped, thread[1])
+	require.Nil(t, f.UpdateThread(ctx, thread[1], "Hi", "Hello!", mhc, nil))
+	expectEvent(t, w, PostEdited, thread[1])
+	require.Nil(t, f.DeleteThread(ctx, reply[2], ella, "oops"))
+	deleted := expectEvent(t, w, PostDeleted, reply[2])
+
+	// Resuming after the reply was added picks up the edit and the deletion.
+	resumed, err := f.WatchThread(ctx, thread[1], added.Position(), View{})
+	require.Nil(t, err)
+	defer resumed.Stop()
+	expectEvent(t, resumed, PostEdited, thread[1])
+	expectEvent(t, resumed, PostDeleted, reply[2])
+	assert.False(t, deleted.Time.Before(added.Time))
+
+	w.Stop()
+	for range w.C {
+	}
+	assert.Nil(t, w.Err())
+}
+
+func TestForum_WatchApproval(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	held, err := f.CreateSection(ctx, "Held", "Moderated", 100, mhc, SectionOptions{Moderated: true})
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Hi", "Hello", moderator, held[0], ThreadOptions{})
+	require.Nil(t, err)
+
+	w, err := f.WatchThread(ctx, thread[1], Position{}, View{})
+	require.Nil(t, err)
+	defer w.Stop()
+	reply, err := f.CreateReply(ctx, thread, "Re: Hi", "Hello back", ella)
+	require.Nil(t, err)
+	require.Nil(t, f.ApprovePost(ctx, reply[2], moderator))
+	added := expectEvent(t, w, PostAdded, reply[2])
+	assert.Nil(t, added.Post.Pending)
+}
+
+func TestForum_WatchSectionBroker(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	f.SetBroker(NewBroker(100))
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	other, err := f.CreateSection(ctx, "Other", "More chat", 200, mhc, SectionOptions{})
+	require.Nil(t, err)
+
+	w, err := f.WatchSection(ctx, gen[0], Position{}, View{})
+	require.Nil(t, err)
+	defer w.Stop()
+	elsewhere, err := f.CreateThread(ctx, "Elsewhere", "Not here", mhc, other[0], ThreadOptions{})
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Hi", "Hello", mhc, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+	added := <-w.C
+	assert.Equal(t, PostAdded, added.Kind)
+	assert.Equal(t, thread[1], added.Post.ID())
+	assert.NotEqual(t, elsewhere[1], added.Post.ID())
+
+	_, err = f.CreateReply(ctx, thread, "Re: Hi", "Hello back", ella)
+	require.Nil(t, err)
+	bumped := <-w.C
+	assert.Equal(t, ThreadBumped, bumped.Kind)
+	assert.Equal(t, thread[1], bumped.Post.ID())
+	assert.Equal(t, ella.ID, bumped.Post.Bump.Author.ID)
+
+	require.Nil(t, f.DeleteThread(ctx, thread[1], mhc, "done"))
+	assert.Equal(t, PostDeleted, (<-w.C).Kind)
+
+	resumed, err := f.WatchSection(ctx, gen[0], added.Position(), View{})
+	require.Nil(t, err)
+	defer resumed.Stop()
+	assert.Equal(t, ThreadBumped, (<-resumed.C).Kind)
+	assert.Equal(t, PostDeleted, (<-resumed.C).Kind)
+}
+
+func TestBroker_FellBehind(t *testing.T) {
+	b := NewBroker(0)
+	all := func(e *Event) *Event { return e }
+	w := b.subscribe(ctx, Position{}, all)
+	post := &Post{Path: []PostID{"s", "t"}}
+	for k := 0; k <= watchBuffer; k++ {
+		b.publish(&Event{Kind: PostEdited, Post: post, Time: time.Now()})
+	}
+	n := 0
+	for range w.C {
+		n++
+	}
+	assert.Equal(t, watchBuffer, n)
+	assert.True(t, errors.Is(w.Err(), ErrFellBehind))
+}
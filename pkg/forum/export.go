@@ -0,0 +1,377 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrImportOrder means an imported post came before its parent, or its path doesn't
+	// agree with its parent's.
+	ErrImportOrder = errors.New("post out of order")
+	// ErrImportConflict means an imported post already exists, and the import was told
+	// to fail rather than skip or overwrite it.
+	ErrImportConflict = errors.New("post already exists")
+	// ErrImportOrphan means an imported post's parent is neither earlier in the import nor
+	// in the forum. It is also an ErrImportOrder.
+	ErrImportOrphan = fmt.Errorf("%w: parent not found", ErrImportOrder)
+)
+
+// importBatch is how many posts are written at a time. Firestore allows 500 writes in a
+// batch, and each post may also update a few tags.
+const importBatch = 100
+
+// Export writes every post, including deleted and hidden ones, to w as JSON Lines, one
+// Post per line. Every post comes after its parent, so that Import can restore them in
+// order. Posts whose parent no longer exists, because it was expunged, come last; Import
+// skips them with SkipOrphans.
+func (f Forum) Export(ctx Context, w io.Writer) error {
+	iter := f.fs.Collection(Root).OrderBy("CreateTime", firestore.Asc).Documents(ctx)
+	defer iter.Stop()
+	enc := json.NewEncoder(w)
+	written := make(map[PostID]bool)
+	// Posts whose parent hasn't been written yet, by parent. Creation order is nearly
+	// always parent first, but a thread moved to a newer section is older than it.
+	waiting := make(map[PostID][]*Post)
+	var write func(p *Post) error
+	write = func(p *Post) error {
+		if err := enc.Encode(p); err != nil {
+			return err
+		}
+		written[p.ID()] = true
+		children := waiting[p.ID()]
+		delete(waiting, p.ID())
+		for _, c := range children {
+			if err := write(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to export posts: %w", err)
+		}
+		post := &Post{}
+		if err := doc.DataTo(post); err != nil {
+			return fmt.Errorf("failed to decode post %s: %w", doc.Ref.ID, err)
+		}
+		if post.Parent != "" && !written[post.Parent] {
+			waiting[post.Parent] = append(waiting[post.Parent], post)
+			continue
+		}
+		if err := write(post); err != nil {
+			return fmt.Errorf("failed to export posts: %w", err)
+		}
+	}
+	var orphans []*Post
+	for _, posts := range waiting {
+		orphans = append(orphans, posts...)
+	}
+	sort.Slice(orphans, func(i, j int) bool { return len(orphans[i].Path) < len(orphans[j].Path) })
+	for _, p := range orphans {
+		if written[p.ID()] {
+			continue
+		}
+		if err := write(p); err != nil {
+			return fmt.Errorf("failed to export posts: %w", err)
+		}
+	}
+	return nil
+}
+
+// ConflictPolicy says what Import does with a post that already exists.
+type ConflictPolicy int
+
+const (
+	ConflictFail      ConflictPolicy = iota // Stop with ErrImportConflict
+	ConflictSkip                            // Keep the existing post
+	ConflictOverwrite                       // Replace the existing post
+)
+
+// ImportOptions control Import.
+type ImportOptions struct {
+	OnConflict ConflictPolicy
+	// DryRun checks the posts and reports what would happen without writing anything.
+	DryRun bool
+	// SkipOrphans leaves out posts whose parent is neither earlier in the import nor in
+	// the forum, and anything under them, instead of failing with ErrImportOrphan.
+	SkipOrphans bool
+}
+
+// ImportStats says what Import did, or with DryRun, would do.
+type ImportStats struct {
+	Read        int
+	Created     int
+	Overwritten int
+	Skipped     int // Already existed
+	Orphans     int // Left out with SkipOrphans
+	Rebuilt     int // Posts whose counters were recalculated
+}
+
+// Import reads posts written by Export and adds them to the forum with the same IDs,
+// paths and timestamps. Each post's parent must come before it, or already be in the
+// forum. Afterwards, the reply counts and bumps of every post with imported posts under
+// it are recalculated from what is actually there, as are tag counts.
+//
+// Posts are written in batches as they are read, so if Import fails part way, the posts
+// before the failure stay imported. A dry run first finds most problems, and importing
+// again with ConflictSkip or ConflictOverwrite picks up where a failure left off.
+func (f Forum) Import(ctx Context, r io.Reader, opts ImportOptions) (*ImportStats, error) {
+	im := &importer{
+		f:        f,
+		opts:     opts,
+		seen:     make(map[PostID]bool),
+		paths:    make(map[PostID][]PostID),
+		affected: make(map[PostID]bool),
+	}
+	dec := json.NewDecoder(r)
+	var batch []*Post
+	for line := 1; ; line++ {
+		post := &Post{}
+		err := dec.Decode(post)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &im.stats, fmt.Errorf("failed to import post %d: %w", line, err)
+		}
+		im.stats.Read++
+		if err := im.check(ctx, post); err != nil {
+			if opts.SkipOrphans && errors.Is(err, ErrImportOrphan) {
+				im.stats.Orphans++
+				continue
+			}
+			return &im.stats, fmt.Errorf("failed to import post %d: %w", line, err)
+		}
+		batch = append(batch, post)
+		if len(batch) == importBatch {
+			if err := im.write(ctx, batch); err != nil {
+				return &im.stats, err
+			}
+			batch = nil
+		}
+	}
+	if err := im.write(ctx, batch); err != nil {
+		return &im.stats, err
+	}
+	if err := im.rebuild(ctx); err != nil {
+		return &im.stats, err
+	}
+	return &im.stats, nil
+}
+
+type importer struct {
+	f     Forum
+	opts  ImportOptions
+	stats ImportStats
+	seen  map[PostID]bool // IDs of posts read so far
+	// paths of posts read so far, and of parents already in the forum
+	paths map[PostID][]PostID
+	// posts with imported posts under them, whose counters need rebuilding
+	affected map[PostID]bool
+}
+
+// check verifies that a post is well formed and that its parent has been seen.
+func (im *importer) check(ctx Context, p *Post) error {
+	if len(p.Path) == 0 {
+		return fmt.Errorf("%w: empty path", ErrImportOrder)
+	}
+	for _, id := range p.Path {
+		if id == "" || strings.Contains(id, "/") {
+			return fmt.Errorf("%w: bad ID %q in path", ErrImportOrder, id)
+		}
+	}
+	id := p.ID()
+	if im.seen[id] {
+		return fmt.Errorf("%w: %s appears twice", ErrImportOrder, id)
+	}
+	if len(p.Path) == 1 {
+		if p.Parent != "" {
+			return fmt.Errorf("%w: %s has parent %s but no path to it", ErrImportOrder, id, p.Parent)
+		}
+	} else {
+		parent := p.Path[len(p.Path)-2]
+		if p.Parent != parent {
+			return fmt.Errorf("%w: %s has parent %s but path %v", ErrImportOrder, id, p.Parent, p.Path)
+		}
+		parentPath, err := im.parentPath(ctx, parent)
+		if err != nil {
+			return err
+		}
+		if !samePath(parentPath, p.Path[:len(p.Path)-1]) {
+			return fmt.Errorf("%w: path of %s doesn't agree with its parent's", ErrImportOrder, id)
+		}
+	}
+	im.seen[id] = true
+	im.paths[id] = p.Path
+	for _, ancestor := range p.Path[:len(p.Path)-1] {
+		im.affected[ancestor] = true
+	}
+	return nil
+}
+
+// parentPath returns the path of a post seen earlier in the import, or already in the
+// forum.
+func (im *importer) parentPath(ctx Context, parent PostID) ([]PostID, error) {
+	if path, ok := im.paths[parent]; ok {
+		return path, nil
+	}
+	doc, err := im.f.fs.Collection(Root).Doc(parent).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%w: %s comes after its children, or no longer exists", ErrImportOrphan, parent)
+	}
+	if err != nil {
+		return nil, err
+	}
+	post := &Post{}
+	if err := doc.DataTo(post); err != nil {
+		return nil, fmt.Errorf("failed to decode post %s: %w", parent, err)
+	}
+	im.paths[parent] = post.Path
+	return post.Path, nil
+}
+
+func samePath(a []PostID, b []PostID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if a[k] != b[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// write imports a batch of posts, dealing with those that already exist as the options
+// say.
+func (im *importer) write(ctx Context, posts []*Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	refs := make([]*firestore.DocumentRef, len(posts))
+	for k, p := range posts {
+		refs[k] = im.f.fs.Collection(Root).Doc(p.ID())
+	}
+	existing, err := im.f.fs.GetAll(ctx, refs)
+	if err != nil {
+		return fmt.Errorf("failed to import posts: %w", err)
+	}
+	wb := im.f.fs.Batch()
+	tags := make(map[string]int)
+	var written []*Post
+	for k, p := range posts {
+		var before *Post
+		if existing[k].Exists() {
+			switch im.opts.OnConflict {
+			case ConflictSkip:
+				im.stats.Skipped++
+				continue
+			case ConflictOverwrite:
+				before = &Post{}
+				if err := existing[k].DataTo(before); err != nil {
+					return fmt.Errorf("failed to decode post %s: %w", p.ID(), err)
+				}
+				im.stats.Overwritten++
+			default:
+				return fmt.Errorf("failed to import post %s: %w", p.ID(), ErrImportConflict)
+			}
+		} else {
+			im.stats.Created++
+		}
+		// Deleted posts no longer count toward their tags.
+		var oldTags, newTags []string
+		if before != nil && before.Deleted == nil {
+			oldTags = before.Tags
+		}
+		if p.Deleted == nil {
+			newTags = p.Tags
+		}
+		for tag, delta := range tagDeltas(oldTags, newTags) {
+			tags[tag] += delta
+		}
+		wb.Set(refs[k], p)
+		written = append(written, p)
+	}
+	if im.opts.DryRun || len(written) == 0 {
+		return nil
+	}
+	for tag, delta := range tags {
+		if delta != 0 {
+			wb.Set(im.f.fs.Collection(Tags).Doc(tag), tagCount(tag, delta), firestore.MergeAll)
+		}
+	}
+	if _, err := wb.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to import posts: %w", err)
+	}
+	for _, p := range written {
+		im.f.indexPost(p)
+	}
+	return nil
+}
+
+// rebuild recalculates the counters of every post with imported posts under it: how
+// many children and descendants it has, and which was added last. As when posts are
+// added normally, hidden posts don't count, but deleted ones do.
+func (im *importer) rebuild(ctx Context) error {
+	if im.opts.DryRun {
+		im.stats.Rebuilt = len(im.affected)
+		return nil
+	}
+	for id := range im.affected {
+		docs, err := im.f.fs.Collection(Root).Where("Path", "array-contains", id).Documents(ctx).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to rebuild counters of %s: %w", id, err)
+		}
+		children, descendants := 0, 0
+		var latest *Post
+		for _, doc := range docs {
+			p := &Post{}
+			if err := doc.DataTo(p); err != nil {
+				return fmt.Errorf("failed to decode post %s: %w", doc.Ref.ID, err)
+			}
+			if p.ID() == id || p.hidden() {
+				continue
+			}
+			descendants++
+			if p.Parent == id {
+				children++
+			}
+			if latest == nil || p.CreateTime.After(latest.CreateTime) {
+				latest = p
+			}
+		}
+		updates := []firestore.Update{
+			{Path: "ChildCount", Value: children},
+			{Path: "DescendentCount", Value: descendants},
+		}
+		if latest != nil {
+			bump := map[string]interface{}{
+				"ID":     latest.ID(),
+				"Head":   latest.Head,
+				"Author": latest.Author,
+				"Time":   latest.CreateTime,
+			}
+			updates = append(updates, firestore.Update{Path: "Bump", Value: bump})
+		}
+		_, err = im.f.fs.Collection(Root).Doc(id).Update(ctx, updates)
+		if err != nil {
+			return fmt.Errorf("failed to rebuild counters of %s: %w", id, err)
+		}
+		im.stats.Rebuilt++
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package forum
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestForum_ExportImport(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Hi", "Hello", ella, gen[0], ThreadOptions{Tags: []string{"intro"}})
+	require.Nil(t, err)
+	reply, err := f.CreateReply(ctx, thread, "Hi", "Hello back", mhc)
+	require.Nil(t, err)
+	nested, err := f.CreateReply(ctx, reply, "Hi", "And again", ella)
+	require.Nil(t, err)
+	oops, err := f.CreateReply(ctx, thread, "Hi", "Oops", ella)
+	require.Nil(t, err)
+	require.Nil(t, f.DeleteThread(ctx, oops[2], ella, "mistake"))
+	before, err := f.getPost(ctx, thread[1])
+	require.Nil(t, err)
+
+	var buf bytes.Buffer
+	require.Nil(t, f.Export(ctx, &buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 5)
+	seen := make(map[PostID]bool)
+	for _, line := range lines {
+		p := &Post{}
+		require.Nil(t, json.Unmarshal([]byte(line), p))
+		if p.Parent != "" {
+			assert.True(t, seen[p.Parent], "%s before its parent", p.ID())
+		}
+		seen[p.ID()] = true
+	}
+	export := buf.String()
+
+	// Everything already exists.
+	_, err = f.Import(ctx, strings.NewReader(export), ImportOptions{})
+	assert.True(t, errors.Is(err, ErrImportConflict))
+	stats, err := f.Import(ctx, strings.NewReader(export), ImportOptions{OnConflict: ConflictSkip})
+	require.Nil(t, err)
+	assert.Equal(t, 5, stats.Skipped)
+
+	f.expunge(ctx)
+	stats, err = f.Import(ctx, strings.NewReader(export), ImportOptions{DryRun: true})
+	require.Nil(t, err)
+	assert.Equal(t, 5, stats.Created)
+	_, err = f.getPost(ctx, thread[1])
+	assert.NotNil(t, err)
+
+	stats, err = f.Import(ctx, strings.NewReader(export), ImportOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, ImportStats{Read: 5, Created: 5, Rebuilt: 3}, *stats)
+	after, err := f.getPost(ctx, thread[1])
+	require.Nil(t, err)
+	assert.Equal(t, before.Path, after.Path)
+	assert.True(t, before.CreateTime.Equal(after.CreateTime))
+	assert.Equal(t, 3, after.DescendentCount)
+	assert.Equal(t, 2, after.ChildCount)
+	assert.Equal(t, oops[2], after.Bump.ID)
+	deleted, err := f.getPost(ctx, oops[2])
+	require.Nil(t, err)
+	require.NotNil(t, deleted.Deleted)
+	assert.Equal(t, "mistake", deleted.Deleted.Why)
+	restored, err := f.getPost(ctx, nested[3])
+	require.Nil(t, err)
+	assert.Equal(t, nested, restored.Path)
+	tag, err := f.getTag(ctx, "intro")
+	require.Nil(t, err)
+	assert.Equal(t, 1, tag.Count)
+
+	stats, err = f.Import(ctx, strings.NewReader(export), ImportOptions{OnConflict: ConflictOverwrite})
+	require.Nil(t, err)
+	assert.Equal(t, 5, stats.Overwritten)
+	tag, err = f.getTag(ctx, "intro")
+	require.Nil(t, err)
+	assert.Equal(t, 1, tag.Count)
+}
+
+func TestForum_ImportOrder(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	encode := func(posts ...*Post) string {
+		var buf bytes.Buffer
+		for _, p := range posts {
+			require.Nil(t, json.NewEncoder(&buf).Encode(p))
+		}
+		return buf.String()
+	}
+	section := &Post{Path: []PostID{"s"}, Head: "Section"}
+	thread := &Post{Path: []PostID{"s", "t"}, Parent: "s", Head: "Thread", Sections: 1}
+
+	_, err = f.Import(ctx, strings.NewReader(encode(thread, section)), ImportOptions{DryRun: true})
+	assert.True(t, errors.Is(err, ErrImportOrder))
+	bad := &Post{Path: []PostID{"s", "t"}, Parent: "x", Head: "Thread"}
+	_, err = f.Import(ctx, strings.NewReader(encode(section, bad)), ImportOptions{DryRun: true})
+	assert.True(t, errors.Is(err, ErrImportOrder))
+	_, err = f.Import(ctx, strings.NewReader(encode(section, section)), ImportOptions{DryRun: true})
+	assert.True(t, errors.Is(err, ErrImportOrder))
+	_, err = f.Import(ctx, strings.NewReader("{not json"), ImportOptions{DryRun: true})
+	assert.NotNil(t, err)
+
+	// Posts whose parent was expunged are left out, along with their replies, if asked.
+	orphan := &Post{Path: []PostID{"s", "gone", "o"}, Parent: "gone", Head: "Orphan", Sections: 1}
+	orphanReply := &Post{Path: []PostID{"s", "gone", "o", "r"}, Parent: "o", Head: "Reply", Sections: 1}
+	orphans := encode(section, thread, orphan, orphanReply)
+	_, err = f.Import(ctx, strings.NewReader(orphans), ImportOptions{DryRun: true})
+	assert.True(t, errors.Is(err, ErrImportOrphan))
+	stats, err := f.Import(ctx, strings.NewReader(orphans), ImportOptions{DryRun: true, SkipOrphans: true})
+	require.Nil(t, err)
+	assert.Equal(t, 2, stats.Created)
+	assert.Equal(t, 2, stats.Orphans)
+
+	stats, err = f.Import(ctx, strings.NewReader(encode(section, thread)), ImportOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, 2, stats.Created)
+	// A parent already in the forum needn't be in the import.
+	reply := &Post{Path: []PostID{"s", "t", "r"}, Parent: "t", Head: "Reply", Sections: 1}
+	_, err = f.Import(ctx, strings.NewReader(encode(reply)), ImportOptions{})
+	require.Nil(t, err)
+	s, err := f.getPost(ctx, "s")
+	require.Nil(t, err)
+	assert.Equal(t, 2, s.DescendentCount)
+	assert.Equal(t, 1, s.ChildCount)
+}
@@ -0,0 +1,186 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"errors"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/search"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var ErrRejected = errors.New("post rejected")
+
+// Verdict is a content filter's decision about a post.
+type Verdict int
+
+const (
+	Accept Verdict = iota // Let the post through
+	Hold                  // Keep the post hidden until a moderator approves it
+	Reject                // Refuse the post
+)
+
+// ContentFilter screens posts before they are stored. It is given new threads and
+// replies, and threads as they will be after an edit.
+type ContentFilter interface {
+	// Filter returns a verdict on post and, unless it accepts the post, the reason.
+	Filter(ctx Context, post *Post) (Verdict, string, error)
+}
+
+// FilterFunc adapts a function to a ContentFilter.
+type FilterFunc func(ctx Context, post *Post) (Verdict, string, error)
+
+func (fn FilterFunc) Filter(ctx Context, post *Post) (Verdict, string, error) {
+	return fn(ctx, post)
+}
+
+// PendingInfo records why a post is held for review.
+type PendingInfo struct {
+	Reason   string
+	Edit     bool      // The post was visible before, and was held after an edit
+	Approved *Revision // With Edit, the content before the edit, restored if it is rejected
+	Time     time.Time
+}
+
+// Revision is the editable content of a post at some point.
+type Revision struct {
+	Head     string
+	Body     string
+	Tags     []string
+	EditTime time.Time
+}
+
+// AddFilter appends a filter to the chain run on new and edited posts.
+func (f *Forum) AddFilter(filter ContentFilter) {
+	f.filters = append(f.filters, filter)
+}
+
+// screen runs post through the filter chain. The first filter to reject the post
+// decides; otherwise the post is held if any filter holds it.
+func (f Forum) screen(ctx Context, post *Post) (Verdict, string, error) {
+	verdict, reason := Accept, ""
+	for _, filter := range f.filters {
+		v, why, err := filter.Filter(ctx, post)
+		if err != nil {
+			return Accept, "", fmt.Errorf("failed to filter post: %w", err)
+		}
+		switch v {
+		case Reject:
+			return Reject, why, nil
+		case Hold:
+			if verdict == Accept {
+				verdict, reason = Hold, why
+			}
+		}
+	}
+	return verdict, reason, nil
+}
+
+// screenNew screens a new post, returning an error wrapping ErrRejected if it is
+// rejected and marking it pending if it is held.
+func (f Forum) screenNew(ctx Context, post *Post) error {
+	verdict, reason, err := f.screen(ctx, post)
+	if err != nil {
+		return err
+	}
+	switch verdict {
+	case Reject:
+		return fmt.Errorf("%w: %s", ErrRejected, reason)
+	case Hold:
+		post.Pending = &PendingInfo{Reason: reason, Time: time.Now()}
+	}
+	return nil
+}
+
+// GetPending returns up to n posts held for review, oldest first.
+func (f Forum) GetPending(ctx Context, moderator User, n int) ([]*Post, error) {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return nil, fmt.Errorf("failed to get pending posts: %w", err)
+	}
+	// Ordering by a field excludes documents without it, which here are the posts that
+	// aren't pending.
+	docs, err := f.fs.
+		Collection(Root).
+		Where("Deleted", "==", nil).
+		OrderBy("Pending.Time", firestore.Asc).
+		Limit(n).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending posts: %w", err)
+	}
+	result := make([]*Post, len(docs))
+	for k, doc := range docs {
+		post := &Post{}
+		if err := doc.DataTo(post); err != nil {
+			return nil, fmt.Errorf("failed to decode post: %w", err)
+		}
+		result[k] = post
+	}
+	return result, nil
+}
+
+// postText returns the words of a post's subject and body, without markup.
+func postText(post *Post) []string {
+	return search.Tokenize(search.StripHTML(post.Head + " " + post.Body))
+}
+
+// BannedWords rejects posts that contain any of words, ignoring case.
+func BannedWords(words ...string) ContentFilter {
+	banned := make(map[string]bool)
+	for _, w := range words {
+		banned[strings.ToLower(w)] = true
+	}
+	return FilterFunc(func(ctx Context, post *Post) (Verdict, string, error) {
+		for _, w := range postText(post) {
+			if banned[w] {
+				return Reject, fmt.Sprintf("contains banned word %q", w), nil
+			}
+		}
+		return Accept, "", nil
+	})
+}
+
+var linkRe = regexp.MustCompile(`(?i)https?://`)
+
+// LinkLimit holds posts with more than max links by users who joined less than age ago.
+// Users whose join time is unknown aren't treated as new.
+func LinkLimit(max int, age time.Duration) ContentFilter {
+	return FilterFunc(func(ctx Context, post *Post) (Verdict, string, error) {
+		if !isNewUser(post.Author, age, time.Now()) {
+			return Accept, "", nil
+		}
+		if n := len(linkRe.FindAllStringIndex(post.Head+" "+post.Body, -1)); n > max {
+			return Hold, fmt.Sprintf("new user posted %d links", n), nil
+		}
+		return Accept, "", nil
+	})
+}
+
+// DuplicateFilter rejects a post whose text is the same as another post by the same
+// author within the last window.
+func (f Forum) DuplicateFilter(window time.Duration) ContentFilter {
+	return FilterFunc(func(ctx Context, post *Post) (Verdict, string, error) {
+		docs, err := f.fs.
+			Collection(Root).
+			Where("Author.ID", "==", post.Author.ID).
+			Where("CreateTime", ">=", time.Now().Add(-window)).
+			Documents(ctx).
+			GetAll()
+		if err != nil {
+			return Accept, "", err
+		}
+		text := strings.Join(postText(post), " ")
+		for _, doc := range docs {
+			other := &Post{}
+			if err := doc.DataTo(other); err != nil {
+				return Accept, "", fmt.Errorf("failed to decode post: %w", err)
+			}
+			if other.ID() != post.ID() && other.Deleted == nil && strings.Join(postText(other), " ") == text {
+				return Reject, "duplicate of " + other.ID(), nil
+			}
+		}
+		return Accept, "", nil
+	})
+}
@@ -0,0 +1,55 @@
+package forum
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestForum_ContentFilters(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	f.AddFilter(BannedWords("Viagra"))
+	f.AddFilter(LinkLimit(1, time.Hour))
+	f.AddFilter(f.DuplicateFilter(time.Hour))
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Hi", "Hello <b>there</b>", mhc, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+
+	_, err = f.CreateReply(ctx, thread, "Re", "Cheap VIAGRA!", ella)
+	assert.True(t, errors.Is(err, ErrRejected))
+	_, err = f.CreateThread(ctx, "Hi", "Hello there", mhc, gen[0], ThreadOptions{})
+	assert.True(t, errors.Is(err, ErrRejected))
+
+	newbie := User{ID: "newbie", Name: "New User", Joined: time.Now()}
+	veteran := User{ID: "veteran", Name: "Old Hand", Joined: time.Now().Add(-48 * time.Hour)}
+	held, err := f.CreateReply(ctx, thread, "Re", "See https://a.com and http://b.com", newbie)
+	require.Nil(t, err)
+	_, err = f.CreateReply(ctx, thread, "Re", "See https://a.com and http://b.com", veteran)
+	require.Nil(t, err)
+
+	replies, _, err := f.GetReplies(ctx, thread[1], nil, 10, View{})
+	require.Nil(t, err)
+	assert.Len(t, replies, 2)
+	pending, err := f.GetPending(ctx, moderator, 10)
+	require.Nil(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, held[2], pending[0].ID())
+	assert.False(t, pending[0].Pending.Edit)
+
+	err = f.UpdateThread(ctx, thread[1], "Hi", "Viagra", mhc, nil)
+	assert.True(t, errors.Is(err, ErrRejected))
+	require.Nil(t, f.UpdateThread(ctx, thread[1], "Hi", "Hello there!", mhc, nil))
+
+	mine, err := f.CreateThread(ctx, "Links", "None yet", newbie, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+	require.Nil(t, f.UpdateThread(ctx, mine[1], "Links", "https://a.com https://b.com", newbie, nil))
+	threads, _, err := f.GetThreads(ctx, gen[0], nil, 10, View{})
+	require.Nil(t, err)
+	require.Len(t, threads, 1)
+	assert.Equal(t, thread[1], threads[0].ID())
+}
@@ -3,9 +3,11 @@ package forum
 import (
 	"cloud.google.com/go/firestore"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/mhcoffin/forum-tools/pkg/uniq"
-	"math"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"time"
 )
 
@@ -14,7 +16,15 @@ const (
 	adminDisplay = "Mikey"
 )
 
-func (f Forum) CreateSection(ctx Context, subject string, description string, index int, author User) ([]PostID, error) {
+// SectionOptions controls how a section behaves.
+type SectionOptions struct {
+	QA          bool // Threads are questions; replies can be voted on and one can be accepted as the answer.
+	CuratedTags bool // Threads may only use tags that a moderator has curated.
+	Moderated   bool // New threads and replies are hidden until a moderator approves them.
+	Visibility  Visibility
+}
+
+func (f Forum) CreateSection(ctx Context, subject string, description string, index int, author User, opts SectionOptions) ([]PostID, error) {
 	post := &Post{
 		Path:            []string{uniq.Uniq()},
 		Parent:          "",
@@ -27,6 +37,11 @@ func (f Forum) CreateSection(ctx Context, subject string, description string, in
 		DescendentCount: 0,
 		ViewCount:       0,
 		Deleted:         nil,
+		QA:              opts.QA,
+		CuratedTags:     opts.CuratedTags,
+		Moderated:       opts.Moderated,
+		Visibility:      opts.Visibility,
+		Sections:        1,
 		CreateTime:      time.Time{},
 		EditTime:        time.Time{},
 	}
@@ -37,17 +52,40 @@ func (f Forum) CreateSection(ctx Context, subject string, description string, in
 	return path, nil
 }
 
-func (f Forum) GetSections(ctx Context) ([]*Post, error) {
-	posts, _, err := f.getChildren(ctx, "", &IndexAsc{val: math.MinInt32}, 100)
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve sections: %w", err)
-	}
-	return posts, nil
+// ThreadOptions holds the optional parts of a new thread.
+type ThreadOptions struct {
+	Poll *Poll    // Poll to attach to the thread, if any
+	Tags []string // Tags for the thread. They are normalized before being stored.
 }
 
-func (f Forum) CreateThread(ctx Context, subject string, body string, author User, sectionId PostID) ([]PostID, error) {
+func (f Forum) CreateThread(ctx Context, subject string, body string, author User, sectionId PostID, opts ThreadOptions) ([]PostID, error) {
+	section, err := f.getPost(ctx, sectionId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create thread: %w", err)
+	}
+	if !section.isSection() {
+		return nil, fmt.Errorf("failed to create thread: %w: %s is not a section", ErrInvalid, sectionId)
+	}
+	if err := f.requireAccess(ctx, author, section); err != nil {
+		return nil, fmt.Errorf("failed to create thread: %w", err)
+	}
+	poll, err := newPoll(opts.Poll)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create thread: %w", err)
+	}
+	tags, err := NormalizeTags(opts.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create thread: %w", err)
+	}
+	if err := f.checkTags(ctx, section, tags); err != nil {
+		return nil, fmt.Errorf("failed to create thread: %w", err)
+	}
+	shadow, err := f.checkSanctions(ctx, author, section.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create thread: %w", err)
+	}
 	post := &Post{
-		Path:            []string{sectionId, uniq.Uniq()},
+		Path:            append(append([]PostID{}, section.Path...), uniq.Uniq()),
 		Head:            subject,
 		Body:            body,
 		Author:          author,
@@ -56,22 +94,47 @@ func (f Forum) CreateThread(ctx Context, subject string, body string, author Use
 		DescendentCount: 0,
 		ViewCount:       0,
 		Deleted:         nil,
+		QA:              section.QA,
+		Poll:            poll,
+		Tags:            tags,
+		Shadow:          shadow,
+		Sections:        len(section.Path),
 		CreateTime:      time.Time{},
 		EditTime:        time.Time{},
 	}
+	if err := f.screenNew(ctx, post); err != nil {
+		return nil, fmt.Errorf("failed to create thread: %w", err)
+	}
+	if err := f.holdForApproval(ctx, section, post); err != nil {
+		return nil, fmt.Errorf("failed to create thread: %w", err)
+	}
+	if err := f.checkRate(ctx, author, true); err != nil {
+		return nil, fmt.Errorf("failed to create thread: %w", err)
+	}
 	path, err := f.addPost(ctx, post)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create thread: %w", err)
 	}
+	// The post is stored, so failing to count it only lets the author post a little more.
+	_ = f.chargeRate(ctx, post, true)
 	return path, nil
 }
 
 // GetThreads retrieves threads, most-recently-bumped thread first.
-func (f Forum) GetThreads(ctx Context, section PostID, cursor Cursor, n int) ([]*Post, Cursor, error) {
+func (f Forum) GetThreads(ctx Context, section PostID, cursor Cursor, n int, view View) ([]*Post, Cursor, error) {
 	if cursor == nil {
 		cursor = &BumpTimeDesc{}
 	}
-	posts, cursor, err := f.getChildren(ctx, section, &BumpTimeDesc{}, n)
+	query := f.fs.
+		Collection(Root).
+		Where("Parent", "==", section).
+		Where("Deleted", "==", nil)
+	query, keep, err := f.applyView(ctx, query, view)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve threads: %w", err)
+	}
+	// Sub-sections are children of a section too, but they aren't threads.
+	posts, cursor, err := f.paginateView(ctx, query, cursor, n, func(p *Post) bool { return !p.isSection() && keep(p) })
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to retrieve threads: %w", err)
 	}
@@ -79,7 +142,45 @@ func (f Forum) GetThreads(ctx Context, section PostID, cursor Cursor, n int) ([]
 }
 
 func (f Forum) CreateReply(ctx Context, parent []PostID, subject string, body string, author User) ([]PostID, error) {
-	path := append(parent, uniq.Uniq())
+	if len(parent) == 0 {
+		return nil, fmt.Errorf("failed to create reply: %w: empty parent path", ErrInvalid)
+	}
+	// Only the parent itself is taken from the caller; the rest of its path is read
+	// from the parent, so a wrong path can't place the reply somewhere else.
+	parentPost, err := f.getPost(ctx, parent[len(parent)-1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reply: %w", err)
+	}
+	if parentPost.isSection() {
+		return nil, fmt.Errorf("failed to create reply: %w: %s is a section", ErrInvalid, parentPost.ID())
+	}
+	depth := parentPost.sectionDepth()
+	thread := parentPost
+	if len(parentPost.Path) > depth+1 {
+		thread, err = f.getPost(ctx, parentPost.threadID())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create reply: %w", err)
+		}
+	}
+	sectionPath := parentPost.sectionPath()
+	section, err := f.getPost(ctx, sectionPath[len(sectionPath)-1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reply: %w", err)
+	}
+	if err := f.requireAccess(ctx, author, section); err != nil {
+		return nil, fmt.Errorf("failed to create reply: %w", err)
+	}
+	if parentPost.Deleted != nil || thread.Deleted != nil {
+		return nil, fmt.Errorf("failed to create reply: %w", ErrDeleted)
+	}
+	if thread.Locked {
+		return nil, fmt.Errorf("failed to create reply: %w", ErrLocked)
+	}
+	shadow, err := f.checkSanctions(ctx, author, sectionPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reply: %w", err)
+	}
+	path := append(append([]PostID{}, parentPost.Path...), uniq.Uniq())
 	post := &Post{
 		Path:            path,
 		Head:            "Re: " + subject,
@@ -90,48 +191,327 @@ func (f Forum) CreateReply(ctx Context, parent []PostID, subject string, body st
 		DescendentCount: 0,
 		ViewCount:       0,
 		Deleted:         nil,
+		QA:              parentPost.QA,
+		Shadow:          shadow,
+		Sections:        depth,
 		CreateTime:      time.Time{},
 		EditTime:        time.Time{},
 	}
-	path, err := f.addPost(ctx, post)
+	if err := f.screenNew(ctx, post); err != nil {
+		return nil, fmt.Errorf("failed to create reply: %w", err)
+	}
+	if err := f.holdForApproval(ctx, section, post); err != nil {
+		return nil, fmt.Errorf("failed to create reply: %w", err)
+	}
+	if err := f.checkRate(ctx, author, false); err != nil {
+		return nil, fmt.Errorf("failed to create reply: %w", err)
+	}
+	path, err = f.addPost(ctx, post)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create reply: %w", err)
 	}
+	// The post is stored, so failing to count it only lets the author post a little more.
+	_ = f.chargeRate(ctx, post, false)
 	return path, nil
 }
 
-func (f Forum) GetReplies(ctx Context, thread PostID, cursor Cursor, n int) ([]*Post, error) {
+// GetReplies retrieves a thread and its replies, oldest first. With a ScoreDesc cursor
+// it retrieves the answers to a Q&A thread instead, accepted answer first.
+func (f Forum) GetReplies(ctx Context, thread PostID, cursor Cursor, n int, view View) ([]*Post, Cursor, error) {
 	if cursor == nil {
 		cursor = &CreateTimeAsc{}
 	}
-	posts, cursor, err := f.getTree(ctx, thread, cursor, n)
+	var posts []*Post
+	var err error
+	if byScore, ok := cursor.(*ScoreDesc); ok {
+		posts, cursor, err = f.getAnswers(ctx, thread, byScore, n, view)
+	} else {
+		query := f.fs.
+			Collection(Root).
+			Where("Path", "array-contains", thread).
+			Where("Deleted", "==", nil)
+		var keep func(*Post) bool
+		query, keep, err = f.applyView(ctx, query, view)
+		if err == nil {
+			posts, cursor, err = f.paginateView(ctx, query, cursor, n, keep)
+		}
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get replies: %w", err)
+		return nil, nil, fmt.Errorf("failed to get replies: %w", err)
 	}
-	return posts, nil
+	return posts, cursor, nil
+}
+
+// GetPostsByAuthor retrieves the threads and replies a user has written, newest first.
+func (f Forum) GetPostsByAuthor(ctx Context, userID string, cursor Cursor, n int, view View) ([]*Post, Cursor, error) {
+	if cursor == nil {
+		cursor = &CreateTimeDesc{}
+	}
+	query := f.fs.
+		Collection(Root).
+		Where("Author.ID", "==", userID).
+		Where("Deleted", "==", nil)
+	query, keep, err := f.applyView(ctx, query, view)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get posts by %s: %w", userID, err)
+	}
+	posts, cursor, err := f.paginateView(ctx, query, cursor, n, func(p *Post) bool { return !p.isSection() && keep(p) })
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get posts by %s: %w", userID, err)
+	}
+	return posts, cursor, nil
 }
 
 func (f Forum) DeleteSection(ctx context.Context, sectionID string, user User, reason string) error {
 	return f.deletePost(ctx, sectionID, user, reason)
 }
 
-func (f Forum) UpdateThread(ctx context.Context, threadID string, subject string, body string) error {
+// UpdateThread replaces the subject and body of a thread. If tags is not nil, it also
+// replaces the thread's tags. Only the author or a moderator may edit a thread; edits by
+// anyone but the author are recorded in the audit log.
+func (f Forum) UpdateThread(ctx context.Context, threadID string, subject string, body string, editor User, tags []string) error {
+	thread, err := f.getPost(ctx, threadID)
+	if err != nil {
+		return fmt.Errorf("failed to update thread: %w", err)
+	}
+	if thread.Author.ID != editor.ID {
+		if err := f.requireModerator(ctx, editor); err != nil {
+			return fmt.Errorf("failed to update thread: %w", err)
+		}
+	}
+	if tags != nil {
+		tags, err = NormalizeTags(tags)
+		if err != nil {
+			return fmt.Errorf("failed to update thread: %w", err)
+		}
+		sections := thread.sectionPath()
+		section, err := f.getPost(ctx, sections[len(sections)-1])
+		if err != nil {
+			return fmt.Errorf("failed to update thread: %w", err)
+		}
+		if err := f.checkTags(ctx, section, tags); err != nil {
+			return fmt.Errorf("failed to update thread: %w", err)
+		}
+	}
+	edited := *thread
+	edited.Head = subject
+	edited.Body = body
+	verdict, reason, err := f.screen(ctx, &edited)
+	if err != nil {
+		return fmt.Errorf("failed to update thread: %w", err)
+	}
+	if verdict == Reject {
+		return fmt.Errorf("failed to update thread: %w: %s", ErrRejected, reason)
+	}
+	hooks, err := f.getWebhooks(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update thread: %w", err)
+	}
 	path := f.fs.Collection(Root).Doc(threadID)
-	_, err := path.Update(ctx, []firestore.Update{
-		{Path: "Header", Value: subject},
-		{Path: "Body", Value: body},
-		{Path: "EditTime", Value: firestore.ServerTimestamp},
+	var after Post
+	err = f.fs.RunTransaction(ctx, func(ctx Context, tx *firestore.Transaction) error {
+		before, err := txPost(tx, path)
+		if err != nil {
+			return err
+		}
+		after = *before
+		after.Head = subject
+		after.Body = body
+		updates := []firestore.Update{
+			{Path: "Head", Value: subject},
+			{Path: "Body", Value: body},
+			{Path: "EditTime", Value: firestore.ServerTimestamp},
+		}
+		if tags != nil {
+			for tag, delta := range tagDeltas(before.Tags, tags) {
+				err := tx.Set(f.fs.Collection(Tags).Doc(tag), tagCount(tag, delta), firestore.MergeAll)
+				if err != nil {
+					return err
+				}
+			}
+			after.Tags = tags
+			updates = append(updates, firestore.Update{Path: "Tags", Value: tags})
+		}
+		if verdict == Hold && before.Pending == nil {
+			after.Pending = &PendingInfo{
+				Reason:   reason,
+				Edit:     true,
+				Approved: &Revision{Head: before.Head, Body: before.Body, Tags: before.Tags, EditTime: before.EditTime},
+				Time:     time.Now(),
+			}
+			updates = append(updates, firestore.Update{Path: "Pending", Value: after.Pending})
+		}
+		if before.Author.ID != editor.ID {
+			if err := f.txAudit(tx, newAuditEntry(editor, AuditEdit, before, &after, "")); err != nil {
+				return err
+			}
+		}
+		if err := f.txQueueWebhooks(tx, hooks, PostEdited, &after); err != nil {
+			return err
+		}
+		return tx.Update(path, updates)
 	})
 	if err != nil {
 		return fmt.Errorf("failed to update thread: %w", err)
 	}
-	return err
+	f.indexPost(&after)
+	f.publish(ctx, PostEdited, &after)
+	return nil
 }
 
 func (f Forum) DeleteThread(ctx context.Context, threadID string, user User, reason string) error {
 	return f.deletePost(ctx, threadID, user, reason)
 }
 
+// ErrNotFound is returned for posts that don't exist or that the viewer may not see.
+var ErrNotFound = errors.New("not found")
+
+// ErrInvalid is wrapped by errors for requests that can't succeed as made, such as a
+// malformed tag or a poll with no question.
+var ErrInvalid = errors.New("invalid argument")
+
+// ErrDeleted is returned for replies to a deleted post, or to anything in a deleted
+// thread.
+var ErrDeleted = errors.New("post is deleted")
+
+// GetPost returns a single post as view's viewer would see it. Deleted posts are
+// returned with their Deleted field set, so that callers can show a placeholder.
+func (f Forum) GetPost(ctx Context, postID PostID, view View) (*Post, error) {
+	post, err := f.getPost(ctx, postID)
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("failed to get post %s: %w", postID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get post %s: %w", postID, err)
+	}
+	r, err := f.newReader(ctx, view)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get post %s: %w", postID, err)
+	}
+	if !r.canSee(post) {
+		return nil, fmt.Errorf("failed to get post %s: %w", postID, ErrNotFound)
+	}
+	return post, nil
+}
+
+// DeletePost marks a thread or reply deleted. Only its author or a moderator may delete
+// a post, and only a moderator may delete a section.
+func (f Forum) DeletePost(ctx Context, postID PostID, user User, reason string) error {
+	post, err := f.getPost(ctx, postID)
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("failed to delete post %s: %w", postID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete post %s: %w", postID, err)
+	}
+	if post.isSection() || post.Author.ID != user.ID {
+		if err := f.requireModerator(ctx, user); err != nil {
+			return fmt.Errorf("failed to delete post %s: %w", postID, err)
+		}
+	}
+	return f.deletePost(ctx, postID, user, reason)
+}
+
+// MaxMoveSize is the largest thread, counting all replies, that MoveThread can move.
+// The move and its audit entry are written in a single batch, which Firestore limits to
+// 500 writes.
+const MaxMoveSize = 490
+
+// MoveThread moves a thread and all its replies to another section. Only moderators may
+// move threads.
+func (f Forum) MoveThread(ctx Context, threadID PostID, sectionID PostID, moderator User, reason string) error {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return fmt.Errorf("failed to move thread: %w", err)
+	}
+	thread, err := f.getPost(ctx, threadID)
+	if err != nil {
+		return fmt.Errorf("failed to move thread: %w", err)
+	}
+	if thread.threadID() != threadID {
+		return fmt.Errorf("failed to move thread: %w: %s is not a thread", ErrInvalid, threadID)
+	}
+	from := thread.sectionPath()
+	if from[len(from)-1] == sectionID {
+		return nil
+	}
+	section, err := f.getSection(ctx, sectionID)
+	if err != nil {
+		return fmt.Errorf("failed to move thread: %w", err)
+	}
+	docs, err := f.fs.Collection(Root).Where("Path", "array-contains", threadID).Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to move thread: %w", err)
+	}
+	if len(docs) > MaxMoveSize {
+		return fmt.Errorf("failed to move thread: more than %d posts", MaxMoveSize)
+	}
+	depth := len(from)
+	moved := make([]*Post, 0, len(docs))
+	wb := f.fs.Batch()
+	for _, doc := range docs {
+		post := &Post{}
+		if err := doc.DataTo(post); err != nil {
+			return fmt.Errorf("failed to decode post %s: %w", doc.Ref.ID, err)
+		}
+		post.Path = append(append([]PostID{}, section.Path...), post.Path[depth:]...)
+		post.Sections = len(section.Path)
+		updates := []firestore.Update{
+			{Path: "Path", Value: post.Path},
+			{Path: "Sections", Value: post.Sections},
+		}
+		if post.ID() == threadID {
+			post.Parent = sectionID
+			updates = append(updates, firestore.Update{Path: "Parent", Value: sectionID})
+		}
+		wb.Update(doc.Ref, updates)
+		moved = append(moved, post)
+	}
+	// Sections the thread stays within, such as a shared parent, may appear on both
+	// sides, so net out the changes before writing them.
+	count := 1 + thread.DescendentCount
+	descendents := make(map[PostID]int)
+	for _, id := range from {
+		descendents[id] -= count
+	}
+	for _, id := range section.Path {
+		descendents[id] += count
+	}
+	for id, delta := range descendents {
+		updates := []firestore.Update{{Path: "DescendentCount", Value: firestore.Increment(delta)}}
+		switch id {
+		case from[depth-1]:
+			updates = append(updates, firestore.Update{Path: "ChildCount", Value: firestore.Increment(-1)})
+		case sectionID:
+			updates = append(updates, firestore.Update{Path: "ChildCount", Value: firestore.Increment(1)})
+		}
+		wb.Update(f.fs.Collection(Root).Doc(id), updates)
+	}
+	after := *thread
+	after.Path = append(append([]PostID{}, section.Path...), threadID)
+	after.Parent = sectionID
+	after.Sections = len(section.Path)
+	entry := newAuditEntry(moderator, AuditMove, thread, &after, reason)
+	entry.Destination = after.Path
+	f.audit(wb, entry)
+	if _, err := wb.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to move thread: %w", err)
+	}
+	for _, post := range moved {
+		if post.Deleted == nil {
+			f.indexPost(post)
+		}
+	}
+	return nil
+}
+
+// ExpungePost permanently deletes a post. Only moderators may expunge posts.
+func (f Forum) ExpungePost(ctx Context, postID PostID, moderator User, reason string) error {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return fmt.Errorf("failed to expunge post: %w", err)
+	}
+	return f.expungePost(ctx, postID, moderator, reason)
+}
+
 func (f Forum) ListThreads(ctx context.Context, sectionID string) ([]*Post, error) {
 	posts, _, err := f.getChildren(ctx, sectionID, &BumpTimeDesc{}, 1000)
 	if err != nil {
@@ -144,10 +524,6 @@ func (f Forum) ListReplies(ctx context.Context, threadID string) ([]*Post, error
 	panic("not implemented")
 }
 
-func (f Forum) CreateDraftReply(ctx context.Context, s []string, body string, author string) (string, error) {
-	panic("not implemented")
-}
-
 func (f Forum) DeleteReply(ctx context.Context, path []string, s string) error {
 	panic("not implemented")
 }
@@ -155,7 +531,3 @@ func (f Forum) DeleteReply(ctx context.Context, path []string, s string) error {
 func (f Forum) UpdateReply(ctx context.Context, replyID string, body string) error {
 	panic("not implemented")
 }
-
-func (f Forum) InstallReply(ctx context.Context, userID string, docID string) error {
-	panic("not implemented")
-}
@@ -1,6 +1,7 @@
 package forum
 
 import (
+	"errors"
 	"github.com/mhcoffin/forum-tools/pkg/uniq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -27,9 +28,9 @@ func TestClient_CreateSection(t *testing.T) {
 	require.Nil(t, err)
 	defer f.expunge(ctx)
 
-	path, err := f.CreateSection(ctx, "Announcements", "Important stuff", 0, mhc)
+	path, err := f.CreateSection(ctx, "Announcements", "Important stuff", 0, mhc, SectionOptions{})
 	require.Nil(t, err)
-	s, err := f.GetSections(ctx)
+	s, err := f.GetSections(ctx, View{})
 	require.Nil(t, err)
 	assert.Len(t, s, 1)
 	assert.Equal(t, path[0], s[0].ID())
@@ -39,11 +40,11 @@ func TestForum_GetSections(t *testing.T) {
 	f, err := NewClient(ctx, "fugalist")
 	require.Nil(t, err)
 	defer f.expunge(ctx)
-	_, err = f.CreateSection(ctx, "Announcements", "Important stuff", 100, mhc)
+	_, err = f.CreateSection(ctx, "Announcements", "Important stuff", 100, mhc, SectionOptions{})
 	require.Nil(t, err)
-	_, err = f.CreateSection(ctx, "Discussion", "Random stuff", 200, mhc)
+	_, err = f.CreateSection(ctx, "Discussion", "Random stuff", 200, mhc, SectionOptions{})
 	require.Nil(t, err)
-	s, err := f.GetSections(ctx)
+	s, err := f.GetSections(ctx, View{})
 	require.Nil(t, err)
 	require.Len(t, s, 2)
 	assert.Equal(t, "Announcements", s[0].Head)
@@ -54,9 +55,9 @@ func TestForum_CreateThread(t *testing.T) {
 	f, err := NewClient(ctx, "fugalist")
 	require.Nil(t, err)
 	defer f.expunge(ctx)
-	ann, err := f.CreateSection(ctx, "Announcements", "Important stuff", 100, mhc)
+	ann, err := f.CreateSection(ctx, "Announcements", "Important stuff", 100, mhc, SectionOptions{})
 	require.Nil(t, err)
-	_, err = f.CreateThread(ctx, "Hi", "First Post!", mhc, ann[0])
+	_, err = f.CreateThread(ctx, "Hi", "First Post!", mhc, ann[0], ThreadOptions{})
 	assert.Nil(t, err)
 }
 
@@ -64,13 +65,13 @@ func TestForum_CreateThreads(t *testing.T) {
 	f, err := NewClient(ctx, "fugalist")
 	require.Nil(t, err)
 	defer f.expunge(ctx)
-	ann, err := f.CreateSection(ctx, "Announcements", "Important stuff", 100, mhc)
+	ann, err := f.CreateSection(ctx, "Announcements", "Important stuff", 100, mhc, SectionOptions{})
 	require.Nil(t, err)
 	require.Len(t, ann, 1)
 	for k := 0; k < 100; k++ {
 		createRandomThread(t, ctx, f, ann[0])
 	}
-	threads, cursor, err := f.GetThreads(ctx, ann[0], &CreateTimeAsc{}, 120)
+	threads, cursor, err := f.GetThreads(ctx, ann[0], &CreateTimeAsc{}, 120, View{})
 	require.Nil(t, err)
 	assert.Nil(t, cursor)
 	assert.Len(t, threads, 100)
@@ -80,15 +81,40 @@ func TestForum_CreateReply(t *testing.T) {
 	f, err := NewClient(ctx, "fugalist")
 	require.Nil(t, err)
 	defer f.expunge(ctx)
-	ann, err := f.CreateSection(ctx, "Announcements", "Important stuff", 100, mhc)
+	ann, err := f.CreateSection(ctx, "Announcements", "Important stuff", 100, mhc, SectionOptions{})
 	require.Nil(t, err)
-	hello, err := f.CreateThread(ctx, "Hello", "First post", mhc, ann[0])
+	hello, err := f.CreateThread(ctx, "Hello", "First post", mhc, ann[0], ThreadOptions{})
 	require.Nil(t, err)
 	_, err = f.CreateReply(ctx, hello, "reply body", "bob", ella)
 	assert.Nil(t, err)
 }
 
+func TestForum_CreateReplyPath(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	ann, err := f.CreateSection(ctx, "Announcements", "Important stuff", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	other, err := f.CreateSection(ctx, "Other", "Other stuff", 200, mhc, SectionOptions{})
+	require.Nil(t, err)
+	hello, err := f.CreateThread(ctx, "Hello", "First post", mhc, ann[0], ThreadOptions{})
+	require.Nil(t, err)
+	reply, err := f.CreateReply(ctx, hello, "Hello", "Hi", ella)
+	require.Nil(t, err)
+
+	// Only the last ID of the parent path counts.
+	nested, err := f.CreateReply(ctx, []PostID{reply[2]}, "Hello", "Hi again", mhc)
+	require.Nil(t, err)
+	assert.Equal(t, reply, nested[:3])
+	forged, err := f.CreateReply(ctx, []PostID{other[0], "nonsense", hello[1]}, "Hello", "Elsewhere?", mhc)
+	require.Nil(t, err)
+	assert.Equal(t, hello, forged[:2])
+
+	_, err = f.CreateReply(ctx, ann, "Hello", "A thread in disguise", mhc)
+	assert.True(t, errors.Is(err, ErrInvalid))
+}
+
 func createRandomThread(t *testing.T, ctx Context, forum *Forum, section PostID) {
-	_, err := forum.CreateThread(ctx, uniq.Uniq(), uniq.Uniq(), ella, section)
+	_, err := forum.CreateThread(ctx, uniq.Uniq(), uniq.Uniq(), ella, section, ThreadOptions{})
 	require.Nil(t, err)
 }
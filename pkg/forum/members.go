@@ -0,0 +1,246 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/uniq"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+const Members = "Members"
+
+// Visibility says who may read a section.
+type Visibility string
+
+const (
+	Public      Visibility = ""        // Anyone
+	MembersOnly Visibility = "members" // Members. Anyone may join.
+	InviteOnly  Visibility = "invite"  // Members. Only moderators may add members.
+)
+
+// Member records that a user belongs to a restricted section.
+type Member struct {
+	Section PostID
+	User    User
+	Time    time.Time `firestore:",serverTimestamp"`
+}
+
+// access records which restricted sections a reader may see.
+type access struct {
+	all        bool // Moderators see everything
+	restricted map[PostID]Visibility
+	member     map[PostID]bool
+}
+
+func (f Forum) getAccess(ctx Context, viewer *User) (*access, error) {
+	a := &access{restricted: make(map[PostID]Visibility), member: make(map[PostID]bool)}
+	if viewer != nil {
+		var err error
+		a.all, err = f.IsModerator(ctx, *viewer)
+		if err != nil {
+			return nil, err
+		}
+		if a.all {
+			return a, nil
+		}
+	}
+	docs, err := f.fs.
+		Collection(Root).
+		Where("Visibility", "in", []Visibility{MembersOnly, InviteOnly}).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read restricted sections: %w", err)
+	}
+	for _, doc := range docs {
+		section := &Post{}
+		if err := doc.DataTo(section); err != nil {
+			return nil, fmt.Errorf("failed to decode section: %w", err)
+		}
+		a.restricted[section.ID()] = section.Visibility
+	}
+	if viewer == nil || len(a.restricted) == 0 {
+		return a, nil
+	}
+	docs, err = f.fs.Collection(Members).Where("User.ID", "==", viewer.ID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read memberships: %w", err)
+	}
+	for _, doc := range docs {
+		m := &Member{}
+		if err := doc.DataTo(m); err != nil {
+			return nil, fmt.Errorf("failed to decode membership: %w", err)
+		}
+		a.member[m.Section] = true
+	}
+	return a, nil
+}
+
+// canRead reports whether the reader may see a post, which they may unless it is in a
+// restricted section they are not a member of.
+func (a *access) canRead(p *Post) bool {
+	if a.all {
+		return true
+	}
+	for _, id := range p.sectionPath() {
+		if a.restricted[id] != Public && !a.member[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// canList reports whether a section appears in the reader's section list. Members-only
+// sections are listed so that people can find and join them; invite-only ones are not.
+func (a *access) canList(section *Post) bool {
+	if a.all {
+		return true
+	}
+	for _, id := range section.sectionPath() {
+		if a.restricted[id] == InviteOnly && !a.member[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// requireAccess returns ErrNotPermitted unless user may read section.
+func (f Forum) requireAccess(ctx Context, user User, section *Post) error {
+	a, err := f.getAccess(ctx, &user)
+	if err != nil {
+		return err
+	}
+	if !a.canRead(section) {
+		return ErrNotPermitted
+	}
+	return nil
+}
+
+// SetVisibility changes who may read a section. Only moderators may change it.
+func (f Forum) SetVisibility(ctx Context, sectionID PostID, visibility Visibility, moderator User) error {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return fmt.Errorf("failed to set visibility: %w", err)
+	}
+	section, err := f.getSection(ctx, sectionID)
+	if err != nil {
+		return fmt.Errorf("failed to set visibility: %w", err)
+	}
+	after := *section
+	after.Visibility = visibility
+	wb := f.fs.Batch()
+	wb.Update(f.fs.Collection(Root).Doc(sectionID), []firestore.Update{
+		{Path: "Visibility", Value: visibility},
+	})
+	f.audit(wb, newAuditEntry(moderator, AuditVisibility, section, &after, string(visibility)))
+	if _, err := wb.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to set visibility of %s: %w", sectionID, err)
+	}
+	return nil
+}
+
+// AddMember adds a user to a restricted section. Only moderators may add members.
+func (f Forum) AddMember(ctx Context, sectionID PostID, user User, moderator User) error {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return fmt.Errorf("failed to add member: %w", err)
+	}
+	section, err := f.getSection(ctx, sectionID)
+	if err != nil {
+		return fmt.Errorf("failed to add member: %w", err)
+	}
+	wb := f.fs.Batch()
+	wb.Set(f.fs.Collection(Members).Doc(pairID(sectionID, user.ID)), &Member{Section: sectionID, User: user})
+	f.audit(wb, newMemberEntry(moderator, AuditAddMember, section, user))
+	if _, err := wb.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to add member: %w", err)
+	}
+	return nil
+}
+
+// RemoveMember removes a user from a restricted section. Only moderators may remove
+// members.
+func (f Forum) RemoveMember(ctx Context, sectionID PostID, userID string, moderator User) error {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+	section, err := f.getSection(ctx, sectionID)
+	if err != nil {
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+	wb := f.fs.Batch()
+	wb.Delete(f.fs.Collection(Members).Doc(pairID(sectionID, userID)))
+	f.audit(wb, newMemberEntry(moderator, AuditRemoveMember, section, User{ID: userID}))
+	if _, err := wb.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+	return nil
+}
+
+// newMemberEntry records a moderator adding a user to a section or removing them.
+func newMemberEntry(actor User, action AuditAction, section *Post, user User) *AuditEntry {
+	return &AuditEntry{ID: uniq.Uniq(), Actor: actor, Action: action, Target: section.Path, Subject: user}
+}
+
+// JoinSection makes a user a member of a members-only section.
+func (f Forum) JoinSection(ctx Context, sectionID PostID, user User) error {
+	section, err := f.getSection(ctx, sectionID)
+	if err != nil {
+		return fmt.Errorf("failed to join section: %w", err)
+	}
+	if section.Visibility == InviteOnly {
+		return fmt.Errorf("failed to join section: %w", ErrNotPermitted)
+	}
+	if err := f.addMember(ctx, sectionID, user); err != nil {
+		return fmt.Errorf("failed to join section: %w", err)
+	}
+	return nil
+}
+
+// LeaveSection ends a user's membership of a section.
+func (f Forum) LeaveSection(ctx Context, sectionID PostID, user User) error {
+	if _, err := f.fs.Collection(Members).Doc(pairID(sectionID, user.ID)).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to leave section: %w", err)
+	}
+	return nil
+}
+
+func (f Forum) addMember(ctx Context, sectionID PostID, user User) error {
+	if _, err := f.getSection(ctx, sectionID); err != nil {
+		return err
+	}
+	_, err := f.fs.Collection(Members).Doc(pairID(sectionID, user.ID)).Set(ctx, &Member{Section: sectionID, User: user})
+	return err
+}
+
+// IsMember reports whether a user is a member of a section.
+func (f Forum) IsMember(ctx Context, sectionID PostID, userID string) (bool, error) {
+	_, err := f.fs.Collection(Members).Doc(pairID(sectionID, userID)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read membership: %w", err)
+	}
+	return true, nil
+}
+
+// GetMembers returns the members of a section. Only moderators may list members.
+func (f Forum) GetMembers(ctx Context, sectionID PostID, moderator User) ([]*Member, error) {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return nil, fmt.Errorf("failed to get members: %w", err)
+	}
+	docs, err := f.fs.Collection(Members).Where("Section", "==", sectionID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get members: %w", err)
+	}
+	result := make([]*Member, len(docs))
+	for k, doc := range docs {
+		m := &Member{}
+		if err := doc.DataTo(m); err != nil {
+			return nil, fmt.Errorf("failed to decode member: %w", err)
+		}
+		result[k] = m
+	}
+	return result, nil
+}
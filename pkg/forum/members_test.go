@@ -0,0 +1,78 @@
+package forum
+
+import (
+	"errors"
+	"github.com/mhcoffin/forum-tools/pkg/search"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestForum_PrivateSections(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	f.SetSearchIndex(search.NewIndex())
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	club, err := f.CreateSection(ctx, "Club", "Members only", 200, mhc, SectionOptions{Visibility: MembersOnly})
+	require.Nil(t, err)
+	staff, err := f.CreateSection(ctx, "Staff", "Staff only", 300, mhc, SectionOptions{Visibility: InviteOnly})
+	require.Nil(t, err)
+	_, err = f.CreateThread(ctx, "Public", "Hello strings", mhc, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+	secret, err := f.CreateThread(ctx, "Secret", "Private strings", moderator, staff[0], ThreadOptions{})
+	require.Nil(t, err)
+
+	_, err = f.CreateThread(ctx, "Hi", "Let me in", ella, club[0], ThreadOptions{})
+	assert.True(t, errors.Is(err, ErrNotPermitted))
+	require.Nil(t, f.JoinSection(ctx, club[0], ella))
+	_, err = f.CreateThread(ctx, "Hi", "I'm in", ella, club[0], ThreadOptions{})
+	require.Nil(t, err)
+
+	err = f.JoinSection(ctx, staff[0], ella)
+	assert.True(t, errors.Is(err, ErrNotPermitted))
+	sections, err := f.GetSections(ctx, View{Viewer: &ella})
+	require.Nil(t, err)
+	assert.Len(t, sections, 2)
+	threads, _, err := f.GetThreads(ctx, staff[0], nil, 10, View{Viewer: &ella})
+	require.Nil(t, err)
+	assert.Empty(t, threads)
+	replies, _, err := f.GetReplies(ctx, secret[1], nil, 10, View{Viewer: &ella})
+	require.Nil(t, err)
+	assert.Empty(t, replies)
+	found, _, err := f.Search(ctx, search.Query{Text: "strings"}, nil, 10, View{Viewer: &ella})
+	require.Nil(t, err)
+	assert.Len(t, found, 1)
+
+	require.Nil(t, f.AddMember(ctx, staff[0], ella, moderator))
+	sections, err = f.GetSections(ctx, View{Viewer: &ella})
+	require.Nil(t, err)
+	assert.Len(t, sections, 3)
+	threads, _, err = f.GetThreads(ctx, staff[0], nil, 10, View{Viewer: &ella})
+	require.Nil(t, err)
+	assert.Len(t, threads, 1)
+	found, _, err = f.Search(ctx, search.Query{Text: "strings"}, nil, 10, View{Viewer: &ella})
+	require.Nil(t, err)
+	assert.Len(t, found, 2)
+
+	threads, _, err = f.GetThreads(ctx, club[0], nil, 10, View{})
+	require.Nil(t, err)
+	assert.Empty(t, threads)
+	members, err := f.GetMembers(ctx, club[0], moderator)
+	require.Nil(t, err)
+	assert.Len(t, members, 1)
+	require.Nil(t, f.RemoveMember(ctx, staff[0], ella.ID, moderator))
+	threads, _, err = f.GetThreads(ctx, staff[0], nil, 10, View{Viewer: &ella})
+	require.Nil(t, err)
+	assert.Empty(t, threads)
+
+	entries, err := f.GetAuditLog(ctx, AuditQuery{Subject: ella.ID}, moderator, 10)
+	require.Nil(t, err)
+	var actions []AuditAction
+	for _, e := range entries {
+		assert.Equal(t, staff[0], e.Target[0])
+		actions = append(actions, e.Action)
+	}
+	assert.ElementsMatch(t, []AuditAction{AuditAddMember, AuditRemoveMember}, actions)
+}
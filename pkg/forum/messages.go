@@ -0,0 +1,317 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"errors"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/search"
+	"github.com/mhcoffin/forum-tools/pkg/uniq"
+	"strings"
+	"time"
+)
+
+const (
+	Conversations = "Conversations"
+	Messages      = "Messages"
+)
+
+var ErrNotParticipant = errors.New("not a participant in the conversation")
+
+// Conversation is a private exchange of messages between two or more users. It is kept
+// apart from the public posts.
+type Conversation struct {
+	ID           string
+	Subject      string
+	Participants []User                    // Everyone who has taken part, including those who left
+	Members      []string                  // IDs of participants who haven't left
+	States       map[string]*Participation // Keyed by user ID
+	Bump         *Bump                     // Most recent message
+	CreateTime   time.Time                 `firestore:",serverTimestamp"`
+}
+
+// Participation is one participant's view of a conversation.
+type Participation struct {
+	LastRead time.Time
+	Muted    bool // Don't notify about new messages
+	Left     bool
+}
+
+// Unread reports whether the conversation has a message userID hasn't read.
+func (c *Conversation) Unread(userID string) bool {
+	state, ok := c.States[userID]
+	if !ok || c.Bump == nil || c.Bump.Author.ID == userID {
+		return false
+	}
+	return c.Bump.Time.After(state.LastRead)
+}
+
+// Message is one message in a conversation.
+type Message struct {
+	ID           string
+	Conversation string
+	Author       User
+	Body         string    // HTML, as for posts
+	CreateTime   time.Time `firestore:",serverTimestamp"`
+}
+
+// snippet returns the start of an HTML body as plain text.
+func snippet(body string) string {
+	const max = 100
+	text := strings.Join(strings.Fields(search.StripHTML(body)), " ")
+	if r := []rune(text); len(r) > max {
+		return string(r[:max]) + "…"
+	}
+	return text
+}
+
+// StartConversation starts a conversation between author and the users in to. It fails
+// with ErrBlocked if any of them has blocked author.
+func (f Forum) StartConversation(ctx Context, subject string, body string, author User, to []User) (string, error) {
+	participants := []User{author}
+	seen := map[string]bool{author.ID: true}
+	for _, u := range to {
+		if seen[u.ID] {
+			continue
+		}
+		seen[u.ID] = true
+		blocked, err := f.isBlocked(ctx, u.ID, author.ID)
+		if err != nil {
+			return "", fmt.Errorf("failed to start conversation: %w", err)
+		}
+		if blocked {
+			return "", fmt.Errorf("failed to start conversation with %s: %w", u.ID, ErrBlocked)
+		}
+		participants = append(participants, u)
+	}
+	if len(participants) < 2 {
+		return "", fmt.Errorf("failed to start conversation: %w: no one to talk to", ErrInvalid)
+	}
+	conv := &Conversation{
+		ID:           uniq.Uniq(),
+		Subject:      subject,
+		Participants: participants,
+		States:       make(map[string]*Participation),
+	}
+	for _, u := range participants {
+		conv.Members = append(conv.Members, u.ID)
+		conv.States[u.ID] = &Participation{}
+	}
+	msg := &Message{ID: uniq.Uniq(), Conversation: conv.ID, Author: author, Body: body}
+	conv.Bump = &Bump{ID: msg.ID, Head: snippet(body), Author: author}
+	conv.States[author.ID].LastRead = time.Now()
+	wb := f.fs.Batch()
+	wb.Create(f.fs.Collection(Conversations).Doc(conv.ID), conv)
+	wb.Create(f.fs.Collection(Messages).Doc(msg.ID), msg)
+	for _, u := range participants[1:] {
+		f.notify(wb, newMessageNotification(u.ID, conv, msg))
+	}
+	if _, err := wb.Commit(ctx); err != nil {
+		return "", fmt.Errorf("failed to start conversation: %w", err)
+	}
+	return conv.ID, nil
+}
+
+func newMessageNotification(userID string, conv *Conversation, msg *Message) *Notification {
+	return &Notification{
+		ID:           uniq.Uniq(),
+		User:         userID,
+		Kind:         NotifyMessage,
+		Conversation: conv.ID,
+		Head:         conv.Subject,
+		Message:      snippet(msg.Body),
+	}
+}
+
+func txConversation(tx *firestore.Transaction, doc *firestore.DocumentRef) (*Conversation, error) {
+	snap, err := tx.Get(doc)
+	if err != nil {
+		return nil, err
+	}
+	conv := &Conversation{}
+	if err := snap.DataTo(conv); err != nil {
+		return nil, fmt.Errorf("failed to decode conversation: %w", err)
+	}
+	return conv, nil
+}
+
+func (c *Conversation) isMember(userID string) bool {
+	for _, id := range c.Members {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// SendMessage adds a message to a conversation, notifying the other members unless
+// they've muted it.
+func (f Forum) SendMessage(ctx Context, conversationID string, body string, author User) (string, error) {
+	doc := f.fs.Collection(Conversations).Doc(conversationID)
+	msg := &Message{ID: uniq.Uniq(), Conversation: conversationID, Author: author, Body: body}
+	err := f.fs.RunTransaction(ctx, func(ctx Context, tx *firestore.Transaction) error {
+		conv, err := txConversation(tx, doc)
+		if err != nil {
+			return err
+		}
+		if !conv.isMember(author.ID) {
+			return ErrNotParticipant
+		}
+		if err := tx.Create(f.fs.Collection(Messages).Doc(msg.ID), msg); err != nil {
+			return err
+		}
+		for _, id := range conv.Members {
+			if state := conv.States[id]; id == author.ID || state != nil && state.Muted {
+				continue
+			}
+			note := newMessageNotification(id, conv, msg)
+			if err := tx.Create(f.fs.Collection(Notifications).Doc(note.ID), note); err != nil {
+				return err
+			}
+		}
+		return tx.Update(doc, []firestore.Update{
+			{Path: "Bump.ID", Value: msg.ID},
+			{Path: "Bump.Head", Value: snippet(body)},
+			{Path: "Bump.Author", Value: author},
+			{Path: "Bump.Time", Value: firestore.ServerTimestamp},
+			{FieldPath: firestore.FieldPath{"States", author.ID, "LastRead"}, Value: firestore.ServerTimestamp},
+		})
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to send message: %w", err)
+	}
+	return msg.ID, nil
+}
+
+// GetConversations returns a user's conversations, most recently active first. It uses
+// a BumpTimeDesc cursor.
+func (f Forum) GetConversations(ctx Context, userID string, cursor Cursor, n int) ([]*Conversation, Cursor, error) {
+	if cursor == nil {
+		cursor = &BumpTimeDesc{}
+	}
+	docs, err := f.fs.
+		Collection(Conversations).
+		Where("Members", "array-contains", userID).
+		OrderBy(cursor.field(), cursor.direction()).
+		StartAfter(cursor.value()).
+		Limit(n).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get conversations: %w", err)
+	}
+	result := make([]*Conversation, len(docs))
+	for k, doc := range docs {
+		conv := &Conversation{}
+		if err := doc.DataTo(conv); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode conversation: %w", err)
+		}
+		result[k] = conv
+	}
+	if len(result) < n {
+		return result, nil, nil
+	}
+	return result, &BumpTimeDesc{tm: result[n-1].Bump.Time}, nil
+}
+
+// GetMessages returns the messages in a conversation, oldest first, using a
+// CreateTimeAsc cursor. Only members of the conversation may read it.
+func (f Forum) GetMessages(ctx Context, conversationID string, reader User, cursor Cursor, n int) ([]*Message, Cursor, error) {
+	if cursor == nil {
+		cursor = &CreateTimeAsc{}
+	}
+	conv, err := f.getConversation(ctx, conversationID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	if !conv.isMember(reader.ID) {
+		return nil, nil, fmt.Errorf("failed to get messages: %w", ErrNotParticipant)
+	}
+	docs, err := f.fs.
+		Collection(Messages).
+		Where("Conversation", "==", conversationID).
+		OrderBy(cursor.field(), cursor.direction()).
+		StartAfter(cursor.value()).
+		Limit(n).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	result := make([]*Message, len(docs))
+	for k, doc := range docs {
+		msg := &Message{}
+		if err := doc.DataTo(msg); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode message: %w", err)
+		}
+		result[k] = msg
+	}
+	if len(result) < n {
+		return result, nil, nil
+	}
+	return result, &CreateTimeAsc{tm: result[n-1].CreateTime}, nil
+}
+
+func (f Forum) getConversation(ctx Context, conversationID string) (*Conversation, error) {
+	snap, err := f.fs.Collection(Conversations).Doc(conversationID).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conv := &Conversation{}
+	if err := snap.DataTo(conv); err != nil {
+		return nil, fmt.Errorf("failed to decode conversation: %w", err)
+	}
+	return conv, nil
+}
+
+// MarkConversationRead records that a user has read everything in a conversation.
+func (f Forum) MarkConversationRead(ctx Context, conversationID string, user User) error {
+	err := f.updateParticipation(ctx, conversationID, user, firestore.Update{
+		FieldPath: firestore.FieldPath{"States", user.ID, "LastRead"},
+		Value:     firestore.ServerTimestamp,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark conversation read: %w", err)
+	}
+	return nil
+}
+
+// MuteConversation stops (or, with muted false, resumes) notifications to a user about
+// new messages in a conversation.
+func (f Forum) MuteConversation(ctx Context, conversationID string, user User, muted bool) error {
+	err := f.updateParticipation(ctx, conversationID, user, firestore.Update{
+		FieldPath: firestore.FieldPath{"States", user.ID, "Muted"},
+		Value:     muted,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mute conversation: %w", err)
+	}
+	return nil
+}
+
+// LeaveConversation removes a user from a conversation. They can no longer read or send
+// messages in it.
+func (f Forum) LeaveConversation(ctx Context, conversationID string, user User) error {
+	err := f.updateParticipation(ctx, conversationID, user,
+		firestore.Update{Path: "Members", Value: firestore.ArrayRemove(user.ID)},
+		firestore.Update{FieldPath: firestore.FieldPath{"States", user.ID, "Left"}, Value: true},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to leave conversation: %w", err)
+	}
+	return nil
+}
+
+// updateParticipation applies updates to a conversation if user is one of its members.
+func (f Forum) updateParticipation(ctx Context, conversationID string, user User, updates ...firestore.Update) error {
+	doc := f.fs.Collection(Conversations).Doc(conversationID)
+	return f.fs.RunTransaction(ctx, func(ctx Context, tx *firestore.Transaction) error {
+		conv, err := txConversation(tx, doc)
+		if err != nil {
+			return err
+		}
+		if !conv.isMember(user.ID) {
+			return ErrNotParticipant
+		}
+		return tx.Update(doc, updates)
+	})
+}
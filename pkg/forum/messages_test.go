@@ -0,0 +1,67 @@
+package forum
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestForum_Conversations(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	bob := User{ID: "bob", Name: "Bob"}
+
+	conv, err := f.StartConversation(ctx, "Lunch", "Lunch <b>tomorrow</b>?", mhc, []User{ella, bob})
+	require.Nil(t, err)
+	_, err = f.SendMessage(ctx, conv, "Sure", ella)
+	require.Nil(t, err)
+	_, err = f.SendMessage(ctx, conv, "Can't", bob)
+	require.Nil(t, err)
+
+	convs, _, err := f.GetConversations(ctx, mhc.ID, nil, 10)
+	require.Nil(t, err)
+	require.Len(t, convs, 1)
+	assert.True(t, convs[0].Unread(mhc.ID))
+	assert.Equal(t, "Can't", convs[0].Bump.Head)
+	require.Nil(t, f.MarkConversationRead(ctx, conv, mhc))
+	convs, _, err = f.GetConversations(ctx, mhc.ID, nil, 10)
+	require.Nil(t, err)
+	assert.False(t, convs[0].Unread(mhc.ID))
+
+	msgs, cursor, err := f.GetMessages(ctx, conv, ella, nil, 2)
+	require.Nil(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, "Lunch <b>tomorrow</b>?", msgs[0].Body)
+	msgs, cursor, err = f.GetMessages(ctx, conv, ella, cursor, 2)
+	require.Nil(t, err)
+	require.Len(t, msgs, 1)
+	assert.Nil(t, cursor)
+
+	require.Nil(t, f.MuteConversation(ctx, conv, ella, true))
+	require.Nil(t, f.LeaveConversation(ctx, conv, bob))
+	_, err = f.SendMessage(ctx, conv, "Wait", bob)
+	assert.True(t, errors.Is(err, ErrNotParticipant))
+	_, _, err = f.GetMessages(ctx, conv, bob, nil, 10)
+	assert.True(t, errors.Is(err, ErrNotParticipant))
+	_, err = f.SendMessage(ctx, conv, "Noon then", mhc)
+	require.Nil(t, err)
+	notes, err := f.GetNotifications(ctx, ella.ID, true, 10)
+	require.Nil(t, err)
+	assert.Len(t, notes, 1)
+}
+
+func TestForum_BlockedConversation(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	require.Nil(t, f.Block(ctx, ella, mhc))
+	_, err = f.StartConversation(ctx, "Hi", "Hello", mhc, []User{ella})
+	assert.True(t, errors.Is(err, ErrBlocked))
+	_, err = f.StartConversation(ctx, "Hi", "Hello", ella, []User{mhc})
+	require.Nil(t, err)
+	require.Nil(t, f.Unblock(ctx, ella, mhc.ID))
+	_, err = f.StartConversation(ctx, "Hi", "Hello", mhc, []User{ella})
+	require.Nil(t, err)
+}
@@ -0,0 +1,56 @@
+package forum
+
+import (
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const Moderators = "Moderators"
+
+// Moderator records that a user may moderate the forum.
+type Moderator struct {
+	User User
+}
+
+// IsModerator reports whether user may perform moderator actions.
+func (f Forum) IsModerator(ctx Context, user User) (bool, error) {
+	if user.ID == admin {
+		return true, nil
+	}
+	_, err := f.fs.Collection(Moderators).Doc(user.ID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read moderator %s: %w", user.ID, err)
+	}
+	return true, nil
+}
+
+// SetModerator grants or revokes moderator rights.
+func (f Forum) SetModerator(ctx Context, user User, moderator bool) error {
+	doc := f.fs.Collection(Moderators).Doc(user.ID)
+	var err error
+	if moderator {
+		_, err = doc.Set(ctx, &Moderator{User: user})
+	} else {
+		_, err = doc.Delete(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set moderator %s: %w", user.ID, err)
+	}
+	return nil
+}
+
+// requireModerator returns ErrNotPermitted unless user is a moderator.
+func (f Forum) requireModerator(ctx Context, user User) error {
+	moderator, err := f.IsModerator(ctx, user)
+	if err != nil {
+		return err
+	}
+	if !moderator {
+		return ErrNotPermitted
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/uniq"
+	"time"
+)
+
+const Notifications = "Notifications"
+
+// NotificationKind says what a notification is about.
+type NotificationKind string
+
+const (
+	NotifyApproved NotificationKind = "approved" // A post held for approval was approved
+	NotifyRejected NotificationKind = "rejected" // A post held for approval was rejected
+	NotifyMessage  NotificationKind = "message"  // A new private message
+)
+
+// Notification tells a user about something that happened to one of their posts, or
+// about a private message.
+type Notification struct {
+	ID           string
+	User         string // ID of the user notified
+	Kind         NotificationKind
+	Path         []PostID // Path of the post concerned
+	Conversation string   // ID of the conversation concerned
+	Head         string
+	Message      string
+	Read         bool
+	Time         time.Time `firestore:",serverTimestamp"`
+}
+
+func newNotification(kind NotificationKind, post *Post, message string) *Notification {
+	return &Notification{
+		ID:      uniq.Uniq(),
+		User:    post.Author.ID,
+		Kind:    kind,
+		Path:    post.Path,
+		Head:    post.Head,
+		Message: message,
+	}
+}
+
+// notify adds a notification to a write batch so it is sent only if the batch commits.
+func (f Forum) notify(wb *firestore.WriteBatch, n *Notification) {
+	wb.Create(f.fs.Collection(Notifications).Doc(n.ID), n)
+}
+
+// txNotify adds n to a transaction so it is committed with the action it reports.
+func (f Forum) txNotify(tx *firestore.Transaction, n *Notification) error {
+	return tx.Create(f.fs.Collection(Notifications).Doc(n.ID), n)
+}
+
+// GetNotifications returns up to n of a user's notifications, newest first.
+func (f Forum) GetNotifications(ctx Context, userID string, unreadOnly bool, n int) ([]*Notification, error) {
+	query := f.fs.Collection(Notifications).Where("User", "==", userID)
+	if unreadOnly {
+		query = query.Where("Read", "==", false)
+	}
+	docs, err := query.OrderBy("Time", firestore.Desc).Limit(n).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notifications: %w", err)
+	}
+	result := make([]*Notification, len(docs))
+	for k, doc := range docs {
+		note := &Notification{}
+		if err := doc.DataTo(note); err != nil {
+			return nil, fmt.Errorf("failed to decode notification: %w", err)
+		}
+		result[k] = note
+	}
+	return result, nil
+}
+
+// MarkNotificationRead marks one of a user's notifications as read.
+func (f Forum) MarkNotificationRead(ctx Context, userID string, id string) error {
+	doc := f.fs.Collection(Notifications).Doc(id)
+	snap, err := doc.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to mark notification read: %w", err)
+	}
+	note := &Notification{}
+	if err := snap.DataTo(note); err != nil {
+		return fmt.Errorf("failed to decode notification: %w", err)
+	}
+	if note.User != userID {
+		return fmt.Errorf("failed to mark notification read: %w", ErrNotPermitted)
+	}
+	if _, err := doc.Update(ctx, []firestore.Update{{Path: "Read", Value: true}}); err != nil {
+		return fmt.Errorf("failed to mark notification read: %w", err)
+	}
+	return nil
+}
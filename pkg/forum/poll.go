@@ -0,0 +1,281 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+const (
+	PollVotes      = "PollVotes"
+	MaxPollOptions = 20
+)
+
+var (
+	ErrNoPoll     = errors.New("thread has no poll")
+	ErrPollClosed = errors.New("poll is closed")
+	ErrAnonymous  = errors.New("poll is anonymous")
+	ErrNoPollKey  = errors.New("no key for anonymous votes")
+)
+
+// Poll is a question attached to a thread. It is stored in the thread's Post, so the
+// current results are read along with the thread.
+type Poll struct {
+	Question  string
+	Options   []string
+	Multi     bool      // Voters may choose more than one option
+	Anonymous bool      // Who voted for what is not recorded
+	Closes    time.Time // Voting stops at this time. Zero means the poll stays open.
+	Closed    bool      // Closed early by the thread author or a moderator
+	Counts    []int     // Number of votes for each option
+	Voters    int       // Number of users who have voted
+	KeyID     string    // Identifies the key an anonymous poll's ballots are keyed by
+}
+
+// IsOpen reports whether the poll accepts votes at time now.
+func (p *Poll) IsOpen(now time.Time) bool {
+	return !p.Closed && (p.Closes.IsZero() || now.Before(p.Closes))
+}
+
+// PollVote is one user's ballot. For anonymous polls the voter is identified only by
+// the document ID, a keyed hash of the voter, which keeps votes one-per-user without
+// revealing who cast them to anyone without the key.
+type PollVote struct {
+	Thread  PostID
+	Voter   *User // Nil for anonymous polls
+	Choices []int
+	Time    time.Time `firestore:",serverTimestamp"`
+}
+
+// newPoll validates a poll supplied to CreateThread and returns a copy with empty results.
+func newPoll(spec *Poll) (*Poll, error) {
+	if spec == nil {
+		return nil, nil
+	}
+	if spec.Question == "" {
+		return nil, fmt.Errorf("%w: poll has no question", ErrInvalid)
+	}
+	if len(spec.Options) < 2 || len(spec.Options) > MaxPollOptions {
+		return nil, fmt.Errorf("%w: poll must have between 2 and %d options", ErrInvalid, MaxPollOptions)
+	}
+	return &Poll{
+		Question:  spec.Question,
+		Options:   append([]string(nil), spec.Options...),
+		Multi:     spec.Multi,
+		Anonymous: spec.Anonymous,
+		Closes:    spec.Closes,
+		Counts:    make([]int, len(spec.Options)),
+	}, nil
+}
+
+// SetPollKey sets the secret that anonymous poll votes are keyed by. Each poll keeps the
+// key its first vote was keyed by, so that nobody can vote twice; pass the keys used
+// before as old to rotate the key without closing the polls that use them. An anonymous
+// poll whose key isn't set refuses votes with ErrNoPollKey.
+func (f *Forum) SetPollKey(key []byte, old ...[]byte) {
+	f.pollKeys = make(map[string][]byte)
+	for _, k := range old {
+		f.pollKeys[pollKeyID(k)] = append([]byte(nil), k...)
+	}
+	f.pollKeyID = pollKeyID(key)
+	f.pollKeys[f.pollKeyID] = append([]byte(nil), key...)
+}
+
+// pollKeyID returns the ID a poll records its key by. It reveals nothing about the key.
+func pollKeyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("poll-key:"), key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// anonymousVoteID returns the ID of a voter's ballot in an anonymous poll keyed by key.
+func anonymousVoteID(key []byte, threadID PostID, voterID string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(pairID(threadID, voterID)))
+	return "anon:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VotePoll records voter's choices in the poll attached to a thread. A user who votes
+// again replaces their earlier choices. Voting needs the same access to the section as
+// posting in it; a muted user may not vote, and a shadow-banned user's vote is quietly
+// left out.
+func (f Forum) VotePoll(ctx Context, threadID PostID, voter User, choices []int) error {
+	thread, err := f.getPost(ctx, threadID)
+	if err != nil {
+		return fmt.Errorf("failed to vote in poll %s: %w", threadID, err)
+	}
+	if thread.isSection() || thread.threadID() != threadID {
+		return fmt.Errorf("failed to vote in poll %s: %w", threadID, ErrNoPoll)
+	}
+	section, err := f.getPost(ctx, thread.Parent)
+	if err != nil {
+		return fmt.Errorf("failed to vote in poll %s: %w", threadID, err)
+	}
+	if err := f.requireAccess(ctx, voter, section); err != nil {
+		return fmt.Errorf("failed to vote in poll %s: %w", threadID, err)
+	}
+	shadow, err := f.checkSanctions(ctx, voter, thread.sectionPath())
+	if err != nil {
+		return fmt.Errorf("failed to vote in poll %s: %w", threadID, err)
+	}
+	threadDoc := f.fs.Collection(Root).Doc(threadID)
+	err = f.fs.RunTransaction(ctx, func(ctx Context, tx *firestore.Transaction) error {
+		thread, err := txPost(tx, threadDoc)
+		if err != nil {
+			return err
+		}
+		poll := thread.Poll
+		if poll == nil {
+			return ErrNoPoll
+		}
+		if thread.Deleted != nil || !poll.IsOpen(time.Now()) {
+			return ErrPollClosed
+		}
+		if err := checkChoices(poll, choices); err != nil {
+			return err
+		}
+		if shadow {
+			return nil
+		}
+		voteDoc := f.fs.Collection(PollVotes).Doc(pairID(threadID, voter.ID))
+		if poll.Anonymous {
+			if poll.KeyID == "" {
+				poll.KeyID = f.pollKeyID
+			}
+			key, ok := f.pollKeys[poll.KeyID]
+			if !ok {
+				return ErrNoPollKey
+			}
+			voteDoc = f.fs.Collection(PollVotes).Doc(anonymousVoteID(key, threadID, voter.ID))
+		}
+		old, err := txPollVote(tx, voteDoc)
+		if err != nil {
+			return err
+		}
+		if old == nil {
+			poll.Voters++
+		} else {
+			for _, c := range old.Choices {
+				if c >= 0 && c < len(poll.Counts) {
+					poll.Counts[c]--
+				}
+			}
+		}
+		for _, c := range choices {
+			poll.Counts[c]++
+		}
+		vote := &PollVote{Thread: threadID, Choices: choices}
+		if !poll.Anonymous {
+			vote.Voter = &voter
+		}
+		if err := tx.Set(voteDoc, vote); err != nil {
+			return err
+		}
+		return tx.Update(threadDoc, []firestore.Update{
+			{Path: "Poll.Counts", Value: poll.Counts},
+			{Path: "Poll.Voters", Value: poll.Voters},
+			{Path: "Poll.KeyID", Value: poll.KeyID},
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to vote in poll %s: %w", threadID, err)
+	}
+	return nil
+}
+
+// txPollVote reads a ballot in tx, or returns nil if there isn't one.
+func txPollVote(tx *firestore.Transaction, doc *firestore.DocumentRef) (*PollVote, error) {
+	snap, err := tx.Get(doc)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	vote := &PollVote{}
+	if err := snap.DataTo(vote); err != nil {
+		return nil, fmt.Errorf("failed to decode poll vote: %w", err)
+	}
+	return vote, nil
+}
+
+func checkChoices(poll *Poll, choices []int) error {
+	if len(choices) == 0 {
+		return fmt.Errorf("%w: no choices", ErrInvalid)
+	}
+	if !poll.Multi && len(choices) > 1 {
+		return fmt.Errorf("%w: poll allows a single choice", ErrInvalid)
+	}
+	seen := make(map[int]bool)
+	for _, c := range choices {
+		if c < 0 || c >= len(poll.Options) {
+			return fmt.Errorf("%w: no such option: %d", ErrInvalid, c)
+		}
+		if seen[c] {
+			return fmt.Errorf("%w: duplicate choice: %d", ErrInvalid, c)
+		}
+		seen[c] = true
+	}
+	return nil
+}
+
+// ClosePoll stops voting in a thread's poll. Only the thread author or a moderator may
+// close a poll.
+func (f Forum) ClosePoll(ctx Context, threadID PostID, user User) error {
+	thread, err := f.getPost(ctx, threadID)
+	if err != nil {
+		return fmt.Errorf("failed to close poll: %w", err)
+	}
+	if thread.Poll == nil {
+		return fmt.Errorf("failed to close poll: %w", ErrNoPoll)
+	}
+	if thread.Author.ID != user.ID {
+		moderator, err := f.IsModerator(ctx, user)
+		if err != nil {
+			return fmt.Errorf("failed to close poll: %w", err)
+		}
+		if !moderator {
+			return fmt.Errorf("failed to close poll: %w", ErrNotPermitted)
+		}
+	}
+	_, err = f.fs.Collection(Root).Doc(threadID).Update(ctx, []firestore.Update{
+		{Path: "Poll.Closed", Value: true},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to close poll: %w", err)
+	}
+	return nil
+}
+
+// GetPollVotes returns the individual votes in a public poll, if view's viewer can see
+// the thread.
+func (f Forum) GetPollVotes(ctx Context, threadID PostID, view View) ([]*PollVote, error) {
+	thread, err := f.GetPost(ctx, threadID, view)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get poll votes: %w", err)
+	}
+	if thread.Poll == nil {
+		return nil, fmt.Errorf("failed to get poll votes: %w", ErrNoPoll)
+	}
+	if thread.Poll.Anonymous {
+		return nil, fmt.Errorf("failed to get poll votes: %w", ErrAnonymous)
+	}
+	docs, err := f.fs.Collection(PollVotes).Where("Thread", "==", threadID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get poll votes: %w", err)
+	}
+	result := make([]*PollVote, len(docs))
+	for k, doc := range docs {
+		vote := &PollVote{}
+		if err := doc.DataTo(vote); err != nil {
+			return nil, fmt.Errorf("failed to decode poll vote: %w", err)
+		}
+		result[k] = vote
+	}
+	return result, nil
+}
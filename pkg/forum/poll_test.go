@@ -0,0 +1,124 @@
+package forum
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestForum_VotePoll(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	poll := &Poll{Question: "Best key?", Options: []string{"C", "G", "D"}}
+	thread, err := f.CreateThread(ctx, "Poll", "Vote!", mhc, gen[0], ThreadOptions{Poll: poll})
+	require.Nil(t, err)
+
+	require.Nil(t, f.VotePoll(ctx, thread[1], mhc, []int{0}))
+	require.Nil(t, f.VotePoll(ctx, thread[1], ella, []int{0}))
+	require.Nil(t, f.VotePoll(ctx, thread[1], ella, []int{2}))
+	assert.NotNil(t, f.VotePoll(ctx, thread[1], ella, []int{1, 2}))
+	assert.NotNil(t, f.VotePoll(ctx, thread[1], ella, []int{3}))
+
+	post, err := f.getPost(ctx, thread[1])
+	require.Nil(t, err)
+	require.NotNil(t, post.Poll)
+	assert.Equal(t, []int{1, 0, 1}, post.Poll.Counts)
+	assert.Equal(t, 2, post.Poll.Voters)
+
+	votes, err := f.GetPollVotes(ctx, thread[1], View{})
+	require.Nil(t, err)
+	assert.Len(t, votes, 2)
+
+	members, err := f.CreateSection(ctx, "Members", "Private", 200, moderator, SectionOptions{Visibility: MembersOnly})
+	require.Nil(t, err)
+	private, err := f.CreateThread(ctx, "Poll", "Vote!", moderator, members[0], ThreadOptions{Poll: poll})
+	require.Nil(t, err)
+	require.Nil(t, f.VotePoll(ctx, private[1], moderator, []int{0}))
+	_, err = f.GetPollVotes(ctx, private[1], View{Viewer: &ella})
+	assert.True(t, errors.Is(err, ErrNotFound))
+	votes, err = f.GetPollVotes(ctx, private[1], View{Viewer: &moderator})
+	require.Nil(t, err)
+	assert.Len(t, votes, 1)
+}
+
+func TestForum_AnonymousPoll(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	poll := &Poll{Question: "Secret?", Options: []string{"Yes", "No"}, Anonymous: true}
+	thread, err := f.CreateThread(ctx, "Poll", "Vote!", mhc, gen[0], ThreadOptions{Poll: poll})
+	require.Nil(t, err)
+	assert.True(t, errors.Is(f.VotePoll(ctx, thread[1], ella, []int{0}), ErrNoPollKey))
+
+	f.SetPollKey([]byte("secret"))
+	require.Nil(t, f.VotePoll(ctx, thread[1], ella, []int{0}))
+	require.Nil(t, f.VotePoll(ctx, thread[1], ella, []int{1}))
+	docs, err := f.fs.Collection(PollVotes).Where("Thread", "==", thread[1]).Documents(ctx).GetAll()
+	require.Nil(t, err)
+	require.Len(t, docs, 1)
+	assert.NotContains(t, docs[0].Ref.ID, ella.ID)
+	assert.Nil(t, docs[0].Data()["Voter"])
+	post, err := f.getPost(ctx, thread[1])
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, post.Poll.Counts)
+	assert.Equal(t, 1, post.Poll.Voters)
+
+	// A new key doesn't let anyone vote again in a poll keyed by the old one.
+	f.SetPollKey([]byte("new secret"))
+	assert.True(t, errors.Is(f.VotePoll(ctx, thread[1], ella, []int{0}), ErrNoPollKey))
+	f.SetPollKey([]byte("new secret"), []byte("secret"))
+	require.Nil(t, f.VotePoll(ctx, thread[1], ella, []int{0}))
+	post, err = f.getPost(ctx, thread[1])
+	require.Nil(t, err)
+	assert.Equal(t, []int{1, 0}, post.Poll.Counts)
+	assert.Equal(t, 1, post.Poll.Voters)
+
+	// Voting needs the same standing as posting.
+	_, err = f.Mute(ctx, ella, gen[0], time.Time{}, moderator, "spam")
+	require.Nil(t, err)
+	var sanction *SanctionError
+	assert.True(t, errors.As(f.VotePoll(ctx, thread[1], ella, []int{0}), &sanction))
+	members, err := f.CreateSection(ctx, "Members", "Private", 200, moderator, SectionOptions{Visibility: MembersOnly})
+	require.Nil(t, err)
+	private, err := f.CreateThread(ctx, "Poll", "Vote!", moderator, members[0], ThreadOptions{Poll: poll})
+	require.Nil(t, err)
+	assert.True(t, errors.Is(f.VotePoll(ctx, private[1], User{ID: "outsider"}, []int{0}), ErrNotPermitted))
+}
+
+func TestForum_ClosePoll(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	poll := &Poll{Question: "Tempo?", Options: []string{"Fast", "Slow"}, Multi: true, Anonymous: true}
+	thread, err := f.CreateThread(ctx, "Poll", "Vote!", mhc, gen[0], ThreadOptions{Poll: poll})
+	require.Nil(t, err)
+	f.SetPollKey([]byte("secret"))
+	require.Nil(t, f.VotePoll(ctx, thread[1], ella, []int{0, 1}))
+
+	_, err = f.GetPollVotes(ctx, thread[1], View{})
+	assert.True(t, errors.Is(err, ErrAnonymous))
+	assert.True(t, errors.Is(f.ClosePoll(ctx, thread[1], ella), ErrNotPermitted))
+	require.Nil(t, f.ClosePoll(ctx, thread[1], mhc))
+	assert.True(t, errors.Is(f.VotePoll(ctx, thread[1], mhc, []int{0}), ErrPollClosed))
+}
+
+func TestForum_PollCloseTime(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	poll := &Poll{Question: "Late?", Options: []string{"Yes", "No"}, Closes: time.Now().Add(-time.Minute)}
+	thread, err := f.CreateThread(ctx, "Poll", "Too late", mhc, gen[0], ThreadOptions{Poll: poll})
+	require.Nil(t, err)
+	assert.True(t, errors.Is(f.VotePoll(ctx, thread[1], mhc, []int{0}), ErrPollClosed))
+}
@@ -4,6 +4,7 @@ import (
 	"cloud.google.com/go/firestore"
 	"context"
 	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/search"
 	"time"
 )
 
@@ -20,7 +21,7 @@ type User struct {
 	ID       string
 	Name     string
 	PhotoURL string
-	Joined   time.Time
+	Joined   time.Time // Zero if unknown, in which case the user isn't treated as new
 }
 
 type DeleteInfo struct {
@@ -48,21 +49,81 @@ type Post struct {
 	DescendentCount int      // Number of direct and indirect children
 	ViewCount       int      // Number of times this post has been viewed
 	Deleted         *DeleteInfo
-	CreateTime      time.Time `firestore:",serverTimestamp"` // Time this post was created.
-	EditTime        time.Time `firestore:",serverTimestamp"` // Last time the header or body were edited
+	QA              bool         // Section (or thread in a section) that works as questions and answers
+	Score           int          // Up votes minus down votes (Q&A replies)
+	UpVotes         int          // Number of up votes (Q&A replies)
+	DownVotes       int          // Number of down votes (Q&A replies)
+	Accepted        bool         // This reply is the accepted answer to its thread
+	Answer          PostID       // ID of the accepted answer (Q&A threads)
+	Solved          bool         // True if a Q&A thread has an accepted answer
+	Poll            *Poll        // Poll attached to a thread, if any
+	Tags            []string     // Normalized tags (threads)
+	CuratedTags     bool         // Threads in this section may only use curated tags
+	Locked          bool         // No more replies may be added to this thread
+	Moderated       bool         // New posts in this section need a moderator's approval
+	Hidden          bool         // Section is listed only for moderators
+	Visibility      Visibility   // Who may read this section
+	Sections        int          // Number of sections at the start of Path. Zero, in older posts, means one.
+	Collapsed       bool         `firestore:"-"` // By a user the viewer has blocked. Set only in listings.
+	Shadow          bool         // Posted under a shadow ban; visible only to the author
+	Pending         *PendingInfo // Held for review; hidden until a moderator approves it
+	CreateTime      time.Time    `firestore:",serverTimestamp"` // Time this post was created.
+	EditTime        time.Time    `firestore:",serverTimestamp"` // Last time the header or body were edited
 }
 
 func (p *Post) ID() PostID {
 	return p.Path[len(p.Path)-1]
 }
 
+// sectionDepth returns how many elements at the start of the post's path are sections.
+func (p *Post) sectionDepth() int {
+	if p.Sections == 0 {
+		return 1
+	}
+	return p.Sections
+}
+
+// isSection reports whether the post is a section or sub-section.
+func (p *Post) isSection() bool {
+	return len(p.Path) == p.sectionDepth()
+}
+
+// sectionPath returns the path of the innermost section containing the post, or of the
+// post itself if it is a section.
+func (p *Post) sectionPath() []PostID {
+	return p.Path[:p.sectionDepth()]
+}
+
+// threadID returns the ID of the thread containing the post, or "" if the post is a
+// section.
+func (p *Post) threadID() PostID {
+	if p.isSection() {
+		return ""
+	}
+	return p.Path[p.sectionDepth()]
+}
+
+// hidden reports whether the post is kept out of listings, although it still exists.
+func (p *Post) hidden() bool {
+	return p.Shadow || p.Pending != nil
+}
+
 type Order struct {
 	Field     string
 	Direction firestore.Direction
 }
 
 type Forum struct {
-	fs *firestore.Client
+	fs        *firestore.Client
+	index     *search.Index
+	blobs     BlobStore
+	limits    AttachmentLimits
+	limiter   LimiterStore
+	rates     RateLimits
+	filters   []ContentFilter
+	broker    *Broker
+	pollKeys  map[string][]byte // By pollKeyID
+	pollKeyID string            // Key for polls with no votes yet
 }
 
 // NewClient returns a new forum client
@@ -71,7 +132,7 @@ func NewClient(ctx Context, projectId string) (*Forum, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create forum client: %w", err)
 	}
-	return &Forum{client}, nil
+	return &Forum{fs: client}, nil
 }
 
 // addPost adds a post to the forum and updates the parents.
@@ -89,7 +150,36 @@ func (f Forum) addPost(ctx Context, post *Post) ([]PostID, error) {
 	default:
 		post.Parent = post.Path[len(post.Path)-2]
 	}
+	hooks, err := f.getWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
 	wb := f.fs.Batch()
+	// A hidden post must not bump or count toward its ancestors, which would give it away.
+	if !post.hidden() {
+		f.countPost(wb, post)
+	}
+	wb.Create(f.fs.Collection(Root).Doc(post.ID()), post)
+	for _, tag := range post.Tags {
+		wb.Set(f.fs.Collection(Tags).Doc(tag), tagCount(tag, 1), firestore.MergeAll)
+	}
+	if err := f.queueWebhooks(wb, hooks, PostAdded, post); err != nil {
+		return nil, err
+	}
+
+	_, err = wb.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f.indexPost(post)
+	f.publish(ctx, PostAdded, post)
+	return post.Path, nil
+}
+
+// countPost adds to wb the updates that bump post's ancestors and count it among their
+// descendents.
+func (f Forum) countPost(wb *firestore.WriteBatch, post *Post) {
+	depth := len(post.Path)
 	for k := 0; k < depth-1; k++ {
 		doc := f.fs.Collection(Root).Doc(post.Path[k])
 		updates := []firestore.Update{
@@ -104,13 +194,6 @@ func (f Forum) addPost(ctx Context, post *Post) ([]PostID, error) {
 		}
 		wb.Update(doc, updates)
 	}
-	wb.Create(f.fs.Collection(Root).Doc(post.ID()), post)
-
-	_, err := wb.Commit(ctx)
-	if err != nil {
-		return nil, err
-	}
-	return post.Path, nil
 }
 
 func (f Forum) getPost(ctx Context, postID string) (*Post, error) {
@@ -135,11 +218,8 @@ func (f Forum) getChildren(ctx Context, parent PostID, cursor Cursor, n int) ([]
 	query := f.fs.
 		Collection(Root).
 		Where("Parent", "==", parent).
-		Where("Deleted", "==", nil).
-		OrderBy(cursor.field(), cursor.direction()).
-		StartAfter(cursor.value()).
-		Limit(n)
-	return f.performQuery(ctx, query, cursor, n)
+		Where("Deleted", "==", nil)
+	return f.paginateView(ctx, query, cursor, n, notPending)
 }
 
 // getTree returns the parent and all descendents.
@@ -150,29 +230,51 @@ func (f Forum) getTree(ctx Context, parent PostID, cursor Cursor, n int) ([]*Pos
 	query := f.fs.
 		Collection(Root).
 		Where("Path", "array-contains", parent).
-		Where("Deleted", "==", nil).
-		OrderBy(cursor.field(), cursor.direction()).
-		StartAfter(cursor.value()).
-		Limit(n)
-	return f.performQuery(ctx, query, cursor, n)
+		Where("Deleted", "==", nil)
+	return f.paginateView(ctx, query, cursor, n, notPending)
 }
 
-// expunge deletes all posts. Mostly useful for testing
+// notPending keeps posts that aren't held for review. Posts from before review existed
+// have no Pending field at all, so this can't be a query.
+func notPending(p *Post) bool {
+	return p.Pending == nil
+}
+
+// paginate orders query by cursor and returns the next n results.
+func (f Forum) paginate(ctx Context, query firestore.Query, cursor Cursor, n int) ([]*Post, Cursor, error) {
+	query = query.OrderBy(cursor.field(), cursor.direction())
+	if tb, ok := cursor.(tiebreaker); ok {
+		field, direction, value := tb.tiebreak()
+		query = query.OrderBy(field, direction).StartAfter(cursor.value(), value)
+	} else {
+		query = query.StartAfter(cursor.value())
+	}
+	return f.performQuery(ctx, query.Limit(n), cursor, n)
+}
+
+// expunge deletes all posts, and everything else that would otherwise outlive them.
+// Mostly useful for testing
 func (f Forum) expunge(ctx Context) {
-	path := f.fs.Collection(Root)
-	docs, err := path.Documents(ctx).GetAll()
-	if err != nil {
-		panic("failed to expunge posts")
+	collections := []string{
+		Root, Tags, Votes, PollVotes, Attachments, Reports, ReportCases, Warnings, AuditLog,
+		Moderators, Sanctions, RateLimitLog, Notifications, Members, Blocks, Conversations,
+		Messages, Bookmarks, Drafts, Webhooks, Deliveries,
 	}
-	count := 0
-	for _, doc := range docs {
-		_, err = f.fs.Collection(Root).Doc(doc.Ref.ID).Delete(ctx)
+	for _, collection := range collections {
+		docs, err := f.fs.Collection(collection).Documents(ctx).GetAll()
 		if err != nil {
-			count++
+			panic("failed to expunge posts")
+		}
+		count := 0
+		for _, doc := range docs {
+			_, err = doc.Ref.Delete(ctx)
+			if err != nil {
+				count++
+			}
+		}
+		if count > 0 {
+			panic("failed to expunge some posts")
 		}
-	}
-	if count > 0 {
-		panic("failed to expunge some posts")
 	}
 }
 
@@ -199,25 +301,84 @@ func (f Forum) performQuery(ctx Context, query firestore.Query, cursor Cursor, n
 
 // deletePost marks a post deleted. It does not actually delete the post or any children.
 func (f Forum) deletePost(ctx Context, postID PostID, who User, why string) error {
-	fmt.Println(postID)
 	path := f.fs.Collection(Root).Doc(postID)
-	fmt.Println(path.Path)
-	_, err := path.Update(ctx, []firestore.Update{
+	hooks, err := f.getWebhooks(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete post %s: %w", postID, err)
+	}
+	var after *Post
+	err = f.fs.RunTransaction(ctx, func(ctx Context, tx *firestore.Transaction) error {
+		post, err := txPost(tx, path)
+		if err != nil {
+			return err
+		}
+		after = nil
+		if post.Deleted != nil {
+			return nil
+		}
+		after, err = f.txDeletePost(tx, post, hooks, who, why)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete post %s: %w", postID, err)
+	}
+	if after != nil {
+		f.unindexPost(postID)
+		f.publish(ctx, PostDeleted, after)
+	}
+	return nil
+}
+
+// txDeletePost adds to tx the writes that mark post deleted, and returns the post as it
+// will be. The caller unindexes and publishes it once tx commits.
+func (f Forum) txDeletePost(tx *firestore.Transaction, post *Post, hooks []*Webhook, who User, why string) (*Post, error) {
+	after := *post
+	after.Deleted = &DeleteInfo{Who: who, Why: why}
+	err := tx.Update(f.fs.Collection(Root).Doc(post.ID()), []firestore.Update{
 		{Path: "Deleted.Who", Value: who},
 		{Path: "Deleted.Why", Value: why},
 		{Path: "Deleted.When", Value: firestore.ServerTimestamp},
 	})
 	if err != nil {
-		return fmt.Errorf("failed to delete post %s: %w", postID, err)
+		return nil, err
 	}
-	return nil
+	if err := f.txAudit(tx, newAuditEntry(who, AuditDelete, post, &after, why)); err != nil {
+		return nil, err
+	}
+	for _, tag := range post.Tags {
+		if err := tx.Set(f.fs.Collection(Tags).Doc(tag), tagCount(tag, -1), firestore.MergeAll); err != nil {
+			return nil, err
+		}
+	}
+	if err := f.txQueueWebhooks(tx, hooks, PostDeleted, &after); err != nil {
+		return nil, err
+	}
+	return &after, nil
 }
 
-func (f Forum) expungePost(ctx Context, postId PostID) error {
-	path := f.fs.Collection(Root).Doc(postId)
-	_, err := path.Delete(ctx)
+// expungePost permanently deletes a post along with its attachments.
+func (f Forum) expungePost(ctx Context, postId PostID, who User, why string) error {
+	post, err := f.getPost(ctx, postId)
+	if err != nil {
+		return fmt.Errorf("failed to expunge doc %s: %w", postId, err)
+	}
+	if err := f.deleteAttachments(ctx, postId); err != nil {
+		return fmt.Errorf("failed to expunge doc %s: %w", postId, err)
+	}
+	wb := f.fs.Batch()
+	wb.Delete(f.fs.Collection(Root).Doc(postId))
+	// A deleted post's tags were already taken off when it was deleted.
+	if post.Deleted == nil {
+		for _, tag := range post.Tags {
+			wb.Set(f.fs.Collection(Tags).Doc(tag), tagCount(tag, -1), firestore.MergeAll)
+		}
+	}
+	f.audit(wb, newAuditEntry(who, AuditExpunge, post, nil, why))
+	_, err = wb.Commit(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to expunge doc %s: %w", postId, err)
 	}
+	f.unindexPost(postId)
+	f.publish(ctx, PostDeleted, post)
 	return nil
 }
@@ -243,3 +243,31 @@ func RandomUser() User {
 		Joined:   time.Now(),
 	}
 }
+
+func TestForum_LegacyPostsListed(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	// A thread written before posts had Pending or Shadow fields.
+	_, err = f.fs.Collection(Root).Doc("legacy").Set(ctx, map[string]interface{}{
+		"Path":       []PostID{gen[0], "legacy"},
+		"Parent":     gen[0],
+		"Head":       "Old",
+		"Body":       "From before",
+		"Author":     mhc,
+		"Deleted":    nil,
+		"Bump":       map[string]interface{}{"Time": time.Now()},
+		"CreateTime": time.Now(),
+		"EditTime":   time.Now(),
+	})
+	require.Nil(t, err)
+	threads, _, err := f.GetThreads(ctx, gen[0], nil, 10, View{})
+	require.Nil(t, err)
+	require.Len(t, threads, 1)
+	assert.Equal(t, "legacy", threads[0].ID())
+	children, _, err := f.getChildren(ctx, gen[0], &CreateTimeAsc{}, 10)
+	require.Nil(t, err)
+	assert.Len(t, children, 1)
+}
@@ -0,0 +1,201 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+const Votes = "Votes"
+
+var (
+	ErrNotQA        = errors.New("not a Q&A thread")
+	ErrNotPermitted = errors.New("not permitted")
+)
+
+// Vote is a single user's vote on a reply in a Q&A thread.
+type Vote struct {
+	Post  PostID
+	Voter User
+	Value int       // +1 or -1
+	Time  time.Time `firestore:",serverTimestamp"`
+}
+
+// pairID is the document ID for something a user may do at most once per post.
+func pairID(postID PostID, userID string) string {
+	return postID + ":" + userID
+}
+
+// Vote records voter's vote on a reply in a Q&A thread. Value is +1 for an up vote,
+// -1 for a down vote, or 0 to withdraw a previous vote. Voting again replaces the
+// previous vote.
+func (f Forum) Vote(ctx Context, replyID PostID, voter User, value int) error {
+	if value < -1 || value > 1 {
+		return fmt.Errorf("invalid vote %d", value)
+	}
+	replyDoc := f.fs.Collection(Root).Doc(replyID)
+	voteDoc := f.fs.Collection(Votes).Doc(pairID(replyID, voter.ID))
+	err := f.fs.RunTransaction(ctx, func(ctx Context, tx *firestore.Transaction) error {
+		reply, err := txPost(tx, replyDoc)
+		if err != nil {
+			return err
+		}
+		if !reply.QA || len(reply.Path) < reply.sectionDepth()+2 {
+			return ErrNotQA
+		}
+		old := 0
+		snap, err := tx.Get(voteDoc)
+		switch {
+		case status.Code(err) == codes.NotFound:
+		case err != nil:
+			return err
+		default:
+			var v Vote
+			if err := snap.DataTo(&v); err != nil {
+				return err
+			}
+			old = v.Value
+		}
+		if old == value {
+			return nil
+		}
+		if value == 0 {
+			err = tx.Delete(voteDoc)
+		} else {
+			err = tx.Set(voteDoc, &Vote{Post: replyID, Voter: voter, Value: value})
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Update(replyDoc, []firestore.Update{
+			{Path: "Score", Value: firestore.Increment(value - old)},
+			{Path: "UpVotes", Value: firestore.Increment(ups(value) - ups(old))},
+			{Path: "DownVotes", Value: firestore.Increment(ups(-value) - ups(-old))},
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to vote on %s: %w", replyID, err)
+	}
+	return nil
+}
+
+func ups(value int) int {
+	if value > 0 {
+		return 1
+	}
+	return 0
+}
+
+// AcceptAnswer marks a reply as the accepted answer to a Q&A thread, replacing any
+// previously accepted answer. An empty answerID clears the accepted answer. Only the
+// author of the thread or a moderator may accept an answer.
+func (f Forum) AcceptAnswer(ctx Context, threadID PostID, answerID PostID, user User) error {
+	moderator, err := f.IsModerator(ctx, user)
+	if err != nil {
+		return fmt.Errorf("failed to accept answer: %w", err)
+	}
+	threadDoc := f.fs.Collection(Root).Doc(threadID)
+	err = f.fs.RunTransaction(ctx, func(ctx Context, tx *firestore.Transaction) error {
+		thread, err := txPost(tx, threadDoc)
+		if err != nil {
+			return err
+		}
+		if !thread.QA || thread.isSection() || thread.threadID() != thread.ID() {
+			return ErrNotQA
+		}
+		if thread.Author.ID != user.ID && !moderator {
+			return ErrNotPermitted
+		}
+		if answerID != "" {
+			answer, err := txPost(tx, f.fs.Collection(Root).Doc(answerID))
+			if err != nil {
+				return err
+			}
+			if answer.threadID() != threadID || answer.ID() == threadID || answer.Deleted != nil {
+				return fmt.Errorf("%s is not a reply to %s", answerID, threadID)
+			}
+		}
+		if thread.Answer == answerID {
+			return nil
+		}
+		if thread.Answer != "" {
+			err = tx.Update(f.fs.Collection(Root).Doc(thread.Answer), []firestore.Update{
+				{Path: "Accepted", Value: false},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if answerID != "" {
+			err = tx.Update(f.fs.Collection(Root).Doc(answerID), []firestore.Update{
+				{Path: "Accepted", Value: true},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Update(threadDoc, []firestore.Update{
+			{Path: "Answer", Value: answerID},
+			{Path: "Solved", Value: answerID != ""},
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to accept answer: %w", err)
+	}
+	return nil
+}
+
+// getAnswers returns the direct replies to a Q&A thread ordered by score, with the
+// accepted answer first.
+func (f Forum) getAnswers(ctx Context, threadID PostID, cursor *ScoreDesc, n int, view View) ([]*Post, Cursor, error) {
+	var result []*Post
+	query := f.fs.
+		Collection(Root).
+		Where("Parent", "==", threadID).
+		Where("Deleted", "==", nil).
+		Where("Accepted", "==", false)
+	query, keep, err := f.applyView(ctx, query, view)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !cursor.after {
+		thread, err := f.getPost(ctx, threadID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if thread.Answer != "" {
+			answer, err := f.getPost(ctx, thread.Answer)
+			if err != nil {
+				return nil, nil, err
+			}
+			if answer.Deleted == nil && keep(answer) {
+				result = append(result, answer)
+			}
+		}
+	}
+	remaining := n - len(result)
+	if remaining <= 0 {
+		return result, &ScoreDesc{after: true}, nil
+	}
+	posts, next, err := f.paginateView(ctx, query, cursor, remaining, keep)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(result, posts...), next, nil
+}
+
+// txPost reads and decodes a post inside a transaction.
+func txPost(tx *firestore.Transaction, doc *firestore.DocumentRef) (*Post, error) {
+	snap, err := tx.Get(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read post %s: %w", doc.ID, err)
+	}
+	post := &Post{}
+	if err := snap.DataTo(post); err != nil {
+		return nil, fmt.Errorf("failed to decode post %s: %w", doc.ID, err)
+	}
+	return post, nil
+}
@@ -0,0 +1,126 @@
+package forum
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestForum_Vote(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	qa, err := f.CreateSection(ctx, "Questions", "Ask here", 100, mhc, SectionOptions{QA: true})
+	require.Nil(t, err)
+	question, err := f.CreateThread(ctx, "How?", "How do I do it?", mhc, qa[0], ThreadOptions{})
+	require.Nil(t, err)
+	answer, err := f.CreateReply(ctx, question, "How?", "Like this", ella)
+	require.Nil(t, err)
+	id := answer[len(answer)-1]
+
+	require.Nil(t, f.Vote(ctx, id, mhc, 1))
+	require.Nil(t, f.Vote(ctx, id, mhc, 1))
+	require.Nil(t, f.Vote(ctx, id, ella, -1))
+	post, err := f.getPost(ctx, id)
+	require.Nil(t, err)
+	assert.Equal(t, 0, post.Score)
+	assert.Equal(t, 1, post.UpVotes)
+	assert.Equal(t, 1, post.DownVotes)
+
+	require.Nil(t, f.Vote(ctx, id, ella, 0))
+	post, err = f.getPost(ctx, id)
+	require.Nil(t, err)
+	assert.Equal(t, 1, post.Score)
+	assert.Equal(t, 0, post.DownVotes)
+}
+
+func TestForum_VoteNotQA(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Hi", "Hello", mhc, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+	reply, err := f.CreateReply(ctx, thread, "Hi", "Hello back", ella)
+	require.Nil(t, err)
+	err = f.Vote(ctx, reply[len(reply)-1], mhc, 1)
+	assert.True(t, errors.Is(err, ErrNotQA))
+}
+
+func TestForum_AcceptAnswer(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	qa, err := f.CreateSection(ctx, "Questions", "Ask here", 100, mhc, SectionOptions{QA: true})
+	require.Nil(t, err)
+	question, err := f.CreateThread(ctx, "How?", "How do I do it?", mhc, qa[0], ThreadOptions{})
+	require.Nil(t, err)
+	other, err := f.CreateThread(ctx, "Why?", "Why do it?", mhc, qa[0], ThreadOptions{})
+	require.Nil(t, err)
+	good, err := f.CreateReply(ctx, question, "How?", "Like this", ella)
+	require.Nil(t, err)
+	popular, err := f.CreateReply(ctx, question, "How?", "Like that", ella)
+	require.Nil(t, err)
+	require.Nil(t, f.Vote(ctx, popular[2], ella, 1))
+
+	err = f.AcceptAnswer(ctx, question[1], good[2], ella)
+	assert.True(t, errors.Is(err, ErrNotPermitted))
+	require.Nil(t, f.AcceptAnswer(ctx, question[1], good[2], mhc))
+
+	answers, _, err := f.GetReplies(ctx, question[1], &ScoreDesc{}, 10, View{})
+	require.Nil(t, err)
+	require.Len(t, answers, 2)
+	assert.Equal(t, good[2], answers[0].ID())
+	assert.True(t, answers[0].Accepted)
+	assert.Equal(t, popular[2], answers[1].ID())
+
+	// Answers the viewer can't see don't leave the page short.
+	spammer := User{ID: "spammer"}
+	spam, err := f.CreateReply(ctx, question, "How?", "Buy now", spammer)
+	require.Nil(t, err)
+	require.Nil(t, f.Vote(ctx, spam[2], mhc, 1))
+	require.Nil(t, f.Vote(ctx, spam[2], ella, 1))
+	require.Nil(t, f.Block(ctx, mhc, spammer))
+	answers, cursor, err := f.GetReplies(ctx, question[1], &ScoreDesc{}, 2, View{Viewer: &mhc})
+	require.Nil(t, err)
+	require.Len(t, answers, 2)
+	assert.Equal(t, good[2], answers[0].ID())
+	assert.Equal(t, popular[2], answers[1].ID())
+	require.NotNil(t, cursor)
+	answers, cursor, err = f.GetReplies(ctx, question[1], cursor, 2, View{Viewer: &mhc})
+	require.Nil(t, err)
+	assert.Empty(t, answers)
+	assert.Nil(t, cursor)
+
+	solved, _, err := f.GetThreads(ctx, qa[0], nil, 10, View{Solved: SolvedOnly})
+	require.Nil(t, err)
+	require.Len(t, solved, 1)
+	assert.Equal(t, question[1], solved[0].ID())
+	unsolved, _, err := f.GetThreads(ctx, qa[0], nil, 10, View{Solved: UnsolvedOnly})
+	require.Nil(t, err)
+	require.Len(t, unsolved, 1)
+	assert.Equal(t, other[1], unsolved[0].ID())
+}
+
+func TestForum_AcceptAnswerInSubsection(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	root := User{ID: admin, Name: adminDisplay}
+	help, err := f.CreateSection(ctx, "Help", "Get help", 100, root, SectionOptions{})
+	require.Nil(t, err)
+	qa, err := f.CreateSubsection(ctx, help[0], "Questions", "Ask here", 1, root, SectionOptions{QA: true})
+	require.Nil(t, err)
+	question, err := f.CreateThread(ctx, "How?", "How do I do it?", mhc, qa[len(qa)-1], ThreadOptions{})
+	require.Nil(t, err)
+	answer, err := f.CreateReply(ctx, question, "How?", "Like this", ella)
+	require.Nil(t, err)
+
+	require.Nil(t, f.AcceptAnswer(ctx, question[len(question)-1], answer[len(answer)-1], mhc))
+	thread, err := f.getPost(ctx, question[len(question)-1])
+	require.Nil(t, err)
+	assert.True(t, thread.Solved)
+	assert.True(t, errors.Is(f.AcceptAnswer(ctx, qa[len(qa)-1], "", root), ErrNotQA))
+}
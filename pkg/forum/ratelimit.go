@@ -0,0 +1,200 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/ratelimit"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+const RateLimitLog = "RateLimits"
+
+// LimiterStore records recent events for rate limiting. A store shared between servers
+// makes limits apply across all of them.
+type LimiterStore interface {
+	// Wait returns how long until one more event could be recorded under key without
+	// more than limit falling in the window ending then. It records nothing.
+	Wait(ctx Context, key string, limit int, window time.Duration, now time.Time) (time.Duration, error)
+	// Record records an event under key at time now. Events older than window may be
+	// forgotten.
+	Record(ctx Context, key string, window time.Duration, now time.Time) error
+}
+
+// RateLimits caps how fast a user may post. Zero fields are unlimited. Moderators are
+// never limited.
+type RateLimits struct {
+	PostsPerMinute        int           // Threads and replies
+	ThreadsPerHour        int           // New threads only
+	NewUserAge            time.Duration // New users, as isNewUser decides, get the limits below
+	NewUserPostsPerMinute int
+	NewUserThreadsPerHour int
+}
+
+var DefaultRateLimits = RateLimits{
+	PostsPerMinute:        10,
+	ThreadsPerHour:        20,
+	NewUserAge:            24 * time.Hour,
+	NewUserPostsPerMinute: 2,
+	NewUserThreadsPerHour: 3,
+}
+
+// RateLimitError is returned when a user posts faster than the rate limits allow.
+type RateLimitError struct {
+	RetryAfter time.Duration // How long until the post would be accepted
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// SetRateLimits enables rate limiting of new posts, with events recorded in store.
+func (f *Forum) SetRateLimits(store LimiterStore, limits RateLimits) {
+	f.limiter = store
+	f.rates = limits
+}
+
+// isNewUser reports whether user joined less than age before now. Only callers know when
+// a user joined, so a user whose Joined is zero isn't new; callers that want new users
+// limited must fill it in. With age zero nobody is new. Rate limits and LinkLimit both
+// use it, so they agree on who is new.
+func isNewUser(user User, age time.Duration, now time.Time) bool {
+	if age <= 0 || user.Joined.IsZero() {
+		return false
+	}
+	return now.Sub(user.Joined) < age
+}
+
+// userRates returns the limits that apply to user. Both are zero for moderators.
+func (f Forum) userRates(ctx Context, user User, now time.Time) (posts int, threads int, err error) {
+	isMod, err := f.IsModerator(ctx, user)
+	if err != nil {
+		return 0, 0, err
+	}
+	if isMod {
+		return 0, 0, nil
+	}
+	if isNewUser(user, f.rates.NewUserAge, now) {
+		return f.rates.NewUserPostsPerMinute, f.rates.NewUserThreadsPerHour, nil
+	}
+	return f.rates.PostsPerMinute, f.rates.ThreadsPerHour, nil
+}
+
+// checkRate returns a *RateLimitError if user has posted too much recently to post again.
+// It records nothing: chargeRate does that once the post is stored, so that posts refused
+// for any reason, or held for approval, don't use up the allowance. Two posts made at the
+// same moment may both get through.
+func (f Forum) checkRate(ctx Context, user User, thread bool) error {
+	if f.limiter == nil {
+		return nil
+	}
+	now := time.Now()
+	posts, threads, err := f.userRates(ctx, user, now)
+	if err != nil {
+		return err
+	}
+	var wait time.Duration
+	if thread && threads > 0 {
+		wait, err = f.limiter.Wait(ctx, "threads:"+user.ID, threads, time.Hour, now)
+		if err != nil {
+			return err
+		}
+	}
+	if posts > 0 {
+		w, err := f.limiter.Wait(ctx, "posts:"+user.ID, posts, time.Minute, now)
+		if err != nil {
+			return err
+		}
+		if w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		return &RateLimitError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// chargeRate counts a post that has been stored against user's allowance. Held posts
+// aren't counted until they're approved, if ever.
+func (f Forum) chargeRate(ctx Context, post *Post, thread bool) error {
+	if f.limiter == nil || post.Pending != nil {
+		return nil
+	}
+	now := time.Now()
+	posts, threads, err := f.userRates(ctx, post.Author, now)
+	if err != nil {
+		return err
+	}
+	if thread && threads > 0 {
+		if err := f.limiter.Record(ctx, "threads:"+post.Author.ID, time.Hour, now); err != nil {
+			return err
+		}
+	}
+	if posts > 0 {
+		if err := f.limiter.Record(ctx, "posts:"+post.Author.ID, time.Minute, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SharedLimiter returns a LimiterStore kept in the database, so that limits apply across
+// every server using it.
+func (f Forum) SharedLimiter() LimiterStore {
+	return firestoreLimiter{fs: f.fs}
+}
+
+type firestoreLimiter struct {
+	fs *firestore.Client
+}
+
+type limiterLog struct {
+	Events []time.Time
+}
+
+func (l firestoreLimiter) read(ctx Context, key string) (*limiterLog, error) {
+	log := &limiterLog{}
+	snap, err := l.fs.Collection(RateLimitLog).Doc(key).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return log, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := snap.DataTo(log); err != nil {
+		return nil, fmt.Errorf("failed to decode rate limit log: %w", err)
+	}
+	return log, nil
+}
+
+func (l firestoreLimiter) Wait(ctx Context, key string, limit int, window time.Duration, now time.Time) (time.Duration, error) {
+	log, err := l.read(ctx, key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	return ratelimit.Delay(ratelimit.Prune(log.Events, window, now), limit, window, now), nil
+}
+
+func (l firestoreLimiter) Record(ctx Context, key string, window time.Duration, now time.Time) error {
+	doc := l.fs.Collection(RateLimitLog).Doc(key)
+	err := l.fs.RunTransaction(ctx, func(ctx Context, tx *firestore.Transaction) error {
+		log := &limiterLog{}
+		snap, err := tx.Get(doc)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := snap.DataTo(log); err != nil {
+				return fmt.Errorf("failed to decode rate limit log: %w", err)
+			}
+		}
+		log.Events = append(ratelimit.Prune(log.Events, window, now), now)
+		return tx.Set(doc, log)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record rate limit event: %w", err)
+	}
+	return nil
+}
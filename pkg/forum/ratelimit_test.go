@@ -0,0 +1,107 @@
+package forum
+
+import (
+	"errors"
+	"github.com/mhcoffin/forum-tools/pkg/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestForum_RateLimits(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	store := ratelimit.NewMemory()
+	f.SetRateLimits(store, RateLimits{
+		PostsPerMinute:        3,
+		ThreadsPerHour:        1,
+		NewUserAge:            time.Hour,
+		NewUserPostsPerMinute: 1,
+	})
+	regular := User{ID: "jane", Name: "Ella", Joined: time.Now().Add(-48 * time.Hour)}
+
+	thread, err := f.CreateThread(ctx, "Hi", "Hello", regular, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+	_, err = f.CreateThread(ctx, "Hi", "Hello", regular, gen[0], ThreadOptions{})
+	var limited *RateLimitError
+	require.True(t, errors.As(err, &limited))
+	assert.True(t, limited.RetryAfter > 59*time.Minute)
+
+	for k := 0; k < 2; k++ {
+		_, err = f.CreateReply(ctx, thread, "Re", "Hello", regular)
+		require.Nil(t, err)
+	}
+	_, err = f.CreateReply(ctx, thread, "Re", "Hello", regular)
+	require.True(t, errors.As(err, &limited))
+	assert.True(t, limited.RetryAfter <= time.Minute)
+
+	// Posts the filters refuse don't use up the allowance.
+	f.AddFilter(BannedWords("spam"))
+	newbie := User{ID: "newbie", Name: "New User", Joined: time.Now()}
+	_, err = f.CreateReply(ctx, thread, "Re", "spam", newbie)
+	assert.NotNil(t, err)
+	assert.False(t, errors.As(err, &limited))
+	_, err = f.CreateReply(ctx, thread, "Re", "Hello", newbie)
+	require.Nil(t, err)
+	_, err = f.CreateReply(ctx, thread, "Re", "Hello", newbie)
+	assert.True(t, errors.As(err, &limited))
+
+	// A user whose join time is unknown gets the regular limits.
+	unknown := User{ID: "unknown", Name: "Who?"}
+	for k := 0; k < 3; k++ {
+		_, err = f.CreateReply(ctx, thread, "Re", "Hello", unknown)
+		require.Nil(t, err)
+	}
+	_, err = f.CreateReply(ctx, thread, "Re", "Hello", unknown)
+	assert.True(t, errors.As(err, &limited))
+
+	// A thread refused by the posts limit doesn't use up a thread.
+	_, err = f.CreateThread(ctx, "Hi", "Hello", unknown, gen[0], ThreadOptions{})
+	assert.True(t, errors.As(err, &limited))
+	wait, err := store.Wait(ctx, "threads:unknown", 1, time.Hour, time.Now())
+	require.Nil(t, err)
+	assert.Zero(t, wait)
+
+	// Nor do posts held for approval.
+	held, err := f.CreateSection(ctx, "Held", "Moderated", 200, mhc, SectionOptions{Moderated: true})
+	require.Nil(t, err)
+	patient := User{ID: "patient", Name: "Patient", Joined: time.Now().Add(-48 * time.Hour)}
+	for k := 0; k < 2; k++ {
+		_, err = f.CreateThread(ctx, "Hi", "Hello", patient, held[0], ThreadOptions{})
+		require.Nil(t, err)
+	}
+
+	for k := 0; k < 5; k++ {
+		_, err = f.CreateReply(ctx, thread, "Re", "Hello", moderator)
+		require.Nil(t, err)
+	}
+}
+
+func TestForum_SharedLimiter(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	store := f.SharedLimiter()
+	now := time.Now().Truncate(time.Microsecond)
+	for k := 0; k < 2; k++ {
+		wait, err := store.Wait(ctx, "posts:ella", 2, time.Minute, now)
+		require.Nil(t, err)
+		assert.Zero(t, wait)
+		require.Nil(t, store.Record(ctx, "posts:ella", time.Minute, now))
+	}
+	wait, err := store.Wait(ctx, "posts:ella", 2, time.Minute, now.Add(time.Second))
+	require.Nil(t, err)
+	assert.Equal(t, 59*time.Second, wait)
+}
+
+func TestIsNewUser(t *testing.T) {
+	now := time.Now()
+	assert.True(t, isNewUser(User{Joined: now.Add(-time.Minute)}, time.Hour, now))
+	assert.False(t, isNewUser(User{Joined: now.Add(-2 * time.Hour)}, time.Hour, now))
+	assert.False(t, isNewUser(User{}, time.Hour, now))
+	assert.False(t, isNewUser(User{}, 0, now))
+}
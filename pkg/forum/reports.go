@@ -0,0 +1,375 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+const (
+	Reports     = "Reports"
+	ReportCases = "ReportCases"
+	Warnings    = "Warnings"
+)
+
+var (
+	ErrAlreadyReported = errors.New("already reported")
+	ErrLocked          = errors.New("thread is locked")
+	ErrNoOpenReport    = errors.New("no open report")
+)
+
+// ReportCategory says what is wrong with a reported post.
+type ReportCategory string
+
+const (
+	ReportSpam       ReportCategory = "spam"
+	ReportOffTopic   ReportCategory = "off-topic"
+	ReportHarassment ReportCategory = "harassment"
+	ReportIllegal    ReportCategory = "illegal"
+	ReportOther      ReportCategory = "other"
+)
+
+// Severity returns how urgently reports in this category need attention. Higher is
+// more urgent.
+func (c ReportCategory) Severity() int {
+	switch c {
+	case ReportIllegal:
+		return 4
+	case ReportHarassment:
+		return 3
+	case ReportSpam:
+		return 2
+	case ReportOffTopic, ReportOther:
+		return 1
+	}
+	return 0
+}
+
+// Report is one member's complaint about a post.
+type Report struct {
+	Post     PostID
+	Reporter User
+	Reason   string
+	Category ReportCategory
+	Time     time.Time `firestore:",serverTimestamp"`
+}
+
+// ResolveAction is what a moderator did about a reported post.
+type ResolveAction string
+
+const (
+	ResolveDismiss ResolveAction = "dismiss"
+	ResolveDelete  ResolveAction = "delete"
+	ResolveLock    ResolveAction = "lock"
+	ResolveWarn    ResolveAction = "warn"
+)
+
+// Resolution records how a moderator closed a report case.
+type Resolution struct {
+	Action    ResolveAction
+	Moderator User
+	Note      string
+	Time      time.Time
+}
+
+// ReportCase aggregates the reports against one post.
+type ReportCase struct {
+	Post       PostID
+	Path       []PostID
+	Head       string
+	Author     User
+	Count      int // Number of reports
+	Severity   int // Highest severity of any report
+	Categories []ReportCategory
+	Open       bool
+	FirstTime  time.Time
+	LastTime   time.Time
+	Resolution *Resolution // How the case was last closed
+}
+
+// Warning is a moderator's warning to a user.
+type Warning struct {
+	User      User
+	Moderator User
+	Post      PostID
+	Reason    string
+	Time      time.Time `firestore:",serverTimestamp"`
+}
+
+// ReportPost flags a post for moderator attention. Each member may report a post once;
+// later reports are added to the post's case, reopening it if it was resolved.
+func (f Forum) ReportPost(ctx Context, postID PostID, reporter User, reason string, category ReportCategory) error {
+	if category.Severity() == 0 {
+		return fmt.Errorf("failed to report post: %w: unknown category %q", ErrInvalid, category)
+	}
+	postDoc := f.fs.Collection(Root).Doc(postID)
+	reportDoc := f.fs.Collection(Reports).Doc(pairID(postID, reporter.ID))
+	caseDoc := f.fs.Collection(ReportCases).Doc(postID)
+	err := f.fs.RunTransaction(ctx, func(ctx Context, tx *firestore.Transaction) error {
+		post, err := txPost(tx, postDoc)
+		if err != nil {
+			return err
+		}
+		if post.Deleted != nil {
+			return fmt.Errorf("post %s: %w", postID, ErrDeleted)
+		}
+		_, err = tx.Get(reportDoc)
+		if err == nil {
+			return ErrAlreadyReported
+		}
+		if status.Code(err) != codes.NotFound {
+			return err
+		}
+		rc, err := txReportCase(tx, caseDoc)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if rc == nil {
+			rc = &ReportCase{Post: postID, FirstTime: now}
+		}
+		rc.Path = post.Path
+		rc.Head = post.Head
+		rc.Author = post.Author
+		rc.Count++
+		if category.Severity() > rc.Severity {
+			rc.Severity = category.Severity()
+		}
+		if !hasCategory(rc.Categories, category) {
+			rc.Categories = append(rc.Categories, category)
+		}
+		rc.Open = true
+		rc.LastTime = now
+		report := &Report{Post: postID, Reporter: reporter, Reason: reason, Category: category}
+		if err := tx.Create(reportDoc, report); err != nil {
+			return err
+		}
+		return tx.Set(caseDoc, rc)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to report post %s: %w", postID, err)
+	}
+	return nil
+}
+
+func hasCategory(categories []ReportCategory, c ReportCategory) bool {
+	for _, x := range categories {
+		if x == c {
+			return true
+		}
+	}
+	return false
+}
+
+func txReportCase(tx *firestore.Transaction, doc *firestore.DocumentRef) (*ReportCase, error) {
+	snap, err := tx.Get(doc)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &ReportCase{}
+	if err := snap.DataTo(rc); err != nil {
+		return nil, fmt.Errorf("failed to decode report case: %w", err)
+	}
+	return rc, nil
+}
+
+// ModerationQueue returns up to n open report cases, most severe and most reported first.
+func (f Forum) ModerationQueue(ctx Context, moderator User, n int) ([]*ReportCase, error) {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return nil, fmt.Errorf("failed to read moderation queue: %w", err)
+	}
+	docs, err := f.fs.
+		Collection(ReportCases).
+		Where("Open", "==", true).
+		OrderBy("Severity", firestore.Desc).
+		OrderBy("Count", firestore.Desc).
+		OrderBy("FirstTime", firestore.Asc).
+		Limit(n).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read moderation queue: %w", err)
+	}
+	result := make([]*ReportCase, len(docs))
+	for k, doc := range docs {
+		rc := &ReportCase{}
+		if err := doc.DataTo(rc); err != nil {
+			return nil, fmt.Errorf("failed to decode report case: %w", err)
+		}
+		result[k] = rc
+	}
+	return result, nil
+}
+
+// GetReports returns the individual reports against a post.
+func (f Forum) GetReports(ctx Context, postID PostID, moderator User) ([]*Report, error) {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return nil, fmt.Errorf("failed to get reports: %w", err)
+	}
+	docs, err := f.fs.Collection(Reports).Where("Post", "==", postID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reports: %w", err)
+	}
+	result := make([]*Report, len(docs))
+	for k, doc := range docs {
+		report := &Report{}
+		if err := doc.DataTo(report); err != nil {
+			return nil, fmt.Errorf("failed to decode report: %w", err)
+		}
+		result[k] = report
+	}
+	return result, nil
+}
+
+// ResolveReport takes action on a post and closes the report case against it, together,
+// so that retrying a resolution that failed can't act twice. The case must be open.
+func (f Forum) ResolveReport(ctx Context, postID PostID, moderator User, action ResolveAction, note string) error {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return fmt.Errorf("failed to resolve report: %w", err)
+	}
+	switch action {
+	case ResolveDismiss, ResolveDelete, ResolveLock, ResolveWarn:
+	default:
+		return fmt.Errorf("failed to resolve report: %w: unknown action %q", ErrInvalid, action)
+	}
+	var hooks []*Webhook
+	if action == ResolveDelete {
+		var err error
+		if hooks, err = f.getWebhooks(ctx); err != nil {
+			return fmt.Errorf("failed to resolve report: %w", err)
+		}
+	}
+	caseDoc := f.fs.Collection(ReportCases).Doc(postID)
+	var deleted *Post
+	err := f.fs.RunTransaction(ctx, func(ctx Context, tx *firestore.Transaction) error {
+		deleted = nil
+		rc, err := txReportCase(tx, caseDoc)
+		if err != nil {
+			return err
+		}
+		if rc == nil || !rc.Open {
+			return ErrNoOpenReport
+		}
+		post, err := txPost(tx, f.fs.Collection(Root).Doc(postID))
+		if err != nil {
+			return err
+		}
+		var thread *Post
+		if action == ResolveLock {
+			if post.isSection() {
+				return fmt.Errorf("%w: %s is not in a thread", ErrInvalid, postID)
+			}
+			if thread, err = txPost(tx, f.fs.Collection(Root).Doc(post.threadID())); err != nil {
+				return err
+			}
+		}
+		switch action {
+		case ResolveDelete:
+			if post.Deleted == nil {
+				if deleted, err = f.txDeletePost(tx, post, hooks, moderator, note); err != nil {
+					return err
+				}
+			}
+		case ResolveLock:
+			after := *thread
+			after.Locked = true
+			err := tx.Update(f.fs.Collection(Root).Doc(thread.ID()), []firestore.Update{
+				{Path: "Locked", Value: true},
+			})
+			if err != nil {
+				return err
+			}
+			if err := f.txAudit(tx, newAuditEntry(moderator, AuditLock, thread, &after, "")); err != nil {
+				return err
+			}
+		case ResolveWarn:
+			err := tx.Create(f.fs.Collection(Warnings).NewDoc(), &Warning{
+				User:      post.Author,
+				Moderator: moderator,
+				Post:      postID,
+				Reason:    note,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		err = tx.Update(caseDoc, []firestore.Update{
+			{Path: "Open", Value: false},
+			{Path: "Resolution", Value: &Resolution{
+				Action:    action,
+				Moderator: moderator,
+				Note:      note,
+				Time:      time.Now(),
+			}},
+		})
+		if err != nil {
+			return err
+		}
+		return f.txAudit(tx, newAuditEntry(moderator, AuditResolve, post, post, string(action)+": "+note))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to resolve report on %s: %w", postID, err)
+	}
+	if deleted != nil {
+		f.unindexPost(postID)
+		f.publish(ctx, PostDeleted, deleted)
+	}
+	return nil
+}
+
+// GetWarnings returns the warnings a user has received. Only the user and moderators may
+// see them.
+func (f Forum) GetWarnings(ctx Context, userID string, viewer User) ([]*Warning, error) {
+	if viewer.ID != userID {
+		if err := f.requireModerator(ctx, viewer); err != nil {
+			return nil, fmt.Errorf("failed to get warnings: %w", err)
+		}
+	}
+	docs, err := f.fs.Collection(Warnings).Where("User.ID", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get warnings: %w", err)
+	}
+	result := make([]*Warning, len(docs))
+	for k, doc := range docs {
+		w := &Warning{}
+		if err := doc.DataTo(w); err != nil {
+			return nil, fmt.Errorf("failed to decode warning: %w", err)
+		}
+		result[k] = w
+	}
+	return result, nil
+}
+
+// LockThread stops (or, with locked false, resumes) replies to a thread. Only
+// moderators may lock threads.
+func (f Forum) LockThread(ctx Context, threadID PostID, moderator User, locked bool) error {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return fmt.Errorf("failed to lock thread: %w", err)
+	}
+	thread, err := f.getPost(ctx, threadID)
+	if err != nil {
+		return fmt.Errorf("failed to lock thread: %w", err)
+	}
+	after := *thread
+	after.Locked = locked
+	action := AuditLock
+	if !locked {
+		action = AuditUnlock
+	}
+	wb := f.fs.Batch()
+	wb.Update(f.fs.Collection(Root).Doc(threadID), []firestore.Update{
+		{Path: "Locked", Value: locked},
+	})
+	f.audit(wb, newAuditEntry(moderator, action, thread, &after, ""))
+	_, err = wb.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to lock thread %s: %w", threadID, err)
+	}
+	return nil
+}
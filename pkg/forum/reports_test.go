@@ -0,0 +1,84 @@
+package forum
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var moderator = User{ID: admin, Name: adminDisplay}
+
+func TestForum_ReportPost(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	root := AddRandomPost(t, f)
+	spam := AddRandomPost(t, f, root.Path...)
+	rude := AddRandomPost(t, f, root.Path...)
+
+	require.Nil(t, f.ReportPost(ctx, spam.ID(), mhc, "buy now", ReportSpam))
+	require.Nil(t, f.ReportPost(ctx, spam.ID(), ella, "ads", ReportSpam))
+	err = f.ReportPost(ctx, spam.ID(), ella, "more ads", ReportSpam)
+	assert.True(t, errors.Is(err, ErrAlreadyReported))
+	require.Nil(t, f.ReportPost(ctx, rude.ID(), mhc, "insults", ReportHarassment))
+
+	_, err = f.ModerationQueue(ctx, ella, 10)
+	assert.True(t, errors.Is(err, ErrNotPermitted))
+	queue, err := f.ModerationQueue(ctx, moderator, 10)
+	require.Nil(t, err)
+	require.Len(t, queue, 2)
+	assert.Equal(t, rude.ID(), queue[0].Post)
+	assert.Equal(t, spam.ID(), queue[1].Post)
+	assert.Equal(t, 2, queue[1].Count)
+
+	reports, err := f.GetReports(ctx, spam.ID(), moderator)
+	require.Nil(t, err)
+	assert.Len(t, reports, 2)
+}
+
+func TestForum_ResolveReport(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	root := AddRandomPost(t, f)
+	thread := AddRandomPost(t, f, root.Path...)
+	reply := AddRandomPost(t, f, thread.Path...)
+	require.Nil(t, f.ReportPost(ctx, reply.ID(), mhc, "flame war", ReportHarassment))
+	require.Nil(t, f.ReportPost(ctx, thread.ID(), mhc, "spam", ReportSpam))
+
+	require.Nil(t, f.ResolveReport(ctx, reply.ID(), moderator, ResolveLock, "cool off"))
+	_, err = f.CreateReply(ctx, reply.Path, "Re", "more flames", ella)
+	assert.True(t, errors.Is(err, ErrLocked))
+
+	require.Nil(t, f.ResolveReport(ctx, thread.ID(), moderator, ResolveWarn, "no spam"))
+	warnings, err := f.GetWarnings(ctx, thread.Author.ID, moderator)
+	require.Nil(t, err)
+	require.Len(t, warnings, 1)
+	assert.Equal(t, moderator.ID, warnings[0].Moderator.ID)
+	warnings, err = f.GetWarnings(ctx, thread.Author.ID, thread.Author)
+	require.Nil(t, err)
+	assert.Len(t, warnings, 1)
+	_, err = f.GetWarnings(ctx, thread.Author.ID, User{ID: "nosy"})
+	assert.True(t, errors.Is(err, ErrNotPermitted))
+
+	// A resolved case can't be resolved again, so the action isn't repeated.
+	err = f.ResolveReport(ctx, thread.ID(), moderator, ResolveWarn, "no spam")
+	assert.True(t, errors.Is(err, ErrNoOpenReport))
+	warnings, err = f.GetWarnings(ctx, thread.Author.ID, moderator)
+	require.Nil(t, err)
+	assert.Len(t, warnings, 1)
+	err = f.ResolveReport(ctx, root.ID(), moderator, ResolveDismiss, "never reported")
+	assert.True(t, errors.Is(err, ErrNoOpenReport))
+
+	queue, err := f.ModerationQueue(ctx, moderator, 10)
+	require.Nil(t, err)
+	assert.Empty(t, queue)
+
+	require.Nil(t, f.ReportPost(ctx, reply.ID(), ella, "still rude", ReportHarassment))
+	require.Nil(t, f.ResolveReport(ctx, reply.ID(), moderator, ResolveDelete, "rude"))
+	post, err := f.getPost(ctx, reply.ID())
+	require.Nil(t, err)
+	require.NotNil(t, post.Deleted)
+	assert.Equal(t, "rude", post.Deleted.Why)
+}
@@ -0,0 +1,330 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/uniq"
+	"time"
+)
+
+const Sanctions = "Sanctions"
+
+// SanctionKind says what a sanction stops a user from doing.
+type SanctionKind string
+
+const (
+	Ban    SanctionKind = "ban"    // No posting anywhere
+	Mute   SanctionKind = "mute"   // No posting in one section
+	Shadow SanctionKind = "shadow" // Posts are visible only to their author
+)
+
+// Sanction restricts what a user may do, until a time or permanently.
+type Sanction struct {
+	ID         string
+	User       User
+	Kind       SanctionKind
+	Section    PostID    // Section a mute applies to
+	Until      time.Time // Zero for a permanent sanction
+	Moderator  User
+	Reason     string
+	Lifted     bool
+	CreateTime time.Time `firestore:",serverTimestamp"`
+}
+
+// Active reports whether the sanction is in force at time now.
+func (s *Sanction) Active(now time.Time) bool {
+	return !s.Lifted && (s.Until.IsZero() || now.Before(s.Until))
+}
+
+// SanctionError is returned when a banned or muted user tries to post.
+type SanctionError struct {
+	Kind    SanctionKind
+	Section PostID
+	Until   time.Time // Zero for a permanent sanction
+	Reason  string
+}
+
+func (e *SanctionError) Error() string {
+	var what string
+	if e.Kind == Mute {
+		what = "muted in section " + e.Section
+	} else {
+		what = "banned"
+	}
+	if e.Until.IsZero() {
+		return fmt.Sprintf("user is %s: %s", what, e.Reason)
+	}
+	return fmt.Sprintf("user is %s until %s: %s", what, e.Until.Format(time.RFC3339), e.Reason)
+}
+
+// Ban stops a user from posting until a time, or permanently if until is zero.
+func (f Forum) Ban(ctx Context, user User, until time.Time, moderator User, reason string) (*Sanction, error) {
+	return f.sanction(ctx, &Sanction{User: user, Kind: Ban, Until: until, Moderator: moderator, Reason: reason})
+}
+
+// Mute stops a user from posting in one section until a time, or permanently if until
+// is zero.
+func (f Forum) Mute(ctx Context, user User, sectionID PostID, until time.Time, moderator User, reason string) (*Sanction, error) {
+	return f.sanction(ctx, &Sanction{User: user, Kind: Mute, Section: sectionID, Until: until, Moderator: moderator, Reason: reason})
+}
+
+// ShadowBan lets a user keep posting, but hides their new posts from everyone else.
+func (f Forum) ShadowBan(ctx Context, user User, until time.Time, moderator User, reason string) (*Sanction, error) {
+	return f.sanction(ctx, &Sanction{User: user, Kind: Shadow, Until: until, Moderator: moderator, Reason: reason})
+}
+
+func (f Forum) sanction(ctx Context, s *Sanction) (*Sanction, error) {
+	if err := f.requireModerator(ctx, s.Moderator); err != nil {
+		return nil, fmt.Errorf("failed to sanction %s: %w", s.User.ID, err)
+	}
+	s.ID = uniq.Uniq()
+	action := map[SanctionKind]AuditAction{Ban: AuditBan, Mute: AuditMute, Shadow: AuditShadow}[s.Kind]
+	wb := f.fs.Batch()
+	wb.Create(f.fs.Collection(Sanctions).Doc(s.ID), s)
+	f.audit(wb, newSanctionEntry(s.Moderator, action, s))
+	if _, err := wb.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to sanction %s: %w", s.User.ID, err)
+	}
+	return s, nil
+}
+
+// newSanctionEntry returns an audit entry for a sanction being imposed or lifted.
+func newSanctionEntry(actor User, action AuditAction, s *Sanction) *AuditEntry {
+	entry := &AuditEntry{ID: uniq.Uniq(), Actor: actor, Action: action, Subject: s.User, Reason: s.Reason}
+	if s.Section != "" {
+		entry.Target = []PostID{s.Section}
+	}
+	return entry
+}
+
+// LiftSanction ends a sanction early. Lifting a shadow ban makes the user's hidden
+// posts visible, unless another shadow ban is still in force. They then count toward
+// and bump their threads as though they had just been posted.
+func (f Forum) LiftSanction(ctx Context, sanctionID string, moderator User) error {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return fmt.Errorf("failed to lift sanction: %w", err)
+	}
+	s, err := f.getSanction(ctx, sanctionID)
+	if err != nil {
+		return fmt.Errorf("failed to lift sanction: %w", err)
+	}
+	if s.Lifted {
+		return nil
+	}
+	if err := f.endSanction(ctx, s, newSanctionEntry(moderator, AuditLift, s)); err != nil {
+		return fmt.Errorf("failed to lift sanction: %w", err)
+	}
+	return nil
+}
+
+// ExpireSanctions releases the posts hidden by shadow bans that ran out before now, and
+// marks those bans lifted. Other sanctions simply stop applying when they run out. It
+// returns how many shadow bans it ended.
+func (f Forum) ExpireSanctions(ctx Context, now time.Time) (int, error) {
+	docs, err := f.fs.
+		Collection(Sanctions).
+		Where("Kind", "==", Shadow).
+		Where("Lifted", "==", false).
+		Where("Until", ">", time.Time{}).
+		Where("Until", "<=", now).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire sanctions: %w", err)
+	}
+	for k, doc := range docs {
+		s := &Sanction{}
+		if err := doc.DataTo(s); err != nil {
+			return k, fmt.Errorf("failed to decode sanction: %w", err)
+		}
+		if err := f.endSanction(ctx, s, nil); err != nil {
+			return k, fmt.Errorf("failed to expire sanction %s: %w", s.ID, err)
+		}
+	}
+	return len(docs), nil
+}
+
+// RunSanctionExpiry calls ExpireSanctions every interval until ctx is done, passing any
+// errors to report.
+func (f Forum) RunSanctionExpiry(ctx Context, interval time.Duration, report func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := f.ExpireSanctions(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			report(err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (f Forum) getSanction(ctx Context, sanctionID string) (*Sanction, error) {
+	snap, err := f.fs.Collection(Sanctions).Doc(sanctionID).Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sanction %s: %w", sanctionID, err)
+	}
+	s := &Sanction{}
+	if err := snap.DataTo(s); err != nil {
+		return nil, fmt.Errorf("failed to decode sanction: %w", err)
+	}
+	return s, nil
+}
+
+// endSanction marks a sanction lifted, recording entry in the audit log if it isn't nil.
+// Ending a shadow ban first releases the posts it hid.
+func (f Forum) endSanction(ctx Context, s *Sanction, entry *AuditEntry) error {
+	var released []*Post
+	if s.Kind == Shadow {
+		active, err := f.GetSanctions(ctx, s.User.ID)
+		if err != nil {
+			return err
+		}
+		shadowed := false
+		for _, other := range active {
+			shadowed = shadowed || other.Kind == Shadow && other.ID != s.ID
+		}
+		if !shadowed {
+			released, err = f.releaseShadowed(ctx, s.User.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	wb := f.fs.Batch()
+	wb.Update(f.fs.Collection(Sanctions).Doc(s.ID), []firestore.Update{{Path: "Lifted", Value: true}})
+	if entry != nil {
+		f.audit(wb, entry)
+	}
+	if _, err := wb.Commit(ctx); err != nil {
+		return err
+	}
+	for _, post := range released {
+		f.indexPost(post)
+		f.publish(ctx, PostAdded, post)
+	}
+	return nil
+}
+
+// releaseBatch is how many posts releaseShadowed updates at a time. Each also updates
+// its ancestors and queues webhooks, within Firestore's 500 writes per batch.
+const releaseBatch = 25
+
+// releaseShadowed makes every post a user made under a shadow ban visible, counting each
+// toward its ancestors in the same batch that un-hides it. A failure part way leaves the
+// rest hidden, to be released by trying again.
+func (f Forum) releaseShadowed(ctx Context, userID string) ([]*Post, error) {
+	docs, err := f.fs.
+		Collection(Root).
+		Where("Author.ID", "==", userID).
+		Where("Shadow", "==", true).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, err
+	}
+	hooks, err := f.getWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var released []*Post
+	for start := 0; start < len(docs); start += releaseBatch {
+		end := start + releaseBatch
+		if end > len(docs) {
+			end = len(docs)
+		}
+		wb := f.fs.Batch()
+		var batch []*Post
+		for _, doc := range docs[start:end] {
+			post := &Post{}
+			if err := doc.DataTo(post); err != nil {
+				return nil, fmt.Errorf("failed to decode post: %w", err)
+			}
+			post.Shadow = false
+			// The precondition stops a concurrent release from counting the post twice.
+			wb.Update(doc.Ref, []firestore.Update{{Path: "Shadow", Value: false}}, firestore.LastUpdateTime(doc.UpdateTime))
+			if !post.hidden() {
+				f.countPost(wb, post)
+				if err := f.queueWebhooks(wb, hooks, PostAdded, post); err != nil {
+					return nil, err
+				}
+				batch = append(batch, post)
+			}
+		}
+		if _, err := wb.Commit(ctx); err != nil {
+			return nil, err
+		}
+		released = append(released, batch...)
+	}
+	return released, nil
+}
+
+// GetSanctions returns the sanctions in force against a user.
+func (f Forum) GetSanctions(ctx Context, userID string) ([]*Sanction, error) {
+	docs, err := f.fs.
+		Collection(Sanctions).
+		Where("User.ID", "==", userID).
+		Where("Lifted", "==", false).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sanctions: %w", err)
+	}
+	now := time.Now()
+	var result []*Sanction
+	for _, doc := range docs {
+		s := &Sanction{}
+		if err := doc.DataTo(s); err != nil {
+			return nil, fmt.Errorf("failed to decode sanction: %w", err)
+		}
+		if s.Active(now) {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+// checkSanctions returns a *SanctionError if user may not post in a section, given by
+// its path so that a mute also covers sub-sections. Otherwise it reports whether the new
+// post should be hidden by a shadow ban.
+func (f Forum) checkSanctions(ctx Context, user User, sections []PostID) (bool, error) {
+	sanctions, err := f.GetSanctions(ctx, user.ID)
+	if err != nil {
+		return false, err
+	}
+	shadow := false
+	for _, s := range sanctions {
+		switch {
+		case s.Kind == Ban, s.Kind == Mute && containsID(sections, s.Section):
+			return false, &SanctionError{Kind: s.Kind, Section: s.Section, Until: s.Until, Reason: s.Reason}
+		case s.Kind == Shadow:
+			shadow = true
+		}
+	}
+	return shadow, nil
+}
+
+// isShadowBanned reports whether a user's new posts are currently hidden.
+func (f Forum) isShadowBanned(ctx Context, userID string) (bool, error) {
+	sanctions, err := f.GetSanctions(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range sanctions {
+		if s.Kind == Shadow {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func containsID(ids []PostID, id PostID) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
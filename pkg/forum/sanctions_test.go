@@ -0,0 +1,129 @@
+package forum
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestForum_Ban(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	other, err := f.CreateSection(ctx, "Other", "More chat", 200, mhc, SectionOptions{})
+	require.Nil(t, err)
+
+	_, err = f.Ban(ctx, ella, time.Now().Add(time.Hour), ella, "self-ban")
+	assert.True(t, errors.Is(err, ErrNotPermitted))
+
+	mute, err := f.Mute(ctx, ella, gen[0], time.Time{}, moderator, "off topic")
+	require.Nil(t, err)
+	_, err = f.CreateThread(ctx, "Hi", "Hello", ella, gen[0], ThreadOptions{})
+	var sanctioned *SanctionError
+	require.True(t, errors.As(err, &sanctioned))
+	assert.Equal(t, Mute, sanctioned.Kind)
+	_, err = f.CreateThread(ctx, "Hi", "Hello", ella, other[0], ThreadOptions{})
+	require.Nil(t, err)
+	require.Nil(t, f.LiftSanction(ctx, mute.ID, moderator))
+
+	_, err = f.Ban(ctx, ella, time.Now().Add(-time.Minute), moderator, "expired")
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Hi", "Hello", ella, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+
+	_, err = f.Ban(ctx, ella, time.Now().Add(time.Hour), moderator, "cool off")
+	require.Nil(t, err)
+	_, err = f.CreateReply(ctx, thread, "Re", "Hello again", ella)
+	require.True(t, errors.As(err, &sanctioned))
+	assert.Equal(t, Ban, sanctioned.Kind)
+	assert.Equal(t, "cool off", sanctioned.Reason)
+
+	sanctions, err := f.GetSanctions(ctx, ella.ID)
+	require.Nil(t, err)
+	assert.Len(t, sanctions, 1)
+}
+
+func TestForum_ShadowBan(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Hi", "Hello", mhc, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+
+	shadow, err := f.ShadowBan(ctx, ella, time.Time{}, moderator, "trolling")
+	require.Nil(t, err)
+	_, err = f.CreateThread(ctx, "Troll", "Bait", ella, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+	_, err = f.CreateReply(ctx, thread, "Re", "Bait", ella)
+	require.Nil(t, err)
+
+	threads, _, err := f.GetThreads(ctx, gen[0], nil, 10, View{Viewer: &mhc})
+	require.Nil(t, err)
+	assert.Len(t, threads, 1)
+	replies, _, err := f.GetReplies(ctx, thread[1], nil, 10, View{})
+	require.Nil(t, err)
+	assert.Len(t, replies, 1)
+	section, err := f.getPost(ctx, gen[0])
+	require.Nil(t, err)
+	assert.Equal(t, 1, section.DescendentCount)
+
+	threads, _, err = f.GetThreads(ctx, gen[0], nil, 10, View{Viewer: &ella})
+	require.Nil(t, err)
+	assert.Len(t, threads, 2)
+	replies, _, err = f.GetReplies(ctx, thread[1], nil, 10, View{Viewer: &ella})
+	require.Nil(t, err)
+	assert.Len(t, replies, 2)
+
+	require.Nil(t, f.LiftSanction(ctx, shadow.ID, moderator))
+	threads, _, err = f.GetThreads(ctx, gen[0], nil, 10, View{})
+	require.Nil(t, err)
+	assert.Len(t, threads, 2)
+	section, err = f.getPost(ctx, gen[0])
+	require.Nil(t, err)
+	assert.Equal(t, 3, section.DescendentCount)
+	assert.Equal(t, 2, section.ChildCount)
+	parent, err := f.getPost(ctx, thread[1])
+	require.Nil(t, err)
+	assert.Equal(t, 1, parent.ChildCount)
+
+	entries, err := f.GetAuditLog(ctx, AuditQuery{Subject: ella.ID}, moderator, 10)
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, AuditLift, entries[0].Action)
+	assert.Equal(t, AuditShadow, entries[1].Action)
+}
+
+func TestForum_ShadowBanExpires(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	_, err = f.ShadowBan(ctx, ella, time.Now().Add(time.Hour), moderator, "cooling off")
+	require.Nil(t, err)
+	_, err = f.CreateThread(ctx, "Troll", "Bait", ella, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+
+	n, err := f.ExpireSanctions(ctx, time.Now())
+	require.Nil(t, err)
+	assert.Zero(t, n)
+	threads, _, err := f.GetThreads(ctx, gen[0], nil, 10, View{})
+	require.Nil(t, err)
+	assert.Empty(t, threads)
+
+	n, err = f.ExpireSanctions(ctx, time.Now().Add(2*time.Hour))
+	require.Nil(t, err)
+	assert.Equal(t, 1, n)
+	threads, _, err = f.GetThreads(ctx, gen[0], nil, 10, View{})
+	require.Nil(t, err)
+	assert.Len(t, threads, 1)
+	section, err := f.getPost(ctx, gen[0])
+	require.Nil(t, err)
+	assert.Equal(t, 1, section.ChildCount)
+}
@@ -0,0 +1,149 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/search"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sort"
+	"time"
+)
+
+// SearchAfter is the cursor for search results. It resumes after the score and ID of
+// the hit it was made from, so it is only meaningful for the query that produced it, but
+// still works if that post has since left the index.
+type SearchAfter struct {
+	score float64
+	id    PostID
+}
+
+func (s *SearchAfter) value() interface{} {
+	return s.id
+}
+
+func (s *SearchAfter) field() string {
+	return ""
+}
+
+func (s *SearchAfter) direction() firestore.Direction {
+	return firestore.Asc
+}
+
+func (s *SearchAfter) Next(post *Post) Cursor {
+	return &SearchAfter{score: s.score, id: post.ID()}
+}
+
+// SetSearchIndex makes the forum keep index up to date as posts are created, edited and
+// deleted. The index only sees changes made through this client; use RebuildSearchIndex
+// to catch up with everything else.
+func (f *Forum) SetSearchIndex(index *search.Index) {
+	f.index = index
+}
+
+func document(post *Post) search.Document {
+	tm := post.CreateTime
+	if tm.IsZero() {
+		tm = time.Now()
+	}
+	return search.Document{
+		ID:       post.ID(),
+		Sections: append([]PostID{}, post.sectionPath()...),
+		Author:   post.Author.ID,
+		Time:     tm,
+		Head:     post.Head,
+		Body:     post.Body,
+	}
+}
+
+// indexPost adds or replaces a post in the search index, if there is one.
+func (f Forum) indexPost(post *Post) {
+	if f.index == nil {
+		return
+	}
+	if post.Deleted != nil || post.hidden() {
+		f.index.Remove(post.ID())
+		return
+	}
+	f.index.Add(document(post))
+}
+
+func (f Forum) unindexPost(postID PostID) {
+	if f.index != nil {
+		f.index.Remove(postID)
+	}
+}
+
+// Search returns posts matching q that view may see, best match first. Hits for posts
+// that have been expunged but not yet removed from the index are skipped.
+func (f Forum) Search(ctx Context, q search.Query, cursor Cursor, n int, view View) ([]*Post, Cursor, error) {
+	if f.index == nil {
+		return nil, nil, fmt.Errorf("search failed: no search index")
+	}
+	r, err := f.newReader(ctx, view)
+	if err != nil {
+		return nil, nil, fmt.Errorf("search failed: %w", err)
+	}
+	hits, err := f.index.Search(q)
+	if err != nil {
+		return nil, nil, fmt.Errorf("search failed: %w", err)
+	}
+	start := 0
+	switch after := cursor.(type) {
+	case nil:
+	case *SearchAfter:
+		// Hits are ordered by descending score, then ID.
+		start = sort.Search(len(hits), func(k int) bool {
+			h := hits[k]
+			return h.Score < after.score || h.Score == after.score && h.ID > after.id
+		})
+	default:
+		return nil, nil, fmt.Errorf("search failed: %w", ErrBadCursor)
+	}
+	result := make([]*Post, 0, n)
+	var last search.Hit
+	for _, hit := range hits[start:] {
+		if len(result) == n {
+			return result, &SearchAfter{score: last.Score, id: last.ID}, nil
+		}
+		doc, err := f.fs.Collection(Root).Doc(hit.ID).Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("search failed: %w", err)
+		}
+		post := &Post{}
+		if err := doc.DataTo(post); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode post %s: %w", hit.ID, err)
+		}
+		if post.Deleted != nil || !r.canSee(post) {
+			continue
+		}
+		result = append(result, post)
+		last = hit
+	}
+	return result, nil, nil
+}
+
+// RebuildSearchIndex returns a new index of every post that has not been deleted or
+// hidden.
+func (f Forum) RebuildSearchIndex(ctx Context) (*search.Index, error) {
+	index := search.NewIndex()
+	iter := f.fs.Collection(Root).Where("Deleted", "==", nil).Documents(ctx)
+	defer iter.Stop()
+	docs, err := iter.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to rebuild search index: %w", err)
+	}
+	for _, doc := range docs {
+		post := &Post{}
+		if err := doc.DataTo(post); err != nil {
+			return nil, fmt.Errorf("failed to decode post %s: %w", doc.Ref.ID, err)
+		}
+		if !post.hidden() {
+			index.Add(document(post))
+		}
+	}
+	return index, nil
+}
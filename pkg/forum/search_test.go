@@ -0,0 +1,57 @@
+package forum
+
+import (
+	"errors"
+	"github.com/mhcoffin/forum-tools/pkg/search"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestForum_Search(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	f.SetSearchIndex(search.NewIndex())
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	t1, err := f.CreateThread(ctx, "Legato strings", "<p>How do I get smooth legato?</p>", mhc, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+	t2, err := f.CreateThread(ctx, "Brass", "Some legato brass", ella, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+	t3, err := f.CreateThread(ctx, "Percussion", "Also legato, sort of", ella, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+
+	posts, cursor, err := f.Search(ctx, search.Query{Text: "legato"}, nil, 2, View{})
+	require.Nil(t, err)
+	require.Len(t, posts, 2)
+	assert.Equal(t, t1[1], posts[0].ID())
+	require.NotNil(t, cursor)
+	// The page resumes after the last hit even once that post has left the index.
+	f.index.Remove(posts[1].ID())
+	more, cursor, err := f.Search(ctx, search.Query{Text: "legato"}, cursor, 2, View{})
+	require.Nil(t, err)
+	require.Len(t, more, 1)
+	assert.NotEqual(t, posts[0].ID(), more[0].ID())
+	assert.Nil(t, cursor)
+	f.indexPost(posts[1])
+	_, _, err = f.Search(ctx, search.Query{Text: "legato"}, &CreateTimeAsc{}, 2, View{})
+	assert.True(t, errors.Is(err, ErrBadCursor))
+
+	// A hit for a post that no longer exists is skipped.
+	f.index.Add(search.Document{ID: "expunged", Head: "Legato", Body: "legato legato"})
+	posts, _, err = f.Search(ctx, search.Query{Text: "legato"}, nil, 10, View{})
+	require.Nil(t, err)
+	assert.Len(t, posts, 3)
+	f.index.Remove("expunged")
+
+	require.Nil(t, f.UpdateThread(ctx, t2[1], "Brass", "Staccato brass", ella, nil))
+	require.Nil(t, f.DeleteThread(ctx, t3[1], mhc, "spam"))
+	posts, _, err = f.Search(ctx, search.Query{Text: "legato"}, nil, 10, View{})
+	require.Nil(t, err)
+	assert.Len(t, posts, 1)
+
+	index, err := f.RebuildSearchIndex(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 3, index.Len())
+}
@@ -0,0 +1,198 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/uniq"
+	"time"
+)
+
+// SectionNode is a section together with its sub-sections.
+type SectionNode struct {
+	*Post
+	Subsections []*SectionNode
+}
+
+// GetSections returns the section hierarchy, each level ordered by Index. Hidden
+// sections, and everything under them, are included only for moderators. Invite-only
+// sections are included only for their members.
+func (f Forum) GetSections(ctx Context, view View) ([]*SectionNode, error) {
+	a, err := f.getAccess(ctx, view.Viewer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve sections: %w", err)
+	}
+	nodes, err := f.getSubsections(ctx, "", 1, a)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve sections: %w", err)
+	}
+	return nodes, nil
+}
+
+// getSubsections returns the sections under parent, which are depth sections deep.
+func (f Forum) getSubsections(ctx Context, parent PostID, depth int, a *access) ([]*SectionNode, error) {
+	query := f.fs.
+		Collection(Root).
+		Where("Parent", "==", parent).
+		Where("Deleted", "==", nil)
+	// Top-level sections may predate the Sections field, but anything under a section
+	// with the right depth is a sub-section rather than a thread.
+	if depth > 1 {
+		query = query.Where("Sections", "==", depth)
+	}
+	docs, err := query.OrderBy("Index", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	var result []*SectionNode
+	for _, doc := range docs {
+		post := &Post{}
+		if err := doc.DataTo(post); err != nil {
+			return nil, fmt.Errorf("failed to decode section: %w", err)
+		}
+		if post.Hidden && !a.all || !a.canList(post) {
+			continue
+		}
+		node := &SectionNode{Post: post}
+		node.Subsections, err = f.getSubsections(ctx, post.ID(), depth+1, a)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, node)
+	}
+	return result, nil
+}
+
+// getSection returns a section, or an error if sectionID is not a section.
+func (f Forum) getSection(ctx Context, sectionID PostID) (*Post, error) {
+	section, err := f.getPost(ctx, sectionID)
+	if err != nil {
+		return nil, err
+	}
+	if !section.isSection() {
+		return nil, fmt.Errorf("%w: %s is not a section", ErrInvalid, sectionID)
+	}
+	return section, nil
+}
+
+// CreateSubsection creates a section inside another section. Only moderators may create
+// sub-sections.
+func (f Forum) CreateSubsection(ctx Context, parentID PostID, subject string, description string, index int, author User, opts SectionOptions) ([]PostID, error) {
+	if err := f.requireModerator(ctx, author); err != nil {
+		return nil, fmt.Errorf("failed to create sub-section: %w", err)
+	}
+	parent, err := f.getSection(ctx, parentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sub-section: %w", err)
+	}
+	post := &Post{
+		Path:        append(append([]PostID{}, parent.Path...), uniq.Uniq()),
+		Index:       index,
+		Head:        subject,
+		Body:        description,
+		Author:      author,
+		Bump:        &Bump{Time: time.Time{}},
+		QA:          opts.QA,
+		CuratedTags: opts.CuratedTags,
+		Moderated:   opts.Moderated,
+		Visibility:  opts.Visibility,
+		Sections:    len(parent.Path) + 1,
+	}
+	path, err := f.addPost(ctx, post)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sub-section: %w", err)
+	}
+	return path, nil
+}
+
+// UpdateSection replaces the title, description and index of a section. Only
+// moderators may update sections.
+func (f Forum) UpdateSection(ctx Context, sectionID PostID, title string, description string, index int, moderator User) error {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return fmt.Errorf("failed to update section: %w", err)
+	}
+	section, err := f.getSection(ctx, sectionID)
+	if err != nil {
+		return fmt.Errorf("failed to update section: %w", err)
+	}
+	after := *section
+	after.Head = title
+	after.Body = description
+	after.Index = index
+	wb := f.fs.Batch()
+	wb.Update(f.fs.Collection(Root).Doc(sectionID), []firestore.Update{
+		{Path: "Head", Value: title},
+		{Path: "Body", Value: description},
+		{Path: "Index", Value: index},
+		{Path: "EditTime", Value: firestore.ServerTimestamp},
+	})
+	f.audit(wb, newAuditEntry(moderator, AuditEdit, section, &after, ""))
+	if _, err := wb.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to update section %s: %w", sectionID, err)
+	}
+	return nil
+}
+
+// ReorderSections renumbers sections so that they are listed in the given order. The
+// sections must be siblings: top-level sections, or sub-sections of the same section.
+// Only moderators may reorder sections.
+func (f Forum) ReorderSections(ctx Context, order []PostID, moderator User) error {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return fmt.Errorf("failed to reorder sections: %w", err)
+	}
+	sections := make([]*Post, len(order))
+	seen := make(map[PostID]bool)
+	for k, id := range order {
+		if seen[id] {
+			return fmt.Errorf("failed to reorder sections: %w: %s appears twice", ErrInvalid, id)
+		}
+		seen[id] = true
+		section, err := f.getSection(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to reorder sections: %w", err)
+		}
+		if k > 0 && section.Parent != sections[0].Parent {
+			return fmt.Errorf("failed to reorder sections: %w: %s and %s are not siblings", ErrInvalid, order[0], id)
+		}
+		sections[k] = section
+	}
+	wb := f.fs.Batch()
+	for k, section := range sections {
+		after := *section
+		after.Index = (k + 1) * 100
+		wb.Update(f.fs.Collection(Root).Doc(section.ID()), []firestore.Update{
+			{Path: "Index", Value: after.Index},
+		})
+		f.audit(wb, newAuditEntry(moderator, AuditReorder, section, &after, ""))
+	}
+	if _, err := wb.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to reorder sections: %w", err)
+	}
+	return nil
+}
+
+// HideSection hides (or, with hidden false, shows) a section in the section list for
+// everyone but moderators.
+func (f Forum) HideSection(ctx Context, sectionID PostID, hidden bool, moderator User) error {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return fmt.Errorf("failed to hide section: %w", err)
+	}
+	section, err := f.getSection(ctx, sectionID)
+	if err != nil {
+		return fmt.Errorf("failed to hide section: %w", err)
+	}
+	after := *section
+	after.Hidden = hidden
+	action := AuditHide
+	if !hidden {
+		action = AuditShow
+	}
+	wb := f.fs.Batch()
+	wb.Update(f.fs.Collection(Root).Doc(sectionID), []firestore.Update{
+		{Path: "Hidden", Value: hidden},
+	})
+	f.audit(wb, newAuditEntry(moderator, action, section, &after, ""))
+	if _, err := wb.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to hide section %s: %w", sectionID, err)
+	}
+	return nil
+}
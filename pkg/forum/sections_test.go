@@ -0,0 +1,99 @@
+package forum
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestForum_Subsections(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	libs, err := f.CreateSection(ctx, "Libraries", "Sample libraries", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	_, err = f.CreateSubsection(ctx, libs[0], "Strings", "String libraries", 200, ella, SectionOptions{})
+	assert.True(t, errors.Is(err, ErrNotPermitted))
+	strings, err := f.CreateSubsection(ctx, libs[0], "Strings", "String libraries", 200, moderator, SectionOptions{})
+	require.Nil(t, err)
+	brass, err := f.CreateSubsection(ctx, libs[0], "Brass", "Brass libraries", 100, moderator, SectionOptions{})
+	require.Nil(t, err)
+	staff, err := f.CreateSection(ctx, "Staff", "Staff only", 50, mhc, SectionOptions{})
+	require.Nil(t, err)
+	require.Nil(t, f.HideSection(ctx, staff[0], true, moderator))
+
+	thread, err := f.CreateThread(ctx, "Legato", "Which violins?", ella, strings[1], ThreadOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, []PostID{libs[0], strings[1], thread[2]}, thread)
+	reply, err := f.CreateReply(ctx, thread, "Legato", "These ones", mhc)
+	require.Nil(t, err)
+	post, err := f.getPost(ctx, reply[3])
+	require.Nil(t, err)
+	assert.Equal(t, thread[2], post.threadID())
+
+	sections, err := f.GetSections(ctx, View{})
+	require.Nil(t, err)
+	require.Len(t, sections, 1)
+	require.Len(t, sections[0].Subsections, 2)
+	assert.Equal(t, "Brass", sections[0].Subsections[0].Head)
+	assert.Equal(t, "Strings", sections[0].Subsections[1].Head)
+	assert.Equal(t, 2, sections[0].Subsections[1].DescendentCount)
+	sections, err = f.GetSections(ctx, View{Viewer: &moderator})
+	require.Nil(t, err)
+	require.Len(t, sections, 2)
+	assert.Equal(t, "Staff", sections[0].Head)
+
+	threads, _, err := f.GetThreads(ctx, libs[0], nil, 10, View{})
+	require.Nil(t, err)
+	assert.Empty(t, threads)
+	_, err = f.CreateThread(ctx, "Oops", "Not a section", ella, thread[2], ThreadOptions{})
+	assert.NotNil(t, err)
+
+	require.Nil(t, f.MoveThread(ctx, thread[2], brass[1], moderator, "brass question"))
+	moved, err := f.getPost(ctx, reply[3])
+	require.Nil(t, err)
+	assert.Equal(t, []PostID{libs[0], brass[1], thread[2], reply[3]}, moved.Path)
+	lib, err := f.getPost(ctx, libs[0])
+	require.Nil(t, err)
+	assert.Equal(t, 4, lib.DescendentCount)
+	sub, err := f.getPost(ctx, brass[1])
+	require.Nil(t, err)
+	assert.Equal(t, 1, sub.ChildCount)
+	assert.Equal(t, 2, sub.DescendentCount)
+}
+
+func TestForum_UpdateSection(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	a, err := f.CreateSection(ctx, "A", "First", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	b, err := f.CreateSection(ctx, "B", "Second", 200, mhc, SectionOptions{})
+	require.Nil(t, err)
+
+	err = f.UpdateSection(ctx, a[0], "Alpha", "First!", 100, ella)
+	assert.True(t, errors.Is(err, ErrNotPermitted))
+	require.Nil(t, f.UpdateSection(ctx, a[0], "Alpha", "First!", 100, moderator))
+	require.Nil(t, f.ReorderSections(ctx, []PostID{b[0], a[0]}, moderator))
+	entries, err := f.GetAuditLog(ctx, AuditQuery{Target: b[0]}, moderator, 10)
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, AuditReorder, entries[0].Action)
+
+	// Only sibling sections can be reordered together.
+	sub, err := f.CreateSubsection(ctx, a[0], "Sub", "Inside A", 100, moderator, SectionOptions{})
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Hi", "Not a section", mhc, a[0], ThreadOptions{})
+	require.Nil(t, err)
+	assert.NotNil(t, f.ReorderSections(ctx, []PostID{b[0], sub[1]}, moderator))
+	assert.NotNil(t, f.ReorderSections(ctx, []PostID{b[0], thread[1]}, moderator))
+	assert.NotNil(t, f.ReorderSections(ctx, []PostID{b[0], b[0]}, moderator))
+
+	sections, err := f.GetSections(ctx, View{})
+	require.Nil(t, err)
+	require.Len(t, sections, 2)
+	assert.Equal(t, "B", sections[0].Head)
+	assert.Equal(t, "Alpha", sections[1].Head)
+	assert.Equal(t, "First!", sections[1].Body)
+}
@@ -0,0 +1,286 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/uniq"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"unicode"
+)
+
+const (
+	Tags         = "Tags"
+	MaxTags      = 5
+	MaxTagLength = 30
+)
+
+// Tag records how many threads use a tag.
+type Tag struct {
+	Name    string
+	Count   int  // Number of threads with this tag
+	Curated bool // Tag may be used in sections that only allow curated tags
+}
+
+// NormalizeTag lower-cases a tag and replaces runs of spaces with a single dash. Tags
+// may contain only letters, digits, dashes, underscores and dots.
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+	if tag == "" {
+		return "", fmt.Errorf("%w: empty tag", ErrInvalid)
+	}
+	if len(tag) > MaxTagLength {
+		return "", fmt.Errorf("%w: tag %q longer than %d characters", ErrInvalid, tag, MaxTagLength)
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_.", r) {
+			return "", fmt.Errorf("%w: character %q in tag %q", ErrInvalid, r, tag)
+		}
+	}
+	return tag, nil
+}
+
+// NormalizeTags normalizes each tag and removes duplicates.
+func NormalizeTags(tags []string) ([]string, error) {
+	result := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		t, err := NormalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[t] {
+			seen[t] = true
+			result = append(result, t)
+		}
+	}
+	if len(result) > MaxTags {
+		return nil, fmt.Errorf("%w: at most %d tags allowed", ErrInvalid, MaxTags)
+	}
+	return result, nil
+}
+
+// tagDeltas returns the change in usage count for each tag when a thread's tags change
+// from old to new.
+func tagDeltas(old []string, new []string) map[string]int {
+	deltas := make(map[string]int)
+	for _, t := range old {
+		deltas[t]--
+	}
+	for _, t := range new {
+		deltas[t]++
+	}
+	for t, d := range deltas {
+		if d == 0 {
+			delete(deltas, t)
+		}
+	}
+	return deltas
+}
+
+func tagCount(tag string, delta int) map[string]interface{} {
+	return map[string]interface{}{
+		"Name":  tag,
+		"Count": firestore.Increment(delta),
+	}
+}
+
+// checkTags verifies that tags may be used in section.
+func (f Forum) checkTags(ctx Context, section *Post, tags []string) error {
+	if !section.CuratedTags {
+		return nil
+	}
+	for _, t := range tags {
+		tag, err := f.getTag(ctx, t)
+		if err != nil {
+			return err
+		}
+		if tag == nil || !tag.Curated {
+			return fmt.Errorf("%w: tag %q is not allowed in this section", ErrInvalid, t)
+		}
+	}
+	return nil
+}
+
+// getTag returns the tag with the given name, or nil if there is no such tag.
+func (f Forum) getTag(ctx Context, name string) (*Tag, error) {
+	doc, err := f.fs.Collection(Tags).Doc(name).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tag %s: %w", name, err)
+	}
+	tag := &Tag{}
+	if err := doc.DataTo(tag); err != nil {
+		return nil, fmt.Errorf("failed to decode tag %s: %w", name, err)
+	}
+	return tag, nil
+}
+
+// GetThreadsByTag retrieves threads with a tag from every section, most-recently-bumped
+// thread first.
+func (f Forum) GetThreadsByTag(ctx Context, tag string, cursor Cursor, n int, view View) ([]*Post, Cursor, error) {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve threads: %w", err)
+	}
+	if cursor == nil {
+		cursor = &BumpTimeDesc{}
+	}
+	query := f.fs.
+		Collection(Root).
+		Where("Tags", "array-contains", tag).
+		Where("Deleted", "==", nil)
+	query, keep, err := f.applyView(ctx, query, view)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve threads: %w", err)
+	}
+	posts, cursor, err := f.paginateView(ctx, query, cursor, n, keep)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve threads: %w", err)
+	}
+	return posts, cursor, nil
+}
+
+// GetTags returns up to n tags, most used first.
+func (f Forum) GetTags(ctx Context, n int) ([]*Tag, error) {
+	docs, err := f.fs.
+		Collection(Tags).
+		OrderBy("Count", firestore.Desc).
+		Limit(n).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve tags: %w", err)
+	}
+	result := make([]*Tag, len(docs))
+	for k, doc := range docs {
+		tag := &Tag{}
+		if err := doc.DataTo(tag); err != nil {
+			return nil, fmt.Errorf("failed to decode tag: %w", err)
+		}
+		result[k] = tag
+	}
+	return result, nil
+}
+
+// CurateTag marks a tag as usable (or not) in sections that only allow curated tags.
+// Only moderators may curate tags.
+func (f Forum) CurateTag(ctx Context, tag string, curated bool, user User) error {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return fmt.Errorf("failed to curate tag: %w", err)
+	}
+	if err := f.requireModerator(ctx, user); err != nil {
+		return fmt.Errorf("failed to curate tag: %w", err)
+	}
+	_, err = f.fs.Collection(Tags).Doc(tag).Set(ctx, map[string]interface{}{
+		"Name":    tag,
+		"Curated": curated,
+	}, firestore.MergeAll)
+	if err != nil {
+		return fmt.Errorf("failed to curate tag: %w", err)
+	}
+	return nil
+}
+
+// renameBatch is how many threads RenameTag retags in each transaction, well inside
+// Firestore's limit of 500 writes.
+const renameBatch = 400
+
+// RenameTag replaces tag from with tag to on every thread. If to is already in use the
+// two tags are merged. Threads are retagged in batches, each in its own transaction, so
+// a rename that fails part way can simply be run again. Only moderators may rename tags.
+func (f Forum) RenameTag(ctx Context, from string, to string, user User) error {
+	from, err := NormalizeTag(from)
+	if err != nil {
+		return fmt.Errorf("failed to rename tag: %w", err)
+	}
+	to, err = NormalizeTag(to)
+	if err != nil {
+		return fmt.Errorf("failed to rename tag: %w", err)
+	}
+	if from == to {
+		return nil
+	}
+	if err := f.requireModerator(ctx, user); err != nil {
+		return fmt.Errorf("failed to rename tag: %w", err)
+	}
+	old, err := f.getTag(ctx, from)
+	if err != nil {
+		return fmt.Errorf("failed to rename tag: %w", err)
+	}
+	if old == nil {
+		return fmt.Errorf("failed to rename tag %q: %w", from, ErrNotFound)
+	}
+	for {
+		renamed, err := f.retagBatch(ctx, from, to)
+		if err != nil {
+			return fmt.Errorf("failed to rename tag: %w", err)
+		}
+		for _, post := range renamed {
+			f.indexPost(post)
+		}
+		if len(renamed) < renameBatch {
+			break
+		}
+	}
+	merge := tagCount(to, 0)
+	if old.Curated {
+		merge["Curated"] = true
+	}
+	wb := f.fs.Batch()
+	wb.Set(f.fs.Collection(Tags).Doc(to), merge, firestore.MergeAll)
+	wb.Delete(f.fs.Collection(Tags).Doc(from))
+	f.audit(wb, &AuditEntry{ID: uniq.Uniq(), Actor: user, Action: AuditRenameTag, Tag: from, NewTag: to})
+	if _, err := wb.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to rename tag: %w", err)
+	}
+	return nil
+}
+
+// retagBatch replaces tag from with tag to on up to renameBatch threads in one
+// transaction, and returns the threads as they now are.
+func (f Forum) retagBatch(ctx Context, from string, to string) ([]*Post, error) {
+	query := f.fs.Collection(Root).Where("Tags", "array-contains", from).Limit(renameBatch)
+	var renamed []*Post
+	err := f.fs.RunTransaction(ctx, func(ctx Context, tx *firestore.Transaction) error {
+		renamed = nil
+		docs, err := tx.Documents(query).GetAll()
+		if err != nil {
+			return err
+		}
+		added := 0
+		for _, doc := range docs {
+			post := &Post{}
+			if err := doc.DataTo(post); err != nil {
+				return fmt.Errorf("failed to decode post: %w", err)
+			}
+			tags := make([]string, 0, len(post.Tags))
+			for _, t := range post.Tags {
+				if t != from && t != to {
+					tags = append(tags, t)
+				}
+			}
+			// Deleted threads were already taken off their tags' counts.
+			if len(tags) == len(post.Tags)-1 && post.Deleted == nil {
+				added++
+			}
+			post.Tags = append(tags, to)
+			if err := tx.Update(doc.Ref, []firestore.Update{{Path: "Tags", Value: post.Tags}}); err != nil {
+				return err
+			}
+			renamed = append(renamed, post)
+		}
+		if added == 0 {
+			return nil
+		}
+		return tx.Set(f.fs.Collection(Tags).Doc(to), tagCount(to, added), firestore.MergeAll)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return renamed, nil
+}
@@ -0,0 +1,109 @@
+package forum
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := NormalizeTags([]string{"  Film Scoring ", "film-scoring", "VSL", "v1.2"})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"film-scoring", "vsl", "v1.2"}, tags)
+
+	_, err = NormalizeTags([]string{"no/slashes"})
+	assert.True(t, errors.Is(err, ErrInvalid))
+	_, err = NormalizeTags([]string{"a", "b", "c", "d", "e", "f"})
+	assert.True(t, errors.Is(err, ErrInvalid))
+}
+
+func TestForum_GetThreadsByTag(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	syn, err := f.CreateSection(ctx, "Synchron", "Libraries", 200, mhc, SectionOptions{})
+	require.Nil(t, err)
+	t1, err := f.CreateThread(ctx, "One", "First", mhc, gen[0], ThreadOptions{Tags: []string{"Strings"}})
+	require.Nil(t, err)
+	t2, err := f.CreateThread(ctx, "Two", "Second", ella, syn[0], ThreadOptions{Tags: []string{"strings", "brass"}})
+	require.Nil(t, err)
+	_, err = f.CreateThread(ctx, "Three", "Third", ella, syn[0], ThreadOptions{Tags: []string{"brass"}})
+	require.Nil(t, err)
+
+	threads, _, err := f.GetThreadsByTag(ctx, "STRINGS", nil, 10, View{})
+	require.Nil(t, err)
+	require.Len(t, threads, 2)
+	assert.Equal(t, t2[1], threads[0].ID())
+	assert.Equal(t, t1[1], threads[1].ID())
+
+	require.Nil(t, f.UpdateThread(ctx, t1[1], "One", "First", mhc, []string{"woodwinds"}))
+	threads, _, err = f.GetThreadsByTag(ctx, "strings", nil, 10, View{})
+	require.Nil(t, err)
+	assert.Len(t, threads, 1)
+
+	tags, err := f.GetTags(ctx, 10)
+	require.Nil(t, err)
+	counts := make(map[string]int)
+	for _, tag := range tags {
+		counts[tag.Name] = tag.Count
+	}
+	assert.Equal(t, 2, counts["brass"])
+	assert.Equal(t, 1, counts["strings"])
+	assert.Equal(t, 1, counts["woodwinds"])
+}
+
+func TestForum_RenameTag(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	root := User{ID: admin, Name: adminDisplay}
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	_, err = f.CreateThread(ctx, "One", "First", mhc, gen[0], ThreadOptions{Tags: []string{"violin"}})
+	require.Nil(t, err)
+	_, err = f.CreateThread(ctx, "Two", "Second", mhc, gen[0], ThreadOptions{Tags: []string{"violin", "strings"}})
+	require.Nil(t, err)
+	gone, err := f.CreateThread(ctx, "Three", "Deleted", mhc, gen[0], ThreadOptions{Tags: []string{"violin"}})
+	require.Nil(t, err)
+	require.Nil(t, f.DeleteThread(ctx, gone[1], mhc, "oops"))
+
+	assert.NotNil(t, f.RenameTag(ctx, "violin", "strings", ella))
+	require.Nil(t, f.RenameTag(ctx, "violin", "strings", root))
+	threads, _, err := f.GetThreadsByTag(ctx, "strings", nil, 10, View{})
+	require.Nil(t, err)
+	assert.Len(t, threads, 2)
+	tag, err := f.getTag(ctx, "strings")
+	require.Nil(t, err)
+	assert.Equal(t, 2, tag.Count)
+	tag, err = f.getTag(ctx, "violin")
+	require.Nil(t, err)
+	assert.Nil(t, tag)
+	entries, err := f.GetAuditLog(ctx, AuditQuery{Actor: admin}, root, 10)
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, AuditRenameTag, entries[0].Action)
+	assert.Equal(t, "violin", entries[0].Tag)
+	assert.Equal(t, "strings", entries[0].NewTag)
+
+	require.Nil(t, f.ExpungePost(ctx, threads[0].ID(), root, "spam"))
+	tag, err = f.getTag(ctx, "strings")
+	require.Nil(t, err)
+	assert.Equal(t, 1, tag.Count)
+}
+
+func TestForum_CuratedTags(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	root := User{ID: admin, Name: adminDisplay}
+	ann, err := f.CreateSection(ctx, "Announcements", "News", 100, mhc, SectionOptions{CuratedTags: true})
+	require.Nil(t, err)
+	_, err = f.CreateThread(ctx, "One", "First", mhc, ann[0], ThreadOptions{Tags: []string{"release"}})
+	assert.NotNil(t, err)
+	require.Nil(t, f.CurateTag(ctx, "release", true, root))
+	_, err = f.CreateThread(ctx, "One", "First", mhc, ann[0], ThreadOptions{Tags: []string{"release"}})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,123 @@
+package forum
+
+import (
+	"cloud.google.com/go/firestore"
+)
+
+// SolvedFilter restricts a listing of Q&A threads by whether they have an accepted answer.
+type SolvedFilter int
+
+const (
+	AnyThread SolvedFilter = iota
+	SolvedOnly
+	UnsolvedOnly
+)
+
+// View describes how a listing should be narrowed. The zero value lists everything an
+// anonymous reader may see.
+type View struct {
+	Solved SolvedFilter
+	Viewer *User // Who is reading, or nil if anonymous
+	// Include posts by users the viewer has blocked, marked Collapsed, instead of leaving
+	// them out.
+	Collapse bool
+}
+
+// applyView narrows query to what view allows. Some restrictions can't be expressed as a
+// Firestore query, so it also returns a predicate that posts read back must satisfy.
+// Pending and Shadow are left to the predicate: posts written before those fields existed
+// lack them, and a query on them would leave those posts out.
+func (f Forum) applyView(ctx Context, query firestore.Query, view View) (firestore.Query, func(*Post) bool, error) {
+	switch view.Solved {
+	case SolvedOnly:
+		query = query.Where("QA", "==", true).Where("Solved", "==", true)
+	case UnsolvedOnly:
+		query = query.Where("QA", "==", true).Where("Solved", "==", false)
+	}
+	r, err := f.newReader(ctx, view)
+	if err != nil {
+		return query, nil, err
+	}
+	return query, r.canSee, nil
+}
+
+// reader is what a listing needs to know about the person it is for.
+type reader struct {
+	id       string // Empty if anonymous
+	shadowed bool
+	access   *access
+	blocked  map[string]bool // IDs of users the reader has blocked
+	collapse bool
+}
+
+func (f Forum) newReader(ctx Context, view View) (*reader, error) {
+	r := &reader{collapse: view.Collapse}
+	var err error
+	if view.Viewer != nil {
+		r.id = view.Viewer.ID
+		r.shadowed, err = f.isShadowBanned(ctx, r.id)
+		if err != nil {
+			return nil, err
+		}
+		r.blocked, err = f.blockedBy(ctx, r.id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.access, err = f.getAccess(ctx, view.Viewer)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// canSee reports whether the reader may see a post. A shadow-banned user must keep
+// seeing their own posts, or they'd notice the ban. When collapsing, canSee marks posts
+// by blocked users as it lets them through.
+func (r *reader) canSee(p *Post) bool {
+	if p.Pending != nil {
+		return false
+	}
+	if p.Shadow && (!r.shadowed || p.Author.ID != r.id) {
+		return false
+	}
+	if !r.access.canRead(p) {
+		return false
+	}
+	if r.blocked[p.Author.ID] {
+		if !r.collapse {
+			return false
+		}
+		p.Collapsed = true
+	}
+	return true
+}
+
+// paginateView returns the next n posts from query that satisfy keep. It reads on when
+// posts are filtered out, so that pages stay full, and the cursor it returns resumes
+// right after the last post returned.
+func (f Forum) paginateView(ctx Context, query firestore.Query, cursor Cursor, n int, keep func(*Post) bool) ([]*Post, Cursor, error) {
+	var result []*Post
+	for {
+		page, next, err := f.paginate(ctx, query, cursor, n)
+		if err != nil {
+			return nil, nil, err
+		}
+		for k, p := range page {
+			if !keep(p) {
+				continue
+			}
+			result = append(result, p)
+			if len(result) == n {
+				if k == len(page)-1 {
+					return result, next, nil
+				}
+				return result, cursor.Next(p), nil
+			}
+		}
+		if next == nil {
+			return result, nil, nil
+		}
+		cursor = next
+	}
+}
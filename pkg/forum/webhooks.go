@@ -0,0 +1,460 @@
+package forum
+
+import (
+	"bytes"
+	"cloud.google.com/go/firestore"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/uniq"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	Webhooks   = "Webhooks"
+	Deliveries = "WebhookDeliveries"
+)
+
+const (
+	// MaxWebhookAttempts is how many times a delivery is tried before it is given up on.
+	MaxWebhookAttempts = 8
+	webhookBackoff     = 30 * time.Second // Wait after the first failed attempt, doubling after each
+	maxWebhookBackoff  = 6 * time.Hour
+	// webhookLease is how long a worker has to finish an attempt before another worker
+	// may try the same delivery.
+	webhookLease = time.Minute
+	// deliveryBatch is how many deliveries DeleteWebhook gives up on in each batch.
+	deliveryBatch = 400
+)
+
+// Webhook asks for events about posts to be sent to a URL.
+type Webhook struct {
+	ID          string
+	URL         string
+	Secret      string      // Key for signing deliveries
+	Events      []EventKind // PostAdded, PostEdited and PostDeleted. Empty means all of them.
+	Sections    []PostID    // Only posts in these sections or their sub-sections. Empty means everywhere.
+	ThreadsOnly bool        // Only threads, not replies
+	Owner       User
+	CreateTime  time.Time `firestore:",serverTimestamp"`
+}
+
+// wants reports whether the webhook is interested in an event about post.
+func (h *Webhook) wants(kind EventKind, post *Post) bool {
+	if len(h.Events) > 0 && !containsKind(h.Events, kind) {
+		return false
+	}
+	if h.ThreadsOnly && post.threadID() != post.ID() {
+		return false
+	}
+	if len(h.Sections) == 0 {
+		return true
+	}
+	for _, id := range post.sectionPath() {
+		if containsID(h.Sections, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsKind(kinds []EventKind, kind EventKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// DeliveryStatus says where a delivery is in its life.
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryDead      DeliveryStatus = "dead" // Gave up after MaxWebhookAttempts, or the webhook was deleted
+)
+
+// Delivery is one event to be sent to one webhook, and the history of trying to send it.
+type Delivery struct {
+	ID          string
+	Webhook     string
+	URL         string
+	Event       EventKind
+	Post        PostID
+	Payload     string
+	Status      DeliveryStatus
+	Attempts    int
+	LastStatus  int // HTTP status of the last attempt, or 0 if there was no response
+	LastError   string
+	NextAttempt time.Time
+	CreateTime  time.Time `firestore:",serverTimestamp"`
+	DeliverTime time.Time // When it was delivered
+}
+
+// WebhookPayload is the JSON body of a delivery.
+type WebhookPayload struct {
+	Event EventKind `json:"event"`
+	Time  time.Time `json:"time"`
+	Post  *Post     `json:"post"`
+}
+
+// SignWebhook returns the signature sent with a delivery in the X-Forum-Signature
+// header, so that receivers can check that it came from the forum.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CreateWebhook subscribes a URL to events. Only moderators may manage webhooks.
+func (f Forum) CreateWebhook(ctx Context, hook Webhook, moderator User) (string, error) {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return "", fmt.Errorf("failed to create webhook: %w", err)
+	}
+	if hook.URL == "" || hook.Secret == "" {
+		return "", fmt.Errorf("failed to create webhook: %w: URL and secret are required", ErrInvalid)
+	}
+	for _, kind := range hook.Events {
+		if kind != PostAdded && kind != PostEdited && kind != PostDeleted {
+			return "", fmt.Errorf("failed to create webhook: unknown event %q", kind)
+		}
+	}
+	hook.ID = uniq.Uniq()
+	hook.Owner = moderator
+	if _, err := f.fs.Collection(Webhooks).Doc(hook.ID).Create(ctx, &hook); err != nil {
+		return "", fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return hook.ID, nil
+}
+
+// DeleteWebhook unsubscribes a webhook. Deliveries still queued for it can't be signed
+// without its secret, so they are marked dead rather than made.
+func (f Forum) DeleteWebhook(ctx Context, webhookID string, moderator User) error {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if _, err := f.fs.Collection(Webhooks).Doc(webhookID).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete webhook %s: %w", webhookID, err)
+	}
+	for {
+		docs, err := f.fs.
+			Collection(Deliveries).
+			Where("Webhook", "==", webhookID).
+			Where("Status", "==", DeliveryPending).
+			Limit(deliveryBatch).
+			Documents(ctx).
+			GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to cancel deliveries of webhook %s: %w", webhookID, err)
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+		wb := f.fs.Batch()
+		for _, doc := range docs {
+			wb.Update(doc.Ref, []firestore.Update{
+				{Path: "Status", Value: DeliveryDead},
+				{Path: "LastError", Value: "webhook deleted"},
+			})
+		}
+		if _, err := wb.Commit(ctx); err != nil {
+			return fmt.Errorf("failed to cancel deliveries of webhook %s: %w", webhookID, err)
+		}
+	}
+}
+
+// GetWebhooks returns every webhook.
+func (f Forum) GetWebhooks(ctx Context, moderator User) ([]*Webhook, error) {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return nil, fmt.Errorf("failed to get webhooks: %w", err)
+	}
+	hooks, err := f.getWebhooks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhooks: %w", err)
+	}
+	return hooks, nil
+}
+
+func (f Forum) getWebhooks(ctx Context) ([]*Webhook, error) {
+	docs, err := f.fs.Collection(Webhooks).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Webhook, len(docs))
+	for k, doc := range docs {
+		hook := &Webhook{}
+		if err := doc.DataTo(hook); err != nil {
+			return nil, fmt.Errorf("failed to decode webhook: %w", err)
+		}
+		result[k] = hook
+	}
+	return result, nil
+}
+
+// webhookDeliveries returns the deliveries of an event about post to each of hooks that
+// wants it. Callers write them in the same batch as the change itself, so that an event
+// is queued if and only if it happened. Hidden posts and sections send nothing.
+func webhookDeliveries(hooks []*Webhook, kind EventKind, post *Post) ([]*Delivery, error) {
+	if post.hidden() || post.isSection() {
+		return nil, nil
+	}
+	var result []*Delivery
+	var payload []byte
+	now := time.Now()
+	for _, hook := range hooks {
+		if !hook.wants(kind, post) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			payload, err = json.Marshal(&WebhookPayload{Event: kind, Time: now, Post: post})
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal webhook payload: %w", err)
+			}
+		}
+		result = append(result, &Delivery{
+			ID:          uniq.Uniq(),
+			Webhook:     hook.ID,
+			URL:         hook.URL,
+			Event:       kind,
+			Post:        post.ID(),
+			Payload:     string(payload),
+			Status:      DeliveryPending,
+			NextAttempt: now,
+		})
+	}
+	return result, nil
+}
+
+// queueWebhooks adds to wb the deliveries of an event about post.
+func (f Forum) queueWebhooks(wb *firestore.WriteBatch, hooks []*Webhook, kind EventKind, post *Post) error {
+	deliveries, err := webhookDeliveries(hooks, kind, post)
+	if err != nil {
+		return err
+	}
+	for _, d := range deliveries {
+		wb.Create(f.fs.Collection(Deliveries).Doc(d.ID), d)
+	}
+	return nil
+}
+
+// txQueueWebhooks adds to tx the deliveries of an event about post.
+func (f Forum) txQueueWebhooks(tx *firestore.Transaction, hooks []*Webhook, kind EventKind, post *Post) error {
+	deliveries, err := webhookDeliveries(hooks, kind, post)
+	if err != nil {
+		return err
+	}
+	for _, d := range deliveries {
+		if err := tx.Create(f.fs.Collection(Deliveries).Doc(d.ID), d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeliverWebhooks tries up to n deliveries that are due at time now, and returns how
+// many it tried. A delivery succeeds if its URL answers with a 2xx status. Failed
+// deliveries are retried with exponential backoff, and after MaxWebhookAttempts they are
+// marked dead. Several workers may deliver at once: each delivery is leased to one of
+// them while it is being tried.
+func (f Forum) DeliverWebhooks(ctx Context, client *http.Client, now time.Time, n int) (int, error) {
+	docs, err := f.fs.
+		Collection(Deliveries).
+		Where("Status", "==", DeliveryPending).
+		Where("NextAttempt", "<=", now).
+		OrderBy("NextAttempt", firestore.Asc).
+		Limit(n).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return 0, fmt.Errorf("failed to find deliveries: %w", err)
+	}
+	tried := 0
+	for _, doc := range docs {
+		d := &Delivery{}
+		if err := doc.DataTo(d); err != nil {
+			return tried, fmt.Errorf("failed to decode delivery: %w", err)
+		}
+		// The lease fails if another worker has touched the delivery since we read it.
+		_, err := doc.Ref.Update(ctx, []firestore.Update{
+			{Path: "NextAttempt", Value: now.Add(webhookLease)},
+		}, firestore.LastUpdateTime(doc.UpdateTime))
+		if err != nil {
+			continue
+		}
+		tried++
+		// A webhook deleted since the event can't sign its deliveries, so they are given up
+		// on at once. DeleteWebhook does the same, but may miss one queued as it ran.
+		var secret string
+		snap, err := f.fs.Collection(Webhooks).Doc(d.Webhook).Get(ctx)
+		gone := status.Code(err) == codes.NotFound
+		if err == nil {
+			hook := &Webhook{}
+			if err := snap.DataTo(hook); err == nil {
+				secret = hook.Secret
+			}
+		}
+		code, err := post(ctx, client, d, secret)
+		d.Attempts++
+		d.LastStatus = code
+		d.LastError = ""
+		switch {
+		case gone:
+			d.Status = DeliveryDead
+			d.LastError = "webhook deleted"
+		case err == nil:
+			d.Status = DeliveryDelivered
+			d.DeliverTime = now
+		case d.Attempts >= MaxWebhookAttempts:
+			d.Status = DeliveryDead
+			d.LastError = err.Error()
+		default:
+			d.LastError = err.Error()
+			d.NextAttempt = now.Add(backoff(d.Attempts))
+		}
+		_, err = doc.Ref.Update(ctx, []firestore.Update{
+			{Path: "Status", Value: d.Status},
+			{Path: "Attempts", Value: d.Attempts},
+			{Path: "LastStatus", Value: d.LastStatus},
+			{Path: "LastError", Value: d.LastError},
+			{Path: "NextAttempt", Value: d.NextAttempt},
+			{Path: "DeliverTime", Value: d.DeliverTime},
+		})
+		if err != nil {
+			return tried, fmt.Errorf("failed to record delivery %s: %w", d.ID, err)
+		}
+	}
+	return tried, nil
+}
+
+// backoff returns how long to wait after a delivery has failed attempts times.
+func backoff(attempts int) time.Duration {
+	wait := webhookBackoff
+	for k := 1; k < attempts && wait < maxWebhookBackoff; k++ {
+		wait *= 2
+	}
+	if wait > maxWebhookBackoff {
+		wait = maxWebhookBackoff
+	}
+	return wait
+}
+
+// post makes one attempt at a delivery and returns the HTTP status, if any.
+func post(ctx Context, client *http.Client, d *Delivery, secret string) (int, error) {
+	if secret == "" {
+		return 0, fmt.Errorf("webhook %s no longer exists", d.Webhook)
+	}
+	body := []byte(d.Payload)
+	req, err := http.NewRequest("POST", d.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "forum-webhooks")
+	req.Header.Set("X-Forum-Event", string(d.Event))
+	req.Header.Set("X-Forum-Delivery", d.ID)
+	req.Header.Set("X-Forum-Signature", SignWebhook(secret, body))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// RunWebhookWorker delivers webhooks every interval until ctx is done. Failures to read
+// or record deliveries are passed to report, and tried again next time.
+func (f Forum) RunWebhookWorker(ctx Context, client *http.Client, interval time.Duration, report func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := f.DeliverWebhooks(ctx, client, time.Now(), 100)
+			if err != nil && ctx.Err() == nil {
+				report(err)
+			}
+			if err != nil || n < 100 {
+				break
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// GetDeliveries returns up to n of a webhook's deliveries with a status, newest first,
+// or deliveries with any status if status is "". Dead deliveries are the webhook's
+// dead-letter list.
+func (f Forum) GetDeliveries(ctx Context, webhookID string, status DeliveryStatus, moderator User, n int) ([]*Delivery, error) {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return nil, fmt.Errorf("failed to get deliveries: %w", err)
+	}
+	query := f.fs.Collection(Deliveries).Where("Webhook", "==", webhookID)
+	if status != "" {
+		query = query.Where("Status", "==", status)
+	}
+	docs, err := query.OrderBy("CreateTime", firestore.Desc).Limit(n).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deliveries: %w", err)
+	}
+	result := make([]*Delivery, len(docs))
+	for k, doc := range docs {
+		d := &Delivery{}
+		if err := doc.DataTo(d); err != nil {
+			return nil, fmt.Errorf("failed to decode delivery: %w", err)
+		}
+		result[k] = d
+	}
+	return result, nil
+}
+
+// RetryDelivery puts a dead delivery back in the queue, with a fresh set of attempts. It
+// fails if the webhook has been deleted.
+func (f Forum) RetryDelivery(ctx Context, deliveryID string, moderator User) error {
+	if err := f.requireModerator(ctx, moderator); err != nil {
+		return fmt.Errorf("failed to retry delivery: %w", err)
+	}
+	doc := f.fs.Collection(Deliveries).Doc(deliveryID)
+	err := f.fs.RunTransaction(ctx, func(ctx Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(doc)
+		if err != nil {
+			return err
+		}
+		d := &Delivery{}
+		if err := snap.DataTo(d); err != nil {
+			return fmt.Errorf("failed to decode delivery: %w", err)
+		}
+		if d.Status != DeliveryDead {
+			return fmt.Errorf("delivery is %s, not dead", d.Status)
+		}
+		if _, err := tx.Get(f.fs.Collection(Webhooks).Doc(d.Webhook)); err != nil {
+			return fmt.Errorf("failed to read webhook %s: %w", d.Webhook, err)
+		}
+		return tx.Update(doc, []firestore.Update{
+			{Path: "Status", Value: DeliveryPending},
+			{Path: "Attempts", Value: 0},
+			{Path: "NextAttempt", Value: time.Now()},
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to retry delivery %s: %w", deliveryID, err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package forum
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver records the webhook deliveries it is sent, answering with status.
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	payloads []*WebhookPayload
+	bad      int // Deliveries with a wrong signature
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.Header.Get("X-Forum-Signature") != SignWebhook("shh", body) {
+		r.bad++
+	}
+	payload := &WebhookPayload{}
+	if err := json.Unmarshal(body, payload); err == nil {
+		r.payloads = append(r.payloads, payload)
+	}
+	w.WriteHeader(r.status)
+}
+
+func TestForum_Webhooks(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	other, err := f.CreateSection(ctx, "Other", "More chat", 200, mhc, SectionOptions{})
+	require.Nil(t, err)
+	r := &receiver{status: http.StatusOK}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	_, err = f.CreateWebhook(ctx, Webhook{URL: srv.URL, Secret: "shh"}, ella)
+	assert.True(t, errors.Is(err, ErrNotPermitted))
+	hookID, err := f.CreateWebhook(ctx, Webhook{
+		URL:      srv.URL,
+		Secret:   "shh",
+		Events:   []EventKind{PostAdded, PostDeleted},
+		Sections: []PostID{gen[0]},
+	}, moderator)
+	require.Nil(t, err)
+
+	thread, err := f.CreateThread(ctx, "Hi", "Hello", ella, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+	_, err = f.CreateThread(ctx, "Elsewhere", "Not wanted", ella, other[0], ThreadOptions{})
+	require.Nil(t, err)
+	require.Nil(t, f.UpdateThread(ctx, thread[1], "Hi", "Edits aren't wanted either", ella, nil))
+	require.Nil(t, f.DeleteThread(ctx, thread[1], ella, "oops"))
+
+	n, err := f.DeliverWebhooks(ctx, srv.Client(), time.Now(), 10)
+	require.Nil(t, err)
+	assert.Equal(t, 2, n)
+	require.Len(t, r.payloads, 2)
+	assert.Zero(t, r.bad)
+	kinds := []EventKind{r.payloads[0].Event, r.payloads[1].Event}
+	assert.ElementsMatch(t, []EventKind{PostAdded, PostDeleted}, kinds)
+	assert.Equal(t, thread[1], r.payloads[0].Post.ID())
+
+	history, err := f.GetDeliveries(ctx, hookID, DeliveryDelivered, moderator, 10)
+	require.Nil(t, err)
+	assert.Len(t, history, 2)
+	n, err = f.DeliverWebhooks(ctx, srv.Client(), time.Now(), 10)
+	require.Nil(t, err)
+	assert.Zero(t, n)
+}
+
+func TestForum_WebhookRetries(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	r := &receiver{status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	hookID, err := f.CreateWebhook(ctx, Webhook{URL: srv.URL, Secret: "shh", ThreadsOnly: true}, moderator)
+	require.Nil(t, err)
+	thread, err := f.CreateThread(ctx, "Hi", "Hello", ella, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+	_, err = f.CreateReply(ctx, thread, "Re: Hi", "Replies aren't wanted", mhc)
+	require.Nil(t, err)
+
+	// Each failure puts off the next attempt for twice as long.
+	now := time.Now()
+	for attempt := 1; attempt < MaxWebhookAttempts; attempt++ {
+		n, err := f.DeliverWebhooks(ctx, srv.Client(), now, 10)
+		require.Nil(t, err)
+		require.Equal(t, 1, n, "attempt %d", attempt)
+		n, err = f.DeliverWebhooks(ctx, srv.Client(), now.Add(backoff(attempt)-time.Second), 10)
+		require.Nil(t, err)
+		require.Zero(t, n, "attempt %d", attempt)
+		now = now.Add(backoff(attempt))
+	}
+	n, err := f.DeliverWebhooks(ctx, srv.Client(), now, 10)
+	require.Nil(t, err)
+	require.Equal(t, 1, n)
+	assert.Len(t, r.payloads, MaxWebhookAttempts)
+
+	dead, err := f.GetDeliveries(ctx, hookID, DeliveryDead, moderator, 10)
+	require.Nil(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, MaxWebhookAttempts, dead[0].Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, dead[0].LastStatus)
+	assert.Contains(t, dead[0].LastError, "503")
+
+	// Once the receiver is fixed, a dead delivery can be sent again.
+	r.mu.Lock()
+	r.status = http.StatusNoContent
+	r.mu.Unlock()
+	require.Nil(t, f.RetryDelivery(ctx, dead[0].ID, moderator))
+	n, err = f.DeliverWebhooks(ctx, srv.Client(), time.Now(), 10)
+	require.Nil(t, err)
+	assert.Equal(t, 1, n)
+	all, err := f.GetDeliveries(ctx, hookID, "", moderator, 10)
+	require.Nil(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, DeliveryDelivered, all[0].Status)
+	assert.NotNil(t, f.RetryDelivery(ctx, all[0].ID, moderator))
+}
+
+func TestForum_DeleteWebhook(t *testing.T) {
+	f, err := NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	defer f.expunge(ctx)
+	gen, err := f.CreateSection(ctx, "General", "Chat", 100, mhc, SectionOptions{})
+	require.Nil(t, err)
+	r := &receiver{status: http.StatusOK}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	hookID, err := f.CreateWebhook(ctx, Webhook{URL: srv.URL, Secret: "shh"}, moderator)
+	require.Nil(t, err)
+	_, err = f.CreateThread(ctx, "Hi", "Hello", ella, gen[0], ThreadOptions{})
+	require.Nil(t, err)
+
+	// What was queued is given up on, not retried without a secret.
+	require.Nil(t, f.DeleteWebhook(ctx, hookID, moderator))
+	n, err := f.DeliverWebhooks(ctx, srv.Client(), time.Now(), 10)
+	require.Nil(t, err)
+	assert.Zero(t, n)
+	assert.Empty(t, r.payloads)
+	dead, err := f.GetDeliveries(ctx, hookID, DeliveryDead, moderator, 10)
+	require.Nil(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, "webhook deleted", dead[0].LastError)
+	assert.NotNil(t, f.RetryDelivery(ctx, dead[0].ID, moderator))
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, backoff(1))
+	assert.Equal(t, time.Minute, backoff(2))
+	assert.Equal(t, 8*time.Minute, backoff(5))
+	assert.Equal(t, maxWebhookBackoff, backoff(20))
+}
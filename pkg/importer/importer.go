@@ -0,0 +1,439 @@
+// Package importer moves a community from other forum software, such as phpBB or
+// Discourse, into the forum.
+//
+// A dump from the old forum is first converted, by a script of your own, into JSON
+// Lines, one record per line. Each record has a "type" and the old forum's "id" for it;
+// the other fields depend on the type:
+//
+//	{"type": "user", "id": "42", "name": "Alice", "joined": "2009-04-01T10:00:00Z"}
+//	{"type": "category", "id": "3", "parent": "1", "title": "Help", "description": "Ask here", "position": 2}
+//	{"type": "topic", "id": "100", "category": "3", "title": "Can't log in", "user": "42",
+//	    "created": "2012-05-06T07:08:09Z", "tags": ["login"], "locked": true}
+//	{"type": "post", "id": "1000", "topic": "100", "reply_to": "999", "user": "42",
+//	    "created": "2012-05-06T07:08:09Z", "edited": "2012-05-07T00:00:00Z", "body": "<p>Hi</p>",
+//	    "deleted": false}
+//
+// A category's parent and creation time, and a post's reply_to, are optional. Times are
+// RFC 3339.
+// Categories become sections, nested as they were; topics become threads; and posts
+// become replies, each under the post it replies to, or under the thread if it doesn't
+// say. The first post of a topic, by creation time, is the thread's own body rather
+// than a reply, as it is in both phpBB and Discourse. Records may come in any order.
+//
+// Posts keep their creation and edit times and their authors. Users get the ID
+// Options.UserPrefix followed by their old ID, so they can be told apart from users who
+// sign up. New IDs are given to everything else, and Import reports which old ID became
+// which new one.
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mhcoffin/forum-tools/pkg/forum"
+	"github.com/mhcoffin/forum-tools/pkg/uniq"
+	"io"
+	"sort"
+	"time"
+)
+
+// DefaultUserPrefix starts the IDs of imported users, unless Options says otherwise.
+const DefaultUserPrefix = "imported:"
+
+// Record is one line of a dump. Which fields are used depends on Type.
+type Record struct {
+	Type        string    `json:"type"` // "user", "category", "topic" or "post"
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`        // User
+	Joined      time.Time `json:"joined"`      // User
+	Parent      string    `json:"parent"`      // Category
+	Title       string    `json:"title"`       // Category, topic
+	Description string    `json:"description"` // Category
+	Position    int       `json:"position"`    // Category
+	Category    string    `json:"category"`    // Topic
+	Tags        []string  `json:"tags"`        // Topic
+	Locked      bool      `json:"locked"`      // Topic
+	Topic       string    `json:"topic"`       // Post
+	ReplyTo     string    `json:"reply_to"`    // Post
+	User        string    `json:"user"`        // Topic, post
+	Created     time.Time `json:"created"`     // Category, topic, post
+	Edited      time.Time `json:"edited"`      // Post
+	Body        string    `json:"body"`        // Post
+	Deleted     bool      `json:"deleted"`     // Post
+}
+
+// Options control an import.
+type Options struct {
+	UserPrefix string // Starts the ID of every imported user. Default DefaultUserPrefix.
+	// Moderator is recorded as the author of the sections, and as whoever deleted posts
+	// that were deleted in the old forum.
+	Moderator forum.User
+	// Previous is the report of an earlier import of the same dump. Its IDs are used
+	// again, so that importing with forum.ConflictSkip resumes an import that failed.
+	Previous   *Report
+	OnConflict forum.ConflictPolicy
+	DryRun     bool
+}
+
+// Report maps the old forum's IDs to the new ones.
+type Report struct {
+	Users      map[string]string       `json:"users"`
+	Categories map[string]forum.PostID `json:"categories"`
+	Topics     map[string]forum.PostID `json:"topics"`
+	Posts      map[string]forum.PostID `json:"posts"` // The first post of a topic maps to its thread
+	Warnings   []string                `json:"warnings,omitempty"`
+	Stats      *forum.ImportStats      `json:"stats,omitempty"`
+}
+
+// Import reads a dump from r and adds it to the forum. The report says what became of
+// everything, even if the import fails part way.
+func Import(ctx context.Context, f *forum.Forum, r io.Reader, opts Options) (*Report, error) {
+	records, err := Read(r)
+	if err != nil {
+		return nil, err
+	}
+	posts, report, err := Convert(records, opts)
+	if err != nil {
+		return report, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, p := range posts {
+		if err := enc.Encode(p); err != nil {
+			return report, fmt.Errorf("failed to encode post: %w", err)
+		}
+	}
+	stats, err := f.Import(ctx, &buf, forum.ImportOptions{OnConflict: opts.OnConflict, DryRun: opts.DryRun})
+	report.Stats = stats
+	if err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// Read reads the records of a dump.
+func Read(r io.Reader) ([]*Record, error) {
+	var records []*Record
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		rec := &Record{}
+		err := dec.Decode(rec)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read record %d: %w", line, err)
+		}
+		if rec.ID == "" {
+			return nil, fmt.Errorf("failed to read record %d: no ID", line)
+		}
+		records = append(records, rec)
+	}
+}
+
+// converter turns records into posts.
+type converter struct {
+	opts       Options
+	report     *Report
+	users      map[string]*Record
+	categories map[string]*Record
+	topics     map[string]*Record
+	posts      map[string][]*Record      // By topic
+	sections   map[string][]forum.PostID // Paths of converted categories, by old ID
+	result     []*forum.Post
+}
+
+// Convert turns the records of a dump into posts for forum.Import, each after its
+// parent, and reports the IDs it gave them. Problems that can be worked around, such as
+// a post replying to one that isn't there, are reported as warnings; others are errors.
+func Convert(records []*Record, opts Options) ([]*forum.Post, *Report, error) {
+	if opts.UserPrefix == "" {
+		opts.UserPrefix = DefaultUserPrefix
+	}
+	c := &converter{
+		opts: opts,
+		report: &Report{
+			Users:      make(map[string]string),
+			Categories: make(map[string]forum.PostID),
+			Topics:     make(map[string]forum.PostID),
+			Posts:      make(map[string]forum.PostID),
+		},
+		users:      make(map[string]*Record),
+		categories: make(map[string]*Record),
+		topics:     make(map[string]*Record),
+		posts:      make(map[string][]*Record),
+		sections:   make(map[string][]forum.PostID),
+	}
+	seen := make(map[string]bool)
+	for _, rec := range records {
+		key := rec.Type + " " + rec.ID
+		if seen[key] {
+			return nil, c.report, fmt.Errorf("failed to convert: %s %s appears twice", rec.Type, rec.ID)
+		}
+		seen[key] = true
+		switch rec.Type {
+		case "user":
+			c.users[rec.ID] = rec
+		case "category":
+			c.categories[rec.ID] = rec
+		case "topic":
+			c.topics[rec.ID] = rec
+		case "post":
+			c.posts[rec.Topic] = append(c.posts[rec.Topic], rec)
+		default:
+			return nil, c.report, fmt.Errorf("failed to convert: %s %s has unknown type", rec.Type, rec.ID)
+		}
+	}
+	for _, id := range sortedKeys(c.categories) {
+		if _, err := c.section(id, nil); err != nil {
+			return nil, c.report, err
+		}
+	}
+	for _, id := range sortedKeys(c.topics) {
+		if err := c.thread(c.topics[id]); err != nil {
+			return nil, c.report, err
+		}
+	}
+	var missing []string
+	for topic := range c.posts {
+		if c.topics[topic] == nil {
+			missing = append(missing, topic)
+		}
+	}
+	sort.Strings(missing)
+	for _, topic := range missing {
+		c.warn("%d posts in topic %s, which doesn't exist, were left out", len(c.posts[topic]), topic)
+	}
+	return c.result, c.report, nil
+}
+
+func sortedKeys(m map[string]*Record) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *converter) warn(format string, args ...interface{}) {
+	c.report.Warnings = append(c.report.Warnings, fmt.Sprintf(format, args...))
+}
+
+// newID returns the new ID for an old one, reusing the one from a previous import.
+func (c *converter) newID(previous func(*Report) map[string]forum.PostID, old string) forum.PostID {
+	if c.opts.Previous != nil {
+		if id, ok := previous(c.opts.Previous)[old]; ok {
+			return id
+		}
+	}
+	return uniq.Uniq()
+}
+
+func (c *converter) user(old string) forum.User {
+	id := c.opts.UserPrefix + old
+	_, known := c.report.Users[old]
+	c.report.Users[old] = id
+	rec := c.users[old]
+	if rec == nil {
+		if !known {
+			c.warn("user %s doesn't exist", old)
+		}
+		return forum.User{ID: id, Name: old}
+	}
+	name := rec.Name
+	if name == "" {
+		name = old
+	}
+	return forum.User{ID: id, Name: name, Joined: rec.Joined}
+}
+
+// section converts a category, after its parents, and returns its path. visiting holds
+// the categories being converted, to catch cycles.
+func (c *converter) section(old string, visiting map[string]bool) ([]forum.PostID, error) {
+	if path, ok := c.sections[old]; ok {
+		return path, nil
+	}
+	rec := c.categories[old]
+	if visiting[old] {
+		return nil, fmt.Errorf("failed to convert: category %s is its own ancestor", old)
+	}
+	if visiting == nil {
+		visiting = make(map[string]bool)
+	}
+	visiting[old] = true
+	var parent []forum.PostID
+	if rec.Parent != "" {
+		if c.categories[rec.Parent] == nil {
+			c.warn("category %s has parent %s, which doesn't exist, so it is a top-level section", old, rec.Parent)
+		} else {
+			var err error
+			parent, err = c.section(rec.Parent, visiting)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	id := c.newID(func(r *Report) map[string]forum.PostID { return r.Categories }, old)
+	path := append(append([]forum.PostID{}, parent...), id)
+	post := &forum.Post{
+		Path:       path,
+		Index:      rec.Position,
+		Head:       rec.Title,
+		Body:       rec.Description,
+		Author:     c.opts.Moderator,
+		Sections:   len(path),
+		Bump:       &forum.Bump{Time: rec.Created},
+		CreateTime: rec.Created,
+		EditTime:   rec.Created,
+	}
+	if len(parent) > 0 {
+		post.Parent = parent[len(parent)-1]
+	}
+	c.result = append(c.result, post)
+	c.sections[old] = path
+	c.report.Categories[old] = id
+	return path, nil
+}
+
+// thread converts a topic and its posts. Replies are added depth first, so that each
+// comes after the post it replies to.
+func (c *converter) thread(topic *Record) error {
+	posts := c.posts[topic.ID]
+	section, ok := c.sections[topic.Category]
+	if !ok {
+		c.warn("topic %s is in category %s, which doesn't exist, so it and its %d posts were left out",
+			topic.ID, topic.Category, len(posts))
+		return nil
+	}
+	sort.SliceStable(posts, func(i, j int) bool { return posts[i].Created.Before(posts[j].Created) })
+	id := c.newID(func(r *Report) map[string]forum.PostID { return r.Topics }, topic.ID)
+	thread := &forum.Post{
+		Path:       append(append([]forum.PostID{}, section...), id),
+		Parent:     section[len(section)-1],
+		Head:       topic.Title,
+		Tags:       c.tags(topic),
+		Locked:     topic.Locked,
+		Sections:   len(section),
+		CreateTime: topic.Created,
+		EditTime:   topic.Created,
+	}
+	c.report.Topics[topic.ID] = id
+	author := topic.User
+	var first *Record
+	if len(posts) > 0 {
+		first, posts = posts[0], posts[1:]
+		c.report.Posts[first.ID] = id
+		thread.Body = first.Body
+		thread.Deleted = c.deleted(first)
+		if author == "" {
+			author = first.User
+		}
+		if thread.CreateTime.IsZero() {
+			thread.CreateTime = first.Created
+		}
+		thread.EditTime = editTime(first)
+	} else {
+		c.warn("topic %s has no posts", topic.ID)
+	}
+	thread.Author = c.user(author)
+	thread.Bump = &forum.Bump{ID: id, Head: thread.Head, Author: thread.Author, Time: thread.CreateTime}
+	c.result = append(c.result, thread)
+
+	// Replies to the first post, or to nothing, or to a post that isn't in the topic,
+	// go directly under the thread.
+	inTopic := make(map[string]bool)
+	for _, p := range posts {
+		inTopic[p.ID] = true
+	}
+	children := make(map[string][]*Record)
+	for _, p := range posts {
+		parent := p.ReplyTo
+		if first != nil && parent == first.ID {
+			parent = ""
+		}
+		if parent != "" && !inTopic[parent] {
+			c.warn("post %s replies to %s, which isn't in topic %s, so it replies to the thread", p.ID, parent, topic.ID)
+			parent = ""
+		}
+		children[parent] = append(children[parent], p)
+	}
+	added := make(map[string]bool)
+	var add func(p *Record, parent []forum.PostID)
+	add = func(p *Record, parent []forum.PostID) {
+		added[p.ID] = true
+		path := append(append([]forum.PostID{}, parent...), c.newID(func(r *Report) map[string]forum.PostID { return r.Posts }, p.ID))
+		// Like replies added normally, those too deep go beside their parent.
+		if len(path) > forum.MaxDepth {
+			path[forum.MaxDepth-1] = path[len(path)-1]
+			path = path[:forum.MaxDepth]
+		}
+		c.result = append(c.result, &forum.Post{
+			Path:       path,
+			Parent:     path[len(path)-2],
+			Head:       "Re: " + topic.Title,
+			Body:       p.Body,
+			Author:     c.user(p.User),
+			Deleted:    c.deleted(p),
+			Sections:   len(section),
+			CreateTime: p.Created,
+			EditTime:   editTime(p),
+		})
+		c.report.Posts[p.ID] = path[len(path)-1]
+		for _, child := range children[p.ID] {
+			if !added[child.ID] {
+				add(child, path)
+			}
+		}
+	}
+	for _, p := range children[""] {
+		add(p, thread.Path)
+	}
+	// What's left replies, directly or not, to itself.
+	for _, p := range posts {
+		if !added[p.ID] {
+			c.warn("post %s is in a cycle of replies, so it replies to the thread", p.ID)
+			add(p, thread.Path)
+		}
+	}
+	return nil
+}
+
+// tags returns a topic's tags, normalized, leaving out those the forum doesn't allow.
+func (c *converter) tags(topic *Record) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, tag := range topic.Tags {
+		t, err := forum.NormalizeTag(tag)
+		if err != nil {
+			c.warn("topic %s: %v, so it was left out", topic.ID, err)
+			continue
+		}
+		if seen[t] {
+			continue
+		}
+		if len(tags) == forum.MaxTags {
+			c.warn("topic %s has more than %d tags, so %q was left out", topic.ID, forum.MaxTags, t)
+			continue
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+// deleted returns how a post deleted in the old forum is recorded as deleted.
+func (c *converter) deleted(p *Record) *forum.DeleteInfo {
+	if !p.Deleted {
+		return nil
+	}
+	return &forum.DeleteInfo{When: editTime(p), Who: c.opts.Moderator, Why: "deleted before import"}
+}
+
+func editTime(p *Record) time.Time {
+	if p.Edited.IsZero() {
+		return p.Created
+	}
+	return p.Edited
+}
@@ -0,0 +1,149 @@
+package importer
+
+import (
+	"context"
+	"github.com/mhcoffin/forum-tools/pkg/forum"
+	"github.com/mhcoffin/forum-tools/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	ctx       = context.Background()
+	moderator = forum.User{ID: "mhc", Name: "Michael"}
+)
+
+func TestMain(m *testing.M) {
+	testutil.StartFirestoreEmulator(m)
+}
+
+const dump = `
+{"type": "post", "id": "p3", "topic": "t1", "reply_to": "p2", "user": "u2", "created": "2012-01-01T12:00:00Z", "body": "nested"}
+{"type": "post", "id": "p1", "topic": "t1", "user": "u1", "created": "2012-01-01T10:00:00Z", "body": "first", "edited": "2012-01-02T10:00:00Z"}
+{"type": "post", "id": "p2", "topic": "t1", "reply_to": "p1", "user": "u2", "created": "2012-01-01T11:00:00Z", "body": "reply"}
+{"type": "post", "id": "p4", "topic": "t1", "reply_to": "gone", "user": "u1", "created": "2012-01-01T13:00:00Z", "body": "orphan", "deleted": true}
+{"type": "topic", "id": "t1", "category": "c2", "title": "Hello", "user": "u1", "created": "2012-01-01T10:00:00Z", "tags": ["Intro", "c++"], "locked": true}
+{"type": "category", "id": "c2", "parent": "c1", "title": "Help", "description": "Ask here", "position": 2}
+{"type": "category", "id": "c1", "title": "Support", "position": 1}
+{"type": "user", "id": "u1", "name": "Alice", "joined": "2009-04-01T10:00:00Z"}
+{"type": "user", "id": "u2", "name": "Bob"}
+{"type": "post", "id": "p5", "topic": "nowhere", "user": "u2", "body": "lost"}
+`
+
+func TestConvert(t *testing.T) {
+	records, err := Read(strings.NewReader(dump))
+	require.Nil(t, err)
+	posts, report, err := Convert(records, Options{Moderator: moderator})
+	require.Nil(t, err)
+	require.Len(t, posts, 6)
+	byID := make(map[forum.PostID]*forum.Post)
+	for k, p := range posts {
+		if p.Parent != "" {
+			require.NotNil(t, byID[p.Parent], "post %d comes before its parent", k)
+			assert.Equal(t, byID[p.Parent].Path, p.Path[:len(p.Path)-1])
+		}
+		byID[p.ID()] = p
+	}
+
+	c1, c2 := byID[report.Categories["c1"]], byID[report.Categories["c2"]]
+	assert.Equal(t, 1, c1.Sections)
+	assert.Equal(t, 2, c2.Sections)
+	assert.Equal(t, c1.ID(), c2.Parent)
+	assert.Equal(t, "Ask here", c2.Body)
+	assert.Equal(t, 2, c2.Index)
+
+	thread := byID[report.Topics["t1"]]
+	assert.Equal(t, c2.ID(), thread.Parent)
+	assert.Equal(t, 2, thread.Sections)
+	assert.Equal(t, "first", thread.Body)
+	assert.Equal(t, []string{"intro"}, thread.Tags)
+	assert.True(t, thread.Locked)
+	assert.Equal(t, forum.User{ID: "imported:u1", Name: "Alice", Joined: time.Date(2009, 4, 1, 10, 0, 0, 0, time.UTC)}, thread.Author)
+	assert.Equal(t, time.Date(2012, 1, 2, 10, 0, 0, 0, time.UTC), thread.EditTime)
+	assert.Equal(t, thread.ID(), report.Posts["p1"])
+
+	reply, nested := byID[report.Posts["p2"]], byID[report.Posts["p3"]]
+	assert.Equal(t, thread.ID(), reply.Parent)
+	assert.Equal(t, reply.ID(), nested.Parent)
+	assert.Equal(t, "Re: Hello", nested.Head)
+	assert.Equal(t, "imported:u2", nested.Author.ID)
+	assert.Equal(t, time.Date(2012, 1, 1, 12, 0, 0, 0, time.UTC), nested.CreateTime)
+
+	orphan := byID[report.Posts["p4"]]
+	assert.Equal(t, thread.ID(), orphan.Parent)
+	require.NotNil(t, orphan.Deleted)
+	assert.Equal(t, moderator, orphan.Deleted.Who)
+
+	assert.Equal(t, map[string]string{"u1": "imported:u1", "u2": "imported:u2"}, report.Users)
+	assert.Len(t, report.Warnings, 3) // The bad tag, the orphan and the lost post
+
+	// A second conversion with the first report gives the same IDs.
+	again, _, err := Convert(records, Options{Moderator: moderator, Previous: report})
+	require.Nil(t, err)
+	for k := range posts {
+		assert.Equal(t, posts[k].Path, again[k].Path)
+	}
+}
+
+func TestConvert_Problems(t *testing.T) {
+	convert := func(dump string) (*Report, error) {
+		records, err := Read(strings.NewReader(dump))
+		require.Nil(t, err)
+		_, report, err := Convert(records, Options{})
+		return report, err
+	}
+	_, err := convert(`{"type": "category", "id": "a", "parent": "b"} {"type": "category", "id": "b", "parent": "a"}`)
+	assert.NotNil(t, err)
+	_, err = convert(`{"type": "forum", "id": "a"}`)
+	assert.NotNil(t, err)
+	_, err = convert(`{"type": "user", "id": "a"} {"type": "user", "id": "a"}`)
+	assert.NotNil(t, err)
+	_, err = Read(strings.NewReader(`{"type": "user"}`))
+	assert.NotNil(t, err)
+
+	report, err := convert(`
+{"type": "category", "id": "c"}
+{"type": "topic", "id": "t", "category": "c", "title": "Loop"}
+{"type": "post", "id": "p0", "topic": "t", "user": "u", "created": "2012-01-01T00:00:00Z"}
+{"type": "post", "id": "p1", "topic": "t", "reply_to": "p2", "user": "u", "created": "2012-01-01T01:00:00Z"}
+{"type": "post", "id": "p2", "topic": "t", "reply_to": "p1", "user": "u", "created": "2012-01-01T02:00:00Z"}
+{"type": "topic", "id": "homeless", "category": "gone", "title": "Lost"}
+`)
+	require.Nil(t, err)
+	assert.Contains(t, report.Posts, "p1")
+	assert.Contains(t, report.Posts, "p2")
+	assert.NotContains(t, report.Topics, "homeless")
+	// The cycle, the missing category and the unknown user.
+	assert.Len(t, report.Warnings, 3)
+}
+
+func TestImport(t *testing.T) {
+	f, err := forum.NewClient(ctx, "fugalist")
+	require.Nil(t, err)
+	report, err := Import(ctx, f, strings.NewReader(dump), Options{Moderator: moderator, DryRun: true})
+	require.Nil(t, err)
+	assert.Equal(t, 6, report.Stats.Created)
+
+	report, err = Import(ctx, f, strings.NewReader(dump), Options{Moderator: moderator})
+	require.Nil(t, err)
+	assert.Equal(t, 6, report.Stats.Created)
+	threads, _, err := f.GetThreads(ctx, report.Categories["c2"], nil, 10, forum.View{})
+	require.Nil(t, err)
+	require.Len(t, threads, 1)
+	thread := threads[0]
+	assert.Equal(t, report.Topics["t1"], thread.ID())
+	assert.Equal(t, "Alice", thread.Author.Name)
+	assert.True(t, thread.CreateTime.Equal(time.Date(2012, 1, 1, 10, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 3, thread.DescendentCount)
+	assert.Equal(t, 2, thread.ChildCount)
+	assert.Equal(t, report.Posts["p4"], thread.Bump.ID)
+
+	// Importing again with the report resumes, rather than duplicating.
+	again, err := Import(ctx, f, strings.NewReader(dump), Options{Moderator: moderator, Previous: report, OnConflict: forum.ConflictSkip})
+	require.Nil(t, err)
+	assert.Equal(t, 6, again.Stats.Skipped)
+	assert.Zero(t, again.Stats.Created)
+}
@@ -0,0 +1,57 @@
+// Package ratelimit records recent events so that their rate can be limited.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory keeps a sliding log of events for each key in process memory. Limits enforced
+// with it apply to one process only.
+type Memory struct {
+	mu     sync.Mutex
+	events map[string][]time.Time
+}
+
+// NewMemory returns an empty store.
+func NewMemory() *Memory {
+	return &Memory{events: make(map[string][]time.Time)}
+}
+
+// Wait returns how long until one more event could be recorded under key without more
+// than limit falling in the window ending then. It records nothing.
+func (m *Memory) Wait(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events[key] = Prune(m.events[key], window, now)
+	return Delay(m.events[key], limit, window, now), nil
+}
+
+// Record records an event under key at time now, forgetting those older than window.
+func (m *Memory) Record(ctx context.Context, key string, window time.Duration, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events[key] = append(Prune(m.events[key], window, now), now)
+	return nil
+}
+
+// Delay returns how long after now until log, pruned to window, has room for one more
+// event under limit.
+func Delay(log []time.Time, limit int, window time.Duration, now time.Time) time.Duration {
+	if len(log) < limit {
+		return 0
+	}
+	return log[len(log)-limit].Add(window).Sub(now)
+}
+
+// Prune drops the events in log that are older than window at time now. log must be in
+// time order.
+func Prune(log []time.Time, window time.Duration, now time.Time) []time.Time {
+	start := now.Add(-window)
+	k := 0
+	for k < len(log) && !log[k].After(start) {
+		k++
+	}
+	return log[k:]
+}
@@ -0,0 +1,40 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for k := 0; k < 3; k++ {
+		wait, err := m.Wait(ctx, "a", 3, time.Minute, now.Add(time.Duration(k)*time.Second))
+		require.Nil(t, err)
+		assert.Zero(t, wait)
+		require.Nil(t, m.Record(ctx, "a", time.Minute, now.Add(time.Duration(k)*time.Second)))
+	}
+	wait, err := m.Wait(ctx, "a", 3, time.Minute, now.Add(10*time.Second))
+	require.Nil(t, err)
+	assert.Equal(t, 50*time.Second, wait)
+	// Waiting records nothing.
+	wait, err = m.Wait(ctx, "a", 4, time.Minute, now.Add(10*time.Second))
+	require.Nil(t, err)
+	assert.Zero(t, wait)
+
+	wait, err = m.Wait(ctx, "b", 3, time.Minute, now.Add(10*time.Second))
+	require.Nil(t, err)
+	assert.Zero(t, wait)
+
+	for k := 0; k < 2; k++ {
+		require.Nil(t, m.Record(ctx, "a", time.Minute, now.Add(61*time.Second)))
+	}
+	wait, err = m.Wait(ctx, "a", 3, time.Minute, now.Add(61*time.Second))
+	require.Nil(t, err)
+	assert.Equal(t, time.Second, wait)
+}
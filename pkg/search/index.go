@@ -0,0 +1,263 @@
+// Package search provides a local inverted index over forum posts.
+package search
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headWeight is how much more a match in the head counts than a match in the body.
+const headWeight = 3.0
+
+// Document is the searchable part of a post.
+type Document struct {
+	ID       string
+	Sections []string // IDs of the sections the post is in, outermost first
+	Author   string
+	Time     time.Time
+	Head     string
+	Body     string // HTML
+}
+
+// Hit is a document that matched a query.
+type Hit struct {
+	ID    string
+	Score float64
+}
+
+type entry struct {
+	doc     *Document
+	headLen int // Number of words in the head. Body positions start after it.
+}
+
+// Index is an in-memory inverted index. It is safe for concurrent use.
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[string]*entry
+	postings map[string]map[string][]int // word -> document ID -> positions
+}
+
+// NewIndex returns an empty index.
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]*entry),
+		postings: make(map[string]map[string][]int),
+	}
+}
+
+// Add indexes doc, replacing any previous version with the same ID.
+func (ix *Index) Add(doc Document) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(doc.ID)
+	head := Tokenize(doc.Head)
+	body := Tokenize(StripHTML(doc.Body))
+	// Leave a gap between head and body so phrases do not span them.
+	words := append(append(head, ""), body...)
+	for pos, word := range words {
+		if word == "" {
+			continue
+		}
+		docs, ok := ix.postings[word]
+		if !ok {
+			docs = make(map[string][]int)
+			ix.postings[word] = docs
+		}
+		docs[doc.ID] = append(docs[doc.ID], pos)
+	}
+	ix.docs[doc.ID] = &entry{doc: &doc, headLen: len(head)}
+}
+
+// Remove drops a document from the index.
+func (ix *Index) Remove(id string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+}
+
+func (ix *Index) remove(id string) {
+	e, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	words := Tokenize(e.doc.Head + " " + StripHTML(e.doc.Body))
+	for _, word := range words {
+		if docs, ok := ix.postings[word]; ok {
+			delete(docs, id)
+			if len(docs) == 0 {
+				delete(ix.postings, word)
+			}
+		}
+	}
+	delete(ix.docs, id)
+}
+
+// Len returns the number of indexed documents.
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.docs)
+}
+
+// Search returns every document matching q, best match first.
+func (ix *Index) Search(q Query) ([]Hit, error) {
+	terms, err := parse(q.Text)
+	if err != nil {
+		return nil, err
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	var scores map[string]float64
+	for _, t := range terms {
+		matches := ix.match(t)
+		if scores == nil {
+			scores = matches
+		} else {
+			for id, s := range scores {
+				if m, ok := matches[id]; ok {
+					scores[id] = s + m
+				} else {
+					delete(scores, id)
+				}
+			}
+		}
+		if len(scores) == 0 {
+			break
+		}
+	}
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		if ix.accept(ix.docs[id].doc, q) {
+			hits = append(hits, Hit{ID: id, Score: score})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return hits, nil
+}
+
+func (ix *Index) accept(doc *Document, q Query) bool {
+	switch {
+	case q.Section != "" && !inSection(doc, q.Section):
+		return false
+	case q.Author != "" && doc.Author != q.Author:
+		return false
+	case !q.Since.IsZero() && doc.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !doc.Time.Before(q.Until):
+		return false
+	}
+	return true
+}
+
+// inSection reports whether doc is in section, directly or in one of its sub-sections.
+func inSection(doc *Document, section string) bool {
+	for _, s := range doc.Sections {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
+
+// match scores every document containing t using tf-idf, counting head matches extra.
+func (ix *Index) match(t term) map[string]float64 {
+	positions := ix.positions(t)
+	scores := make(map[string]float64, len(positions))
+	idf := math.Log(1 + float64(len(ix.docs))/float64(1+len(positions)))
+	for id, pos := range positions {
+		headLen := ix.docs[id].headLen
+		tf := 0.0
+		for _, p := range pos {
+			if p < headLen {
+				tf += headWeight
+			} else {
+				tf++
+			}
+		}
+		scores[id] = (1 + math.Log(tf)) * idf
+	}
+	return scores
+}
+
+// positions returns the positions at which t starts in each document that contains it.
+func (ix *Index) positions(t term) map[string][]int {
+	first := ix.wordPositions(t.words[0], t.prefix && len(t.words) == 1)
+	if len(t.words) == 1 {
+		return first
+	}
+	result := make(map[string][]int)
+	for id, starts := range first {
+		for _, start := range starts {
+			found := true
+			for k := 1; k < len(t.words) && found; k++ {
+				found = contains(ix.postings[t.words[k]][id], start+k)
+			}
+			if found {
+				result[id] = append(result[id], start)
+			}
+		}
+	}
+	return result
+}
+
+func (ix *Index) wordPositions(word string, prefix bool) map[string][]int {
+	if !prefix {
+		return ix.postings[word]
+	}
+	result := make(map[string][]int)
+	for w, docs := range ix.postings {
+		if strings.HasPrefix(w, word) {
+			for id, pos := range docs {
+				result[id] = append(result[id], pos...)
+			}
+		}
+	}
+	return result
+}
+
+func contains(positions []int, p int) bool {
+	for _, q := range positions {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Save writes the indexed documents to w.
+func (ix *Index) Save(w io.Writer) error {
+	ix.mu.RLock()
+	docs := make([]*Document, 0, len(ix.docs))
+	for _, e := range ix.docs {
+		docs = append(docs, e.doc)
+	}
+	ix.mu.RUnlock()
+	if err := gob.NewEncoder(w).Encode(docs); err != nil {
+		return fmt.Errorf("failed to save search index: %w", err)
+	}
+	return nil
+}
+
+// Load reads an index written by Save.
+func Load(r io.Reader) (*Index, error) {
+	var docs []*Document
+	if err := gob.NewDecoder(r).Decode(&docs); err != nil {
+		return nil, fmt.Errorf("failed to load search index: %w", err)
+	}
+	ix := NewIndex()
+	for _, doc := range docs {
+		ix.Add(*doc)
+	}
+	return ix, nil
+}
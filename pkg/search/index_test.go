@@ -0,0 +1,105 @@
+package search
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func testIndex() *Index {
+	ix := NewIndex()
+	ix.Add(Document{ID: "a", Sections: []string{"syn"}, Author: "mhc", Time: now, Head: "Synchron strings", Body: "<p>The <b>legato</b> patches sound great.</p>"})
+	ix.Add(Document{ID: "b", Sections: []string{"syn", "brass"}, Author: "ella", Time: now.Add(time.Hour), Head: "Brass", Body: "Strings &amp; brass together, legato strings"})
+	ix.Add(Document{ID: "c", Sections: []string{"gen"}, Author: "ella", Time: now.Add(2 * time.Hour), Head: "Hello", Body: "<script>strings()</script>Nothing to see"})
+	return ix
+}
+
+func ids(hits []Hit) []string {
+	result := make([]string, len(hits))
+	for k, h := range hits {
+		result[k] = h.ID
+	}
+	return result
+}
+
+func TestStripHTML(t *testing.T) {
+	assert.Equal(t, " a  b  & c", StripHTML("<p>a</p><br/>b<script>x</script> &amp; c"))
+}
+
+func TestIndex_Search(t *testing.T) {
+	ix := testIndex()
+	hits, err := ix.Search(Query{Text: "strings"})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, ids(hits))
+
+	hits, err = ix.Search(Query{Text: "legato strings"})
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, ids(hits))
+
+	hits, err = ix.Search(Query{Text: `"legato strings"`})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"b"}, ids(hits))
+
+	hits, err = ix.Search(Query{Text: "synch*"})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"a"}, ids(hits))
+
+	_, err = ix.Search(Query{Text: `"unbalanced`})
+	assert.NotNil(t, err)
+}
+
+func TestIndex_SearchFilters(t *testing.T) {
+	ix := testIndex()
+	hits, err := ix.Search(Query{Text: "strings", Author: "ella"})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"b"}, ids(hits))
+
+	hits, err = ix.Search(Query{Text: "hello", Section: "syn"})
+	require.Nil(t, err)
+	assert.Empty(t, hits)
+
+	// A section's search includes its sub-sections.
+	hits, err = ix.Search(Query{Text: "legato", Section: "syn"})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, ids(hits))
+	hits, err = ix.Search(Query{Text: "legato", Section: "brass"})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"b"}, ids(hits))
+
+	hits, err = ix.Search(Query{Text: "strings", Since: now.Add(time.Minute)})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"b"}, ids(hits))
+
+	hits, err = ix.Search(Query{Text: "strings", Until: now.Add(time.Minute)})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"a"}, ids(hits))
+}
+
+func TestIndex_RemoveAndReplace(t *testing.T) {
+	ix := testIndex()
+	ix.Remove("a")
+	hits, err := ix.Search(Query{Text: "synchron"})
+	require.Nil(t, err)
+	assert.Empty(t, hits)
+
+	ix.Add(Document{ID: "b", Head: "Woodwinds", Body: "flutes"})
+	hits, err = ix.Search(Query{Text: "brass"})
+	require.Nil(t, err)
+	assert.Empty(t, hits)
+	assert.Equal(t, 2, ix.Len())
+}
+
+func TestIndex_SaveLoad(t *testing.T) {
+	var buf bytes.Buffer
+	require.Nil(t, testIndex().Save(&buf))
+	ix, err := Load(&buf)
+	require.Nil(t, err)
+	assert.Equal(t, 3, ix.Len())
+	hits, err := ix.Search(Query{Text: "brass"})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"b"}, ids(hits))
+}
@@ -0,0 +1,54 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Query describes a search. Every term in Text must match. A term ending in '*' matches
+// any word with that prefix, and words in double quotes must appear together in order.
+type Query struct {
+	Text    string
+	Section string    // If set, only posts in this section or its sub-sections
+	Author  string    // If set, only posts by this user ID
+	Since   time.Time // If set, only posts created at or after this time
+	Until   time.Time // If set, only posts created before this time
+}
+
+// term is a single word, a prefix, or a phrase.
+type term struct {
+	words  []string
+	prefix bool
+}
+
+// parse splits query text into terms.
+func parse(text string) ([]term, error) {
+	var terms []term
+	parts := strings.Split(text, `"`)
+	if len(parts)%2 == 0 {
+		return nil, fmt.Errorf("unbalanced quotes in %q", text)
+	}
+	for k, part := range parts {
+		if k%2 == 1 {
+			words := Tokenize(part)
+			if len(words) > 0 {
+				terms = append(terms, term{words: words})
+			}
+			continue
+		}
+		for _, field := range strings.Fields(part) {
+			words := Tokenize(field)
+			for _, word := range words {
+				terms = append(terms, term{words: []string{word}})
+			}
+			if len(words) > 0 && strings.HasSuffix(field, "*") {
+				terms[len(terms)-1].prefix = true
+			}
+		}
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+	return terms, nil
+}
@@ -0,0 +1,28 @@
+package search
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	scriptRe = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+	tagRe    = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// StripHTML removes tags from an HTML fragment and decodes entities.
+func StripHTML(s string) string {
+	s = scriptRe.ReplaceAllString(s, " ")
+	s = tagRe.ReplaceAllString(s, " ")
+	return html.UnescapeString(s)
+}
+
+// Tokenize splits text into lower-case words. Anything that is not a letter or digit
+// separates words.
+func Tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}